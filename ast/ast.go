@@ -1,8 +1,6 @@
 package ast
 
 import (
-	"math"
-
 	"github.com/gogim1/goscript/file"
 )
 
@@ -26,7 +24,7 @@ type NumberNode struct {
 }
 
 func NewNumberNode(sl file.SourceLocation, n, d int) *NumberNode {
-	g := gcd(int(math.Abs(float64(n))), d)
+	g := gcd(abs(n), d)
 	node := &NumberNode{
 		Base:        Base{Location: sl},
 		Numerator:   n / g,
@@ -35,6 +33,33 @@ func NewNumberNode(sl file.SourceLocation, n, d int) *NumberNode {
 	return node
 }
 
+// FloatNode is an inexact number, written with an exponent like `1.5e3`.
+type FloatNode struct {
+	Base
+	Value float64
+}
+
+func NewFloatNode(sl file.SourceLocation, v float64) *FloatNode {
+	node := &FloatNode{
+		Base:  Base{Location: sl},
+		Value: v,
+	}
+	return node
+}
+
+type BoolNode struct {
+	Base
+	Value bool
+}
+
+func NewBoolNode(sl file.SourceLocation, v bool) *BoolNode {
+	node := &BoolNode{
+		Base:  Base{Location: sl},
+		Value: v,
+	}
+	return node
+}
+
 type StringNode struct {
 	Base
 	Value string
@@ -84,26 +109,79 @@ func NewVariableNode(sl file.SourceLocation, n string, k ScopeKind) *VariableNod
 	return node
 }
 
+// LambdaNode is a function of the parameters of VarList. The last parameters are
+// optional when they have Defaults, which are evaluated in order and see the previous
+// parameters. Rest, if any, is bound to the list of the remaining arguments.
+//
+// Patterns is nil unless some parameters destructure their argument. It then has an
+// element for each parameter, which is either nil or a pattern of `match`, in which
+// case the variable of the parameter is the wildcard `_`.
 type LambdaNode struct {
 	Base
-	VarList []*VariableNode
-	Expr    ExprNode
+	VarList  []*VariableNode
+	Patterns []ExprNode
+	Defaults []ExprNode
+	Rest     *VariableNode
+	Expr     ExprNode
 }
 
 func NewLambdaNode(sl file.SourceLocation, vl []*VariableNode, e ExprNode) *LambdaNode {
+	return NewLambdaNodeWithParams(sl, vl, LambdaParams{}, e)
+}
+
+// LambdaParams are the optional parts of the parameters of a lambda, as in LambdaNode.
+type LambdaParams struct {
+	Patterns []ExprNode
+	Defaults []ExprNode
+	Rest     *VariableNode
+}
+
+// NewLambdaNodeWithParams returns a lambda whose parameters may be patterns, have
+// defaults or end with a rest parameter.
+func NewLambdaNodeWithParams(sl file.SourceLocation, vl []*VariableNode, p LambdaParams, e ExprNode) *LambdaNode {
 	node := &LambdaNode{
-		Base:    Base{Location: sl},
-		VarList: vl,
-		Expr:    e,
+		Base:     Base{Location: sl},
+		VarList:  vl,
+		Patterns: p.Patterns,
+		Defaults: p.Defaults,
+		Rest:     p.Rest,
+		Expr:     e,
 	}
 	return node
 }
 
+// Param returns the i-th parameter, which is either a variable or a pattern.
+func (n *LambdaNode) Param(i int) ExprNode {
+	if n.Patterns != nil && n.Patterns[i] != nil {
+		return n.Patterns[i]
+	}
+	return n.VarList[i]
+}
+
+// Default returns the default expression of the i-th parameter, or nil if it is required.
+func (n *LambdaNode) Default(i int) ExprNode {
+	if i -= len(n.VarList) - len(n.Defaults); i >= 0 {
+		return n.Defaults[i]
+	}
+	return nil
+}
+
+// LetrecVarExprItem binds either a variable or, when Pattern is not nil, the
+// variables of a pattern of `match` destructuring the value of its expression.
 type LetrecVarExprItem struct {
 	Variable *VariableNode
+	Pattern  ExprNode
 	Expr     ExprNode
 }
 
+// Target returns the variable or the pattern bound by the item.
+func (ve *LetrecVarExprItem) Target() ExprNode {
+	if ve.Pattern != nil {
+		return ve.Pattern
+	}
+	return ve.Variable
+}
+
 type LetrecNode struct {
 	Base
 	VarExprList []*LetrecVarExprItem
@@ -119,6 +197,45 @@ func NewLetrecNode(sl file.SourceLocation, vel []*LetrecVarExprItem, e ExprNode)
 	return node
 }
 
+// LetNode binds its variables for its expression only. The expressions of a parallel
+// `let` see none of the variables, while those of a sequential `let*` see the previous
+// ones.
+type LetNode struct {
+	Base
+	Sequential  bool
+	VarExprList []*LetrecVarExprItem
+	Expr        ExprNode
+}
+
+func NewLetNode(sl file.SourceLocation, seq bool, vel []*LetrecVarExprItem, e ExprNode) *LetNode {
+	node := &LetNode{
+		Base:        Base{Location: sl},
+		Sequential:  seq,
+		VarExprList: vel,
+		Expr:        e,
+	}
+	return node
+}
+
+// LoopNode calls Fun with the values of Inits, in tail position. The body of Fun sees
+// Name bound to Fun itself, so that calling it starts the next iteration.
+type LoopNode struct {
+	Base
+	Name  *VariableNode
+	Inits []ExprNode
+	Fun   *LambdaNode
+}
+
+func NewLoopNode(sl file.SourceLocation, n *VariableNode, i []ExprNode, f *LambdaNode) *LoopNode {
+	node := &LoopNode{
+		Base:  Base{Location: sl},
+		Name:  n,
+		Inits: i,
+		Fun:   f,
+	}
+	return node
+}
+
 type IfNode struct {
 	Base
 	Cond    ExprNode
@@ -178,3 +295,171 @@ func NewAccessNode(sl file.SourceLocation, v *VariableNode, e ExprNode) *AccessN
 	}
 	return node
 }
+
+// QuoteNode is a quasiquotation, which evaluates to its expression as code.
+type QuoteNode struct {
+	Base
+	Expr ExprNode
+}
+
+func NewQuoteNode(sl file.SourceLocation, e ExprNode) *QuoteNode {
+	node := &QuoteNode{
+		Base: Base{Location: sl},
+		Expr: e,
+	}
+	return node
+}
+
+// UnquoteNode is evaluated when the innermost enclosing quasiquotation is, and
+// its value replaces it. A splicing unquote replaces it with the elements of a
+// sequence.
+type UnquoteNode struct {
+	Base
+	Expr     ExprNode
+	Splicing bool
+}
+
+func NewUnquoteNode(sl file.SourceLocation, e ExprNode, splicing bool) *UnquoteNode {
+	node := &UnquoteNode{
+		Base:     Base{Location: sl},
+		Expr:     e,
+		Splicing: splicing,
+	}
+	return node
+}
+
+// RestNode is a `@name` element of a macro pattern or template, which stands for
+// the remaining elements of a sequence or an argument list. In a list pattern of
+// `match`, it binds the remaining elements of the list.
+type RestNode struct {
+	Base
+	Name string
+}
+
+func NewRestNode(sl file.SourceLocation, n string) *RestNode {
+	node := &RestNode{
+		Base: Base{Location: sl},
+		Name: n,
+	}
+	return node
+}
+
+type MacroRule struct {
+	Name     *VariableNode
+	Pattern  []ExprNode
+	Template ExprNode
+}
+
+// MacroNode defines macros for its expression. It is replaced by the expansion of
+// its expression before execution.
+type MacroNode struct {
+	Base
+	Rules []*MacroRule
+	Expr  ExprNode
+}
+
+func NewMacroNode(sl file.SourceLocation, r []*MacroRule, e ExprNode) *MacroNode {
+	node := &MacroNode{
+		Base:  Base{Location: sl},
+		Rules: r,
+		Expr:  e,
+	}
+	return node
+}
+
+type RecordField struct {
+	Name *VariableNode
+	Expr ExprNode
+}
+
+// RecordNode is either a `{name = expr ...}` record, a `{expr | name = expr ...}`
+// update of the record its expression evaluates to, or a `{name = pattern ...}`
+// pattern of `match`. Expr is nil unless it is an update.
+type RecordNode struct {
+	Base
+	Expr   ExprNode
+	Fields []*RecordField
+}
+
+func NewRecordNode(sl file.SourceLocation, e ExprNode, f []*RecordField) *RecordNode {
+	node := &RecordNode{
+		Base:   Base{Location: sl},
+		Expr:   e,
+		Fields: f,
+	}
+	return node
+}
+
+type MatchCase struct {
+	Pattern ExprNode
+	Guard   ExprNode
+	Expr    ExprNode
+}
+
+// MatchNode evaluates the expression of the first case whose pattern matches the value
+// of its expression and whose guard, if any, holds.
+type MatchNode struct {
+	Base
+	Expr  ExprNode
+	Cases []*MatchCase
+}
+
+func NewMatchNode(sl file.SourceLocation, e ExprNode, c []*MatchCase) *MatchNode {
+	node := &MatchNode{
+		Base:  Base{Location: sl},
+		Expr:  e,
+		Cases: c,
+	}
+	return node
+}
+
+// DataVariant is a variant of a data type. Predicate is the name bound to the
+// predicate testing whether a value was built by the variant.
+type DataVariant struct {
+	Name      *VariableNode
+	Predicate *VariableNode
+	Fields    []*VariableNode
+}
+
+// DataNode declares a data type for its expression, binding the constructor and
+// the predicate of every variant, and the predicate of the type.
+type DataNode struct {
+	Base
+	Name      *VariableNode
+	Predicate *VariableNode
+	Variants  []*DataVariant
+	Expr      ExprNode
+}
+
+func NewDataNode(sl file.SourceLocation, n *VariableNode, p *VariableNode, v []*DataVariant, e ExprNode) *DataNode {
+	node := &DataNode{
+		Base:      Base{Location: sl},
+		Name:      n,
+		Predicate: p,
+		Variants:  v,
+		Expr:      e,
+	}
+	return node
+}
+
+type CondCase struct {
+	Cond ExprNode
+	Expr ExprNode
+}
+
+// CondNode evaluates the expression of the first case whose condition holds, or its
+// else expression if none does. Else is nil when omitted.
+type CondNode struct {
+	Base
+	Cases []*CondCase
+	Else  ExprNode
+}
+
+func NewCondNode(sl file.SourceLocation, c []*CondCase, e ExprNode) *CondNode {
+	node := &CondNode{
+		Base:  Base{Location: sl},
+		Cases: c,
+		Else:  e,
+	}
+	return node
+}
@@ -0,0 +1,264 @@
+package ast
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Format renders a node as source code that parses back to the same tree.
+func Format(node ExprNode) string {
+	b := &strings.Builder{}
+	format(b, node)
+	return b.String()
+}
+
+// FormatFloat prints f as the shortest float literal that reads back as f. NaN and
+// infinities have no literal and print as `nan`, `+inf` and `-inf`, which only `tonum`
+// reads back.
+func FormatFloat(f float64) string {
+	switch {
+	case math.IsNaN(f):
+		return "nan"
+	case math.IsInf(f, 1):
+		return "+inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	}
+	mantissa, exp, ok := strings.Cut(strconv.FormatFloat(f, 'g', -1, 64), "e")
+	if !ok {
+		return mantissa + "e0"
+	}
+	e, _ := strconv.Atoi(exp)
+	return mantissa + "e" + strconv.Itoa(e)
+}
+
+func format(b *strings.Builder, node ExprNode) {
+	switch n := node.(type) {
+	case *NumberNode:
+		b.WriteString(strconv.Itoa(n.Numerator))
+		if n.Denominator != 1 {
+			b.WriteString("/" + strconv.Itoa(n.Denominator))
+		}
+	case *FloatNode:
+		b.WriteString(FormatFloat(n.Value))
+	case *BoolNode:
+		b.WriteString(strconv.FormatBool(n.Value))
+	case *StringNode:
+		b.WriteString(`"`)
+		for _, char := range n.Value {
+			switch char {
+			case '\\':
+				b.WriteString(`\\`)
+			case '"':
+				b.WriteString(`\"`)
+			case '\t':
+				b.WriteString(`\t`)
+			case '\n':
+				b.WriteString(`\n`)
+			default:
+				b.WriteRune(char)
+			}
+		}
+		b.WriteString(`"`)
+	case *IntrinsicNode:
+		b.WriteString(n.Name)
+	case *VariableNode:
+		b.WriteString(n.Name)
+	case *LambdaNode:
+		b.WriteString("lambda (")
+		for i := range n.VarList {
+			if i != 0 {
+				b.WriteString(" ")
+			}
+			format(b, n.Param(i))
+			if d := n.Default(i); d != nil {
+				b.WriteString(" = ")
+				format(b, d)
+			}
+		}
+		if n.Rest != nil {
+			if len(n.VarList) != 0 {
+				b.WriteString(" ")
+			}
+			b.WriteString("@" + n.Rest.Name)
+		}
+		b.WriteString(") { ")
+		format(b, n.Expr)
+		b.WriteString(" }")
+	case *LetrecNode:
+		b.WriteString("letrec ")
+		formatBindings(b, n.VarExprList)
+		b.WriteString(" { ")
+		format(b, n.Expr)
+		b.WriteString(" }")
+	case *LetNode:
+		if n.Sequential {
+			b.WriteString("let* ")
+		} else {
+			b.WriteString("let ")
+		}
+		formatBindings(b, n.VarExprList)
+		b.WriteString(" { ")
+		format(b, n.Expr)
+		b.WriteString(" }")
+	case *LoopNode:
+		b.WriteString("loop ")
+		if n.Name.Name != "recur" {
+			b.WriteString(n.Name.Name + " ")
+		}
+		b.WriteString("(")
+		for i, init := range n.Inits {
+			if i != 0 {
+				b.WriteString(" ")
+			}
+			format(b, n.Fun.Param(i))
+			b.WriteString(" = ")
+			format(b, init)
+		}
+		b.WriteString(") { ")
+		format(b, n.Fun.Expr)
+		b.WriteString(" }")
+	case *IfNode:
+		b.WriteString("if ")
+		format(b, n.Cond)
+		b.WriteString(" then ")
+		format(b, n.Branch1)
+		b.WriteString(" else ")
+		format(b, n.Branch2)
+	case *CallNode:
+		b.WriteString("(")
+		format(b, n.Callee)
+		for _, arg := range n.ArgList {
+			b.WriteString(" ")
+			format(b, arg)
+		}
+		b.WriteString(")")
+	case *SequenceNode:
+		b.WriteString("[")
+		for i, expr := range n.ExprList {
+			if i != 0 {
+				b.WriteString(" ")
+			}
+			format(b, expr)
+		}
+		b.WriteString("]")
+	case *AccessNode:
+		b.WriteString("&")
+		format(b, n.Variable)
+		b.WriteString(" ")
+		format(b, n.Expr)
+	case *QuoteNode:
+		b.WriteString("`")
+		format(b, n.Expr)
+	case *UnquoteNode:
+		b.WriteString(",")
+		if n.Splicing {
+			b.WriteString("@")
+		}
+		format(b, n.Expr)
+	case *RestNode:
+		b.WriteString("@" + n.Name)
+	case *MacroNode:
+		b.WriteString("macro (")
+		for i, rule := range n.Rules {
+			if i != 0 {
+				b.WriteString(" ")
+			}
+			format(b, rule.Name)
+			b.WriteString(" (")
+			for j, p := range rule.Pattern {
+				if j != 0 {
+					b.WriteString(" ")
+				}
+				format(b, p)
+			}
+			b.WriteString(") { ")
+			format(b, rule.Template)
+			b.WriteString(" }")
+		}
+		b.WriteString(") { ")
+		format(b, n.Expr)
+		b.WriteString(" }")
+	case *RecordNode:
+		b.WriteString("{")
+		if n.Expr != nil {
+			format(b, n.Expr)
+			b.WriteString(" |")
+			if len(n.Fields) != 0 {
+				b.WriteString(" ")
+			}
+		}
+		for i, f := range n.Fields {
+			if i != 0 {
+				b.WriteString(" ")
+			}
+			format(b, f.Name)
+			b.WriteString(" = ")
+			format(b, f.Expr)
+		}
+		b.WriteString("}")
+	case *MatchNode:
+		b.WriteString("match ")
+		format(b, n.Expr)
+		b.WriteString(" {")
+		for _, c := range n.Cases {
+			b.WriteString(" ")
+			format(b, c.Pattern)
+			if c.Guard != nil {
+				b.WriteString(" if ")
+				format(b, c.Guard)
+			}
+			b.WriteString(" then ")
+			format(b, c.Expr)
+		}
+		b.WriteString(" }")
+	case *CondNode:
+		b.WriteString("cond {")
+		for _, c := range n.Cases {
+			b.WriteString(" ")
+			format(b, c.Cond)
+			b.WriteString(" then ")
+			format(b, c.Expr)
+		}
+		if n.Else != nil {
+			b.WriteString(" else ")
+			format(b, n.Else)
+		}
+		b.WriteString(" }")
+	case *DataNode:
+		b.WriteString("data ")
+		format(b, n.Name)
+		b.WriteString(" (")
+		for i, v := range n.Variants {
+			if i != 0 {
+				b.WriteString(" ")
+			}
+			format(b, v.Name)
+			b.WriteString(" (")
+			for j, f := range v.Fields {
+				if j != 0 {
+					b.WriteString(" ")
+				}
+				format(b, f)
+			}
+			b.WriteString(")")
+		}
+		b.WriteString(") { ")
+		format(b, n.Expr)
+		b.WriteString(" }")
+	}
+}
+
+func formatBindings(b *strings.Builder, varExprList []*LetrecVarExprItem) {
+	b.WriteString("(")
+	for i, ve := range varExprList {
+		if i != 0 {
+			b.WriteString(" ")
+		}
+		format(b, ve.Target())
+		b.WriteString(" = ")
+		format(b, ve.Expr)
+	}
+	b.WriteString(")")
+}
@@ -0,0 +1,58 @@
+package ast
+
+import (
+	"testing"
+
+	"github.com/gogim1/goscript/file"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormat(t *testing.T) {
+	sl := file.SourceLocation{Line: 1, Col: 1}
+	a := NewVariableNode(sl, "a", Lexical)
+	half := NewNumberNode(sl, -1, 2)
+	put := NewIntrinsicNode(sl, "put")
+	call := NewCallNode(sl, put, []ExprNode{a, NewStringNode(sl, "\"str\"\t\\\n")})
+	lambda := NewLambdaNodeWithParams(sl, []*VariableNode{a, a}, LambdaParams{Defaults: []ExprNode{half}, Rest: a}, NewSequenceNode(sl, []ExprNode{call, half}))
+	quote := NewQuoteNode(sl, NewCallNode(sl, a, []ExprNode{NewUnquoteNode(sl, a, false), NewUnquoteNode(sl, a, true)}))
+	letrec := NewLetrecNode(sl, []*LetrecVarExprItem{{Variable: a, Expr: lambda}}, NewIfNode(sl, half, quote, NewAccessNode(sl, a, a)))
+
+	assert.Equal(t, "letrec (a = lambda (a a = -1/2 @a) { [(put a \"\\\"str\\\"\\t\\\\\\n\") -1/2] }) { if -1/2 then `(a ,a ,@a) else &a a }", Format(letrec))
+
+	wildcard := NewVariableNode(sl, "_", Lexical)
+	list := NewSequenceNode(sl, []ExprNode{wildcard, NewRestNode(sl, "a")})
+	record := NewRecordNode(sl, nil, []*RecordField{{Name: a, Expr: half}})
+	match := NewMatchNode(sl, a, []*MatchCase{{Pattern: list, Guard: a, Expr: half}, {Pattern: record, Expr: a}})
+	assert.Equal(t, "match a { [_ @a] if a then -1/2 {a = -1/2} then a }", Format(match))
+
+	params := NewLambdaNodeWithParams(sl, []*VariableNode{wildcard, a}, LambdaParams{Patterns: []ExprNode{record, nil}}, a)
+	destructuring := NewLetrecNode(sl, []*LetrecVarExprItem{{Pattern: list, Expr: a}}, params)
+	assert.Equal(t, "letrec ([_ @a] = a) { lambda ({a = -1/2} a) { a } }", Format(destructuring))
+
+	let := NewLetNode(sl, false, []*LetrecVarExprItem{{Variable: a, Expr: half}, {Pattern: list, Expr: a}}, a)
+	assert.Equal(t, "let (a = -1/2 [_ @a] = a) { a }", Format(let))
+	assert.Equal(t, "let* () { a }", Format(NewLetNode(sl, true, nil, a)))
+
+	fun := NewLambdaNodeWithParams(sl, []*VariableNode{a, wildcard}, LambdaParams{Patterns: []ExprNode{nil, record}}, a)
+	assert.Equal(t, "loop (a = -1/2 {a = -1/2} = a) { a }", Format(NewLoopNode(sl, NewVariableNode(sl, "recur", Lexical), []ExprNode{half, a}, fun)))
+	assert.Equal(t, "loop a (a = -1/2 {a = -1/2} = a) { a }", Format(NewLoopNode(sl, a, []ExprNode{half, a}, fun)))
+
+	variants := []*DataVariant{{Name: a, Predicate: a, Fields: []*VariableNode{a, a}}, {Name: a, Predicate: a}}
+	data := NewDataNode(sl, a, a, variants, NewCallNode(sl, a, []ExprNode{a}))
+	assert.Equal(t, "data a (a (a a) a ()) { (a a) }", Format(data))
+
+	update := NewRecordNode(sl, a, []*RecordField{{Name: a, Expr: half}, {Name: a, Expr: a}})
+	assert.Equal(t, "{a | a = -1/2 a = a}", Format(update))
+	assert.Equal(t, "{a |}", Format(NewRecordNode(sl, a, []*RecordField{})))
+
+	cond := NewCondNode(sl, []*CondCase{{Cond: a, Expr: half}, {Cond: half, Expr: a}}, nil)
+	assert.Equal(t, "cond { a then -1/2 -1/2 then a }", Format(cond))
+	assert.Equal(t, "cond { a then -1/2 else a }", Format(NewCondNode(sl, cond.Cases[:1], a)))
+
+	floats := []ExprNode{}
+	for _, f := range []float64{1500, 0.1, -2.5, 1e21, 1.5e-7, 0} {
+		floats = append(floats, NewFloatNode(sl, f))
+	}
+	assert.Equal(t, "[1500e0 0.1e0 -2.5e0 1e21 1.5e-7 0e0]", Format(NewSequenceNode(sl, floats)))
+	assert.Equal(t, "if true then false else a", Format(NewIfNode(sl, NewBoolNode(sl, true), NewBoolNode(sl, false), a)))
+}
@@ -6,3 +6,10 @@ func gcd(x, y int) int {
 	}
 	return x
 }
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
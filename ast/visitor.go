@@ -4,21 +4,41 @@ import "github.com/gogim1/goscript/file"
 
 type Visitor interface {
 	VisitNumberNode(*NumberNode) *file.Error
+	VisitFloatNode(*FloatNode) *file.Error
+	VisitBoolNode(*BoolNode) *file.Error
 	VisitStringNode(*StringNode) *file.Error
 	VisitIntrinsicNode(*IntrinsicNode) *file.Error
 	VisitVariableNode(*VariableNode) *file.Error
 	VisitLambdaNode(*LambdaNode) *file.Error
 	VisitLetrecNode(*LetrecNode) *file.Error
+	VisitLetNode(*LetNode) *file.Error
+	VisitLoopNode(*LoopNode) *file.Error
 	VisitIfNode(*IfNode) *file.Error
 	VisitCallNode(*CallNode) *file.Error
 	VisitSequenceNode(*SequenceNode) *file.Error
 	VisitAccessNode(*AccessNode) *file.Error
+	VisitQuoteNode(*QuoteNode) *file.Error
+	VisitUnquoteNode(*UnquoteNode) *file.Error
+	VisitRestNode(*RestNode) *file.Error
+	VisitMacroNode(*MacroNode) *file.Error
+	VisitRecordNode(*RecordNode) *file.Error
+	VisitMatchNode(*MatchNode) *file.Error
+	VisitDataNode(*DataNode) *file.Error
+	VisitCondNode(*CondNode) *file.Error
 }
 
 func (n *NumberNode) Accept(v Visitor) *file.Error {
 	return v.VisitNumberNode(n)
 }
 
+func (n *FloatNode) Accept(v Visitor) *file.Error {
+	return v.VisitFloatNode(n)
+}
+
+func (n *BoolNode) Accept(v Visitor) *file.Error {
+	return v.VisitBoolNode(n)
+}
+
 func (n *StringNode) Accept(v Visitor) *file.Error {
 	return v.VisitStringNode(n)
 }
@@ -39,6 +59,14 @@ func (n *LetrecNode) Accept(v Visitor) *file.Error {
 	return v.VisitLetrecNode(n)
 }
 
+func (n *LetNode) Accept(v Visitor) *file.Error {
+	return v.VisitLetNode(n)
+}
+
+func (n *LoopNode) Accept(v Visitor) *file.Error {
+	return v.VisitLoopNode(n)
+}
+
 func (n *IfNode) Accept(v Visitor) *file.Error {
 	return v.VisitIfNode(n)
 }
@@ -54,3 +82,35 @@ func (n *SequenceNode) Accept(v Visitor) *file.Error {
 func (n *AccessNode) Accept(v Visitor) *file.Error {
 	return v.VisitAccessNode(n)
 }
+
+func (n *QuoteNode) Accept(v Visitor) *file.Error {
+	return v.VisitQuoteNode(n)
+}
+
+func (n *UnquoteNode) Accept(v Visitor) *file.Error {
+	return v.VisitUnquoteNode(n)
+}
+
+func (n *RestNode) Accept(v Visitor) *file.Error {
+	return v.VisitRestNode(n)
+}
+
+func (n *MacroNode) Accept(v Visitor) *file.Error {
+	return v.VisitMacroNode(n)
+}
+
+func (n *RecordNode) Accept(v Visitor) *file.Error {
+	return v.VisitRecordNode(n)
+}
+
+func (n *MatchNode) Accept(v Visitor) *file.Error {
+	return v.VisitMatchNode(n)
+}
+
+func (n *DataNode) Accept(v Visitor) *file.Error {
+	return v.VisitDataNode(n)
+}
+
+func (n *CondNode) Accept(v Visitor) *file.Error {
+	return v.VisitCondNode(n)
+}
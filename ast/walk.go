@@ -0,0 +1,139 @@
+package ast
+
+import "unicode"
+
+// Walk calls fn for node and then for every node below it, in depth-first order.
+func Walk(node ExprNode, fn func(ExprNode)) {
+	fn(node)
+	switch n := node.(type) {
+	case *LambdaNode:
+		for i := range n.VarList {
+			Walk(n.Param(i), fn)
+			if d := n.Default(i); d != nil {
+				Walk(d, fn)
+			}
+		}
+		if n.Rest != nil {
+			Walk(n.Rest, fn)
+		}
+		Walk(n.Expr, fn)
+	case *LetrecNode:
+		for _, ve := range n.VarExprList {
+			Walk(ve.Target(), fn)
+			Walk(ve.Expr, fn)
+		}
+		Walk(n.Expr, fn)
+	case *LetNode:
+		for _, ve := range n.VarExprList {
+			Walk(ve.Target(), fn)
+			Walk(ve.Expr, fn)
+		}
+		Walk(n.Expr, fn)
+	case *LoopNode:
+		Walk(n.Name, fn)
+		for _, init := range n.Inits {
+			Walk(init, fn)
+		}
+		Walk(n.Fun, fn)
+	case *IfNode:
+		Walk(n.Cond, fn)
+		Walk(n.Branch1, fn)
+		Walk(n.Branch2, fn)
+	case *CallNode:
+		Walk(n.Callee, fn)
+		for _, arg := range n.ArgList {
+			Walk(arg, fn)
+		}
+	case *SequenceNode:
+		for _, expr := range n.ExprList {
+			Walk(expr, fn)
+		}
+	case *AccessNode:
+		Walk(n.Variable, fn)
+		Walk(n.Expr, fn)
+	case *QuoteNode:
+		Walk(n.Expr, fn)
+	case *UnquoteNode:
+		Walk(n.Expr, fn)
+	case *MacroNode:
+		for _, rule := range n.Rules {
+			Walk(rule.Name, fn)
+			for _, p := range rule.Pattern {
+				Walk(p, fn)
+			}
+			Walk(rule.Template, fn)
+		}
+		Walk(n.Expr, fn)
+	case *RecordNode:
+		if n.Expr != nil {
+			Walk(n.Expr, fn)
+		}
+		for _, f := range n.Fields {
+			Walk(f.Name, fn)
+			Walk(f.Expr, fn)
+		}
+	case *MatchNode:
+		Walk(n.Expr, fn)
+		for _, c := range n.Cases {
+			Walk(c.Pattern, fn)
+			if c.Guard != nil {
+				Walk(c.Guard, fn)
+			}
+			Walk(c.Expr, fn)
+		}
+	case *CondNode:
+		for _, c := range n.Cases {
+			Walk(c.Cond, fn)
+			Walk(c.Expr, fn)
+		}
+		if n.Else != nil {
+			Walk(n.Else, fn)
+		}
+	case *DataNode:
+		Walk(n.Name, fn)
+		Walk(n.Predicate, fn)
+		for _, v := range n.Variants {
+			Walk(v.Name, fn)
+			Walk(v.Predicate, fn)
+			for _, f := range v.Fields {
+				Walk(f, fn)
+			}
+		}
+		Walk(n.Expr, fn)
+	}
+}
+
+// PatternVariables returns the variables bound by a pattern of `match`, in source order.
+func PatternVariables(pattern ExprNode) []*VariableNode {
+	switch p := pattern.(type) {
+	case *VariableNode:
+		if p.Name != "_" {
+			return []*VariableNode{p}
+		}
+	case *RestNode:
+		kind := Lexical
+		if unicode.IsUpper([]rune(p.Name)[0]) {
+			kind = Dynamic
+		}
+		return []*VariableNode{NewVariableNode(p.Location, p.Name, kind)}
+	case *SequenceNode:
+		ret := []*VariableNode{}
+		for _, element := range p.ExprList {
+			ret = append(ret, PatternVariables(element)...)
+		}
+		return ret
+	case *RecordNode:
+		ret := []*VariableNode{}
+		for _, f := range p.Fields {
+			ret = append(ret, PatternVariables(f.Expr)...)
+		}
+		return ret
+	case *CallNode:
+		ret := []*VariableNode{}
+		for _, arg := range p.ArgList {
+			ret = append(ret, PatternVariables(arg)...)
+		}
+		return ret
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package ast
+
+import (
+	"testing"
+
+	"github.com/gogim1/goscript/file"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWalk(t *testing.T) {
+	sl := file.SourceLocation{Line: 1, Col: 1}
+	a := NewVariableNode(sl, "a", Lexical)
+	one := NewNumberNode(sl, 1, 1)
+	put := NewIntrinsicNode(sl, "put")
+	call := NewCallNode(sl, put, []ExprNode{a, NewStringNode(sl, "str")})
+	lambda := NewLambdaNode(sl, []*VariableNode{a}, NewSequenceNode(sl, []ExprNode{call, one}))
+	letrec := NewLetrecNode(sl, []*LetrecVarExprItem{{Variable: a, Expr: lambda}}, NewIfNode(sl, one, one, NewAccessNode(sl, a, a)))
+
+	kinds := []string{}
+	Walk(letrec, func(node ExprNode) {
+		switch node.(type) {
+		case *NumberNode:
+			kinds = append(kinds, "number")
+		case *StringNode:
+			kinds = append(kinds, "string")
+		case *IntrinsicNode:
+			kinds = append(kinds, "intrinsic")
+		case *VariableNode:
+			kinds = append(kinds, "variable")
+		case *LambdaNode:
+			kinds = append(kinds, "lambda")
+		case *LetrecNode:
+			kinds = append(kinds, "letrec")
+		case *IfNode:
+			kinds = append(kinds, "if")
+		case *CallNode:
+			kinds = append(kinds, "call")
+		case *SequenceNode:
+			kinds = append(kinds, "sequence")
+		case *AccessNode:
+			kinds = append(kinds, "access")
+		}
+	})
+	assert.Equal(t, []string{
+		"letrec", "variable", "lambda", "variable", "sequence", "call", "intrinsic", "variable", "string", "number",
+		"if", "number", "number", "access", "variable", "variable",
+	}, kinds)
+}
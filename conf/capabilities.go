@@ -0,0 +1,55 @@
+package conf
+
+// Capabilities restricts the intrinsics and FFI functions a program may call.
+// A nil predicate, like a nil *Capabilities, allows every name. Methods of host
+// objects are checked by FFI as "<type>.<method>".
+type Capabilities struct {
+	Intrinsic func(name string) bool
+	FFI       func(name string) bool
+}
+
+func (c *Capabilities) AllowsIntrinsic(name string) bool {
+	return c == nil || c.Intrinsic == nil || c.Intrinsic(name)
+}
+
+func (c *Capabilities) AllowsFFI(name string) bool {
+	return c == nil || c.FFI == nil || c.FFI(name)
+}
+
+// Narrow returns capabilities that only allow what both c and other allow.
+func (c *Capabilities) Narrow(other *Capabilities) *Capabilities {
+	if c == nil {
+		return other
+	}
+	if other == nil {
+		return c
+	}
+	return &Capabilities{
+		Intrinsic: func(name string) bool {
+			return c.AllowsIntrinsic(name) && other.AllowsIntrinsic(name)
+		},
+		FFI: func(name string) bool {
+			return c.AllowsFFI(name) && other.AllowsFFI(name)
+		},
+	}
+}
+
+// Only builds a predicate that allows the given names.
+func Only(names ...string) func(string) bool {
+	set := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		set[name] = struct{}{}
+	}
+	return func(name string) bool {
+		_, ok := set[name]
+		return ok
+	}
+}
+
+// Except builds a predicate that allows every name but the given ones.
+func Except(names ...string) func(string) bool {
+	only := Only(names...)
+	return func(name string) bool {
+		return !only(name)
+	}
+}
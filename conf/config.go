@@ -5,6 +5,14 @@ type Config struct {
 	EnableTCO   bool
 	EnableDebug bool
 	UseStd      bool
+	// NumericConditions also accepts numbers where a bool is expected, nonzero
+	// numbers being true, for programs written before bools were introduced.
+	NumericConditions bool
+	// Capabilities restricts what programs may call, nil allows everything.
+	Capabilities *Capabilities
+	// EvalCapabilities further narrows Capabilities for code run through `eval` and
+	// `evalin`.
+	EvalCapabilities *Capabilities
 }
 
 func New(opts ...Option) *Config {
@@ -19,6 +27,13 @@ func New(opts ...Option) *Config {
 	return c
 }
 
+// Eval returns the configuration of code run through `eval` and `evalin`.
+func (c *Config) Eval() *Config {
+	eval := *c
+	eval.Capabilities = c.Capabilities.Narrow(c.EvalCapabilities)
+	return &eval
+}
+
 type Option func(c *Config)
 
 func UseStd(use bool) Option {
@@ -39,8 +54,26 @@ func EnableTCO(enable bool) Option {
 	}
 }
 
+func EnableNumericConditions(enable bool) Option {
+	return func(c *Config) {
+		c.NumericConditions = enable
+	}
+}
+
 func SetGCTrigger(trigger func() bool) Option {
 	return func(c *Config) {
 		c.GCTrigger = trigger
 	}
 }
+
+func SetCapabilities(capabilities *Capabilities) Option {
+	return func(c *Config) {
+		c.Capabilities = capabilities
+	}
+}
+
+func SetEvalCapabilities(capabilities *Capabilities) Option {
+	return func(c *Config) {
+		c.EvalCapabilities = capabilities
+	}
+}
@@ -1,9 +1,9 @@
 letrec (
   leaf = lambda () {
-    lambda () { 0 }
+    lambda () { false }
   }
   node = lambda (value left right) {
-    lambda () { 1 }
+    lambda () { true }
   }
   dfs = lambda (tree) {
     if (not (tree)) then (void)
@@ -0,0 +1,17 @@
+data shape (circle (radius) rect (width height)) {
+  letrec (
+    area = lambda (s) {
+      match s {
+        (circle r) then (mul 3 (mul r r))
+        (rect w h) then (mul w h)
+      }
+    }
+    shapes = (mklist (circle 2) (rect 3 4))
+  ) {[
+    (put (listget shapes 0) " has area " (area (listget shapes 0)) "\n")
+    (put (listget shapes 1) " has area " (area (listget shapes 1)) "\n")
+    (put "width " &width (listget shapes 1) "\n")
+    (put (isshape (circle 1)) " " (iscircle (rect 1 1)) " " (isshape 1) "\n")
+    (put (eq (rect 1 2) (rect 1 2)) "\n")
+  ]}
+}
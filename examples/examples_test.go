@@ -8,6 +8,7 @@ import (
 	"github.com/gogim1/goscript/examples"
 	"github.com/gogim1/goscript/file"
 	"github.com/gogim1/goscript/lexer"
+	"github.com/gogim1/goscript/macro"
 	"github.com/gogim1/goscript/parser"
 	"github.com/gogim1/goscript/runtime"
 )
@@ -28,6 +29,11 @@ func run(filepath string, conf *conf.Config) error {
 		return err
 	}
 
+	node, err = macro.Expand(node)
+	if err != nil {
+		return err
+	}
+
 	state := runtime.NewState(node, conf)
 	if err = state.Execute(); err != nil {
 		return err
@@ -106,6 +112,64 @@ func Example_list() {
 	// 1
 }
 
+func Example_macros() {
+	err := run("./macros.gs", gConf)
+	if err != nil {
+		fmt.Printf("err: %v", err)
+		return
+	}
+
+	// Output:
+	// x < y
+	// 3
+}
+
+func Example_match() {
+	err := run("./match.gs", gConf)
+	if err != nil {
+		fmt.Printf("err: %v", err)
+		return
+	}
+
+	// Output:
+	// void
+	// zero
+	// negative
+	// just 5
+	// just a list starting with 1
+	// pair of a and b
+	// something else
+}
+
+func Example_data() {
+	err := run("./data.gs", gConf)
+	if err != nil {
+		fmt.Printf("err: %v", err)
+		return
+	}
+
+	// Output:
+	// (circle 2) has area 12
+	// (rect 3 4) has area 12
+	// width 3
+	// true false false
+	// true
+}
+
+func Example_records() {
+	err := run("./records.gs", gConf)
+	if err != nil {
+		fmt.Printf("err: %v", err)
+		return
+	}
+
+	// Output:
+	// {x = 0 y = 0} {x = 4 y = 6}
+	// x = 4
+	// y = 0
+	// true
+}
+
 func Example_multi_stage() {
 	err := run("./multi-stage.gs", gConf)
 	if err != nil {
@@ -117,6 +181,8 @@ func Example_multi_stage() {
 	// EVAL
 	// hello world
 	// hello world
+	// <code (mul x (mul x (mul x 1)))>
+	// 8
 }
 
 func Example_mutual_recursion() {
@@ -202,3 +268,20 @@ func Example_call_golang() {
 	// Output:
 	// hello world
 }
+
+func Example_embed() {
+	examples.Embed()
+
+	// Output:
+	// hello world
+}
+
+func Example_async() {
+	examples.Async()
+
+	// Output:
+	// t=2 b slept 2
+	// t=3 a slept 3
+	// t=6 a slept 3
+	// t=7 b slept 5
+}
@@ -79,3 +79,73 @@ letrec (s = (go "concat" "hello" " " "world")) {
 		return
 	}
 }
+
+// embed goscript with the interpreter API
+func Embed() {
+	i := runtime.NewInterpreter().Register("concat", concat)
+	if err := i.SetGlobal("name", runtime.NewString("world")); err != nil {
+		fmt.Println(err)
+		return
+	}
+	if err := i.Load(`(reg "greet" lambda (s) { (go "concat" s " " name) })`); err != nil {
+		fmt.Println(err)
+		return
+	}
+	if _, err := i.Execute(); err != nil {
+		fmt.Println(err)
+		return
+	}
+	v, err := i.Call("greet", "hello")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println(v)
+}
+
+// multiplex goscript programs on an event loop with asynchronous golang functions
+func Async() {
+	type event struct {
+		at    int
+		i     *runtime.Interpreter
+		value runtime.Value
+	}
+	queue := []event{}
+	now := 0
+
+	for _, src := range []string{
+		`[(put "a slept " (go "sleep" 3) "\n") (put "a slept " (go "sleep" 3) "\n")]`,
+		`[(put "b slept " (go "sleep" 2) "\n") (put "b slept " (go "sleep" 5) "\n")]`,
+	} {
+		var i *runtime.Interpreter
+		i = runtime.NewInterpreter().RegisterFunction("sleep", func(ctx *runtime.Context, args ...runtime.Value) (runtime.Value, *file.Error) {
+			d := args[0].(*runtime.Number)
+			queue = append(queue, event{at: now + d.Numerator, i: i, value: d})
+			return ctx.Suspend()
+		})
+		if err := i.Load(src); err != nil {
+			fmt.Println(err)
+			return
+		}
+		if _, err := i.Execute(); err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
+	for len(queue) != 0 {
+		next := 0
+		for k, e := range queue {
+			if e.at < queue[next].at {
+				next = k
+			}
+		}
+		e := queue[next]
+		queue = append(queue[:next], queue[next+1:]...)
+		now = e.at
+		fmt.Printf("t=%d ", now)
+		if _, err := e.i.Resume(e.value); err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
+}
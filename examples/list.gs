@@ -1,13 +1,12 @@
 letrec (
     empty = lambda () {
-        lambda () { 0 }
+        lambda () { false }
     }
     cons = lambda (head tail) {
-        lambda () { 1 } 
+        lambda () { true }
     }
     null = lambda (list) {
-        if (not (list)) then 1
-        else 0
+        (not (list))
     }
     head = lambda (list) {
         if (not (list)) then (void)
@@ -0,0 +1,15 @@
+macro (
+  when (c @body) { if c then [@body] else (void) }
+  unless (c @body) { if c then (void) else [@body] }
+  with (body) { body }
+  with ((x v) @rest) { (lambda (x) { (with @rest) } v) }
+  or2 (a b) { letrec (t = a) { if t then t else b } }
+) {
+  letrec (t = false) {
+    (with (x 1) (y 2) [
+      (when (lt x y) (put "x < y" "\n"))
+      (unless (lt x y) (put "x >= y" "\n"))
+      (put (or2 t 3) "\n")
+    ])
+  }
+}
@@ -0,0 +1,24 @@
+letrec (
+  just = lambda (value) { lambda () { true } }
+  nothing = lambda () { lambda () { false } }
+  describe = lambda (v) {
+    match v {
+      (void) then (put "void\n")
+      0 then (put "zero\n")
+      n if if (isnum n) then (lt n 0) else false then (put "negative\n")
+      {value = [x @rest]} then (put "just a list starting with " x "\n")
+      {value} then (put "just " value "\n")
+      [] then (put "empty list\n")
+      [x y] then (put "pair of " x " and " y "\n")
+      _ then (put "something else\n")
+    }
+  }
+) {[
+  (describe (void))
+  (describe 0)
+  (describe -3)
+  (describe (just 5))
+  (describe (just (mklist 1 2)))
+  (describe (mklist "a" "b"))
+  (describe (nothing))
+]}
@@ -9,4 +9,12 @@
   (put (eval (eval (eval (quote (quote (quote "hello world\n")))))))
   (put (eval (eval (eval (quote (quote (quote "hello world
 ")))))))
-]
\ No newline at end of file
+  letrec (
+    power = lambda (n) {
+      if (eq n 0) then `1 else `(mul x ,(power (sub n 1)))
+    }
+  ) {[
+    (put (power 3) "\n")
+    (put (eval `(lambda (x) { ,(power 3) } 2)) "\n")
+  ]}
+]
@@ -0,0 +1,22 @@
+letrec (
+  origin = {x = 0 y = 0}
+  move = lambda (p dx dy) { {p | x = (add &x p dx) y = (add &y p dy)} }
+  show = lambda (r) {
+    letrec (
+      each = lambda (fields) {
+        match fields {
+          [] then (void)
+          [f @rest] then [(put f " = " (recget r f) "\n") (each rest)]
+        }
+      }
+    ) {
+      (each (recfields r))
+    }
+  }
+) {
+  letrec (p = (move (move origin 1 2) 3 4)) {[
+    (put origin " " p "\n")
+    (show {p | y = 0})
+    (put (eq p {y = 6 x = 4}) "\n")
+  ]}
+}
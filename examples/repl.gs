@@ -5,13 +5,13 @@ letrec (
     (put "( (_-. )(_)( \\__ \\( (__  )   / _)(_  )___/  )(    " "\n")
     (put " \\___/(_____)(___/ \\___)(_)\\_)(____)(__)   (__)" " Hopes you have a `SUGOI` day!\n")
   ]
-  loop = lambda () {
+  repl = lambda () {
     letrec (
       line = [(put "> ") (getline)]
     ) {
-      [(put (eval line) "\n") (loop)]
+      [(put (eval line) "\n") (repl)]
     }
   }
 ) {
-  (loop)
+  (repl)
 }
\ No newline at end of file
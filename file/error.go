@@ -4,9 +4,18 @@ import (
 	"fmt"
 )
 
+type ErrorKind int
+
+const (
+	GeneralError ErrorKind = iota
+	// PermissionError reports a call denied by the capabilities of the configuration.
+	PermissionError
+)
+
 type Error struct {
 	Location SourceLocation
 	Message  string
+	Kind     ErrorKind
 }
 
 func (e *Error) Error() string {
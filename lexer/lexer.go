@@ -27,7 +27,7 @@ func (l *lexer) nextToken() (*Token, *file.Error) {
 			kind = Number
 			for l.currIndex < len(l.source) {
 				currChar = l.source[l.currIndex]
-				if unicode.IsDigit(currChar) || strings.ContainsRune("-+./", currChar) {
+				if unicode.IsDigit(currChar) || strings.ContainsRune("-+./", currChar) || l.isExponent() {
 					l.currLocation.Update(currChar)
 					l.currIndex++
 				} else {
@@ -47,6 +47,11 @@ func (l *lexer) nextToken() (*Token, *file.Error) {
 					break
 				}
 			}
+			// `let*` is the only keyword ending with a symbol.
+			if l.currIndex < len(l.source) && l.source[l.currIndex] == '*' && string(l.source[begin:l.currIndex]) == "let" {
+				l.currLocation.Update('*')
+				l.currIndex++
+			}
 			kind = Identifier
 			for _, kw := range keyword {
 				if string(l.source[begin:l.currIndex]) == kw {
@@ -54,7 +59,7 @@ func (l *lexer) nextToken() (*Token, *file.Error) {
 					break
 				}
 			}
-		} else if strings.ContainsRune("(){}[]=@&", currChar) {
+		} else if strings.ContainsRune("(){}[]=@&`,_|", currChar) {
 			kind = Symbol
 			l.currLocation.Update(currChar)
 			l.currIndex++
@@ -101,6 +106,15 @@ func (l *lexer) nextToken() (*Token, *file.Error) {
 	}
 }
 
+// isExponent reports whether the current character starts the exponent of a float literal.
+func (l *lexer) isExponent() bool {
+	if !strings.ContainsRune("eE", l.source[l.currIndex]) || l.currIndex+1 >= len(l.source) {
+		return false
+	}
+	next := l.source[l.currIndex+1]
+	return unicode.IsDigit(next) || strings.ContainsRune("-+", next)
+}
+
 func Lex(source file.Source) ([]*Token, *file.Error) {
 	sl := file.SourceLocation{Line: 1, Col: 1}
 	for _, char := range source {
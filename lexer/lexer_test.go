@@ -32,6 +32,16 @@ func TestLex(t *testing.T) {
 				{Location: file.SourceLocation{Line: 1, Col: 15}, Kind: Number, Source: "-1/2"},
 			},
 		},
+		{
+			"1.5e3 -2E-1 0e+0 1else",
+			[]*Token{
+				{Location: file.SourceLocation{Line: 1, Col: 1}, Kind: Number, Source: "1.5e3"},
+				{Location: file.SourceLocation{Line: 1, Col: 7}, Kind: Number, Source: "-2E-1"},
+				{Location: file.SourceLocation{Line: 1, Col: 13}, Kind: Number, Source: "0e+0"},
+				{Location: file.SourceLocation{Line: 1, Col: 18}, Kind: Number, Source: "1"},
+				{Location: file.SourceLocation{Line: 1, Col: 19}, Kind: Keyword, Source: "else"},
+			},
+		},
 		{
 			"add",
 			[]*Token{
@@ -59,6 +69,20 @@ func TestLex(t *testing.T) {
 				{Location: file.SourceLocation{Line: 1, Col: 9}, Kind: Symbol, Source: "&"},
 			},
 		},
+		{
+			"`(a ,b ,@c)",
+			[]*Token{
+				{Location: file.SourceLocation{Line: 1, Col: 1}, Kind: Symbol, Source: "`"},
+				{Location: file.SourceLocation{Line: 1, Col: 2}, Kind: Symbol, Source: "("},
+				{Location: file.SourceLocation{Line: 1, Col: 3}, Kind: Identifier, Source: "a"},
+				{Location: file.SourceLocation{Line: 1, Col: 5}, Kind: Symbol, Source: ","},
+				{Location: file.SourceLocation{Line: 1, Col: 6}, Kind: Identifier, Source: "b"},
+				{Location: file.SourceLocation{Line: 1, Col: 8}, Kind: Symbol, Source: ","},
+				{Location: file.SourceLocation{Line: 1, Col: 9}, Kind: Symbol, Source: "@"},
+				{Location: file.SourceLocation{Line: 1, Col: 10}, Kind: Identifier, Source: "c"},
+				{Location: file.SourceLocation{Line: 1, Col: 11}, Kind: Symbol, Source: ")"},
+			},
+		},
 		{
 			"#comment",
 			[]*Token{},
@@ -108,6 +132,36 @@ func TestLex(t *testing.T) {
 				{Location: file.SourceLocation{Line: 1, Col: 70}, Kind: Symbol, Source: `}`},
 			},
 		},
+		{
+			"match a_b { _ then 1 }",
+			[]*Token{
+				{Location: file.SourceLocation{Line: 1, Col: 1}, Kind: Keyword, Source: `match`},
+				{Location: file.SourceLocation{Line: 1, Col: 7}, Kind: Identifier, Source: `a_b`},
+				{Location: file.SourceLocation{Line: 1, Col: 11}, Kind: Symbol, Source: `{`},
+				{Location: file.SourceLocation{Line: 1, Col: 13}, Kind: Symbol, Source: `_`},
+				{Location: file.SourceLocation{Line: 1, Col: 15}, Kind: Keyword, Source: `then`},
+				{Location: file.SourceLocation{Line: 1, Col: 20}, Kind: Number, Source: `1`},
+				{Location: file.SourceLocation{Line: 1, Col: 22}, Kind: Symbol, Source: `}`},
+			},
+		},
+		{
+			"let* let letx",
+			[]*Token{
+				{Location: file.SourceLocation{Line: 1, Col: 1}, Kind: Keyword, Source: `let*`},
+				{Location: file.SourceLocation{Line: 1, Col: 6}, Kind: Keyword, Source: `let`},
+				{Location: file.SourceLocation{Line: 1, Col: 10}, Kind: Identifier, Source: `letx`},
+			},
+		},
+		{
+			"{r|a}",
+			[]*Token{
+				{Location: file.SourceLocation{Line: 1, Col: 1}, Kind: Symbol, Source: `{`},
+				{Location: file.SourceLocation{Line: 1, Col: 2}, Kind: Identifier, Source: `r`},
+				{Location: file.SourceLocation{Line: 1, Col: 3}, Kind: Symbol, Source: `|`},
+				{Location: file.SourceLocation{Line: 1, Col: 4}, Kind: Identifier, Source: `a`},
+				{Location: file.SourceLocation{Line: 1, Col: 5}, Kind: Symbol, Source: `}`},
+			},
+		},
 	}
 
 	for _, test := range tests {
@@ -126,10 +180,16 @@ func TestLex_error(t *testing.T) {
 		"1/0",
 		"1/-3",
 		"1.1.1",
+		"1e1.5",
+		"1.e1",
+		"1/2e1",
+		"01e1",
+		"1e+",
 		`"`,
 		`"\"`,
 		`"\\\"`,
 		`.`,
+		`letx*`,
 	}
 	for _, test := range tests {
 		t.Run(test, func(t *testing.T) {
@@ -23,6 +23,12 @@ var eof = Token{}
 
 var keyword = [...]string{
 	"if", "then", "else",
-	"letrec",
+	"letrec", "let", "let*",
+	"loop",
 	"lambda",
+	"macro",
+	"match",
+	"data",
+	"cond",
+	"true", "false",
 }
@@ -4,7 +4,7 @@ import "regexp"
 
 const charSet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789`~!@#$%^&*()-_=+[{]}\\|;:'\",<.>/? \t\n\r"
 
-var numberRegexp = regexp.MustCompile(`^[+-]?((0|[1-9][0-9]*)|((0|[1-9][0-9]*)\.([0-9]*[1-9]))|((0|[1-9][0-9]*)/([1-9][0-9]*)))$`)
+var numberRegexp = regexp.MustCompile(`^[+-]?((0|[1-9][0-9]*)|((0|[1-9][0-9]*)\.([0-9]*[1-9]))|((0|[1-9][0-9]*)/([1-9][0-9]*))|((0|[1-9][0-9]*)(\.[0-9]+)?[eE][+-]?[0-9]+))$`)
 
 func countTrailingEscape(s string) int {
 	cnt := 0
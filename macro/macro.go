@@ -0,0 +1,1255 @@
+package macro
+
+import (
+	"strconv"
+	"unicode"
+
+	. "github.com/gogim1/goscript/ast"
+	"github.com/gogim1/goscript/file"
+)
+
+// maxDepth bounds nested expansions, so that a macro expanding to itself fails
+// instead of looping forever.
+const maxDepth = 1000
+
+// rule is a macro rule along with what its template refers to: the lexical names it
+// uses without binding them, and the names these variables were given where the
+// macro is defined.
+type rule struct {
+	*MacroRule
+	free  map[string]bool
+	names map[string]string
+}
+
+type rules map[string][]*rule
+
+// scope is what the expansion of a node sees: the visible macros, and the new names
+// of the lexical variables in scope that were renamed.
+type scope struct {
+	rules rules
+	names map[string]string
+}
+
+// bind returns the scope under binders of the given names. Macros of those names are
+// shadowed, and a lexical variable is renamed when a visible template refers to a
+// variable of the same name, which it would capture otherwise.
+func (e *expander) bind(env scope, names ...string) scope {
+	shadowed, renamed := false, false
+	for _, name := range names {
+		if _, ok := env.rules[name]; ok {
+			shadowed = true
+		}
+		if _, ok := env.names[name]; ok || env.captures(name) {
+			renamed = true
+		}
+	}
+	if shadowed {
+		r := make(rules, len(env.rules))
+		for name, rules := range env.rules {
+			r[name] = rules
+		}
+		for _, name := range names {
+			delete(r, name)
+		}
+		env.rules = r
+	}
+	if renamed {
+		m := make(map[string]string, len(env.names)+len(names))
+		for name, new := range env.names {
+			m[name] = new
+		}
+		for _, name := range names {
+			delete(m, name)
+			if env.captures(name) {
+				e.fresh++
+				m[name] = name + "%" + strconv.Itoa(e.fresh)
+			}
+		}
+		env.names = m
+	}
+	return env
+}
+
+// captures reports whether a binder of the given name would capture a variable that a
+// visible template refers to.
+func (s scope) captures(name string) bool {
+	if kind(name) != Lexical {
+		return false
+	}
+	for _, rules := range s.rules {
+		for _, rule := range rules {
+			if rule.free[name] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// variable copies a variable bound or referred to in scope with its new name.
+func (s scope) variable(v *VariableNode) *VariableNode {
+	if name, ok := s.names[v.Name]; ok && v.Kind == Lexical {
+		return NewVariableNode(v.Location, name, v.Kind)
+	}
+	return NewVariableNode(v.Location, v.Name, v.Kind)
+}
+
+// binding is what a pattern variable matched, a rest element matches a list of nodes.
+type binding struct {
+	nodes []ExprNode
+	rest  bool
+}
+
+type expander struct {
+	fresh int
+	depth int
+	// resolved are the variables a template refers to, which already have the name
+	// of the variable they refer to where the macro is defined.
+	resolved map[*VariableNode]bool
+}
+
+// Expand replaces every macro definition and macro use below node by its expansion.
+// The given node is left untouched.
+//
+// Macros are hygienic with respect to lexical names: lexical variables bound by a
+// template are renamed, so they never capture the variables of the macro use, and
+// the variables a template refers to without binding them are those visible where
+// the macro is defined, so binders around the macro use are renamed rather than
+// capturing them. Dynamic variables are kept as is, as they are meant to be visible
+// to the code they enclose. The variable of an access is never renamed, as it names a
+// variable of the closure being accessed.
+func Expand(node ExprNode) (ExprNode, *file.Error) {
+	e := &expander{resolved: make(map[*VariableNode]bool)}
+	return e.expand(node, scope{})
+}
+
+// names returns the names of variables.
+func names(vars []*VariableNode) []string {
+	ret := []string{}
+	for _, v := range vars {
+		ret = append(ret, v.Name)
+	}
+	return ret
+}
+
+func (e *expander) expand(node ExprNode, env scope) (ExprNode, *file.Error) {
+	sl := node.GetLocation()
+	switch n := node.(type) {
+	case *NumberNode:
+		return NewNumberNode(sl, n.Numerator, n.Denominator), nil
+	case *FloatNode:
+		return NewFloatNode(sl, n.Value), nil
+	case *BoolNode:
+		return NewBoolNode(sl, n.Value), nil
+	case *StringNode:
+		return NewStringNode(sl, n.Value), nil
+	case *IntrinsicNode:
+		return NewIntrinsicNode(sl, n.Name), nil
+	case *VariableNode:
+		if e.resolved[n] {
+			return NewVariableNode(sl, n.Name, n.Kind), nil
+		}
+		return env.variable(n), nil
+	case *LambdaNode:
+		inner := env
+		varList := []*VariableNode{}
+		var patterns, defaults []ExprNode
+		for i, v := range n.VarList {
+			// a default sees the previous parameters only.
+			if d := n.Default(i); d != nil {
+				expr, err := e.expand(d, inner)
+				if err != nil {
+					return nil, err
+				}
+				defaults = append(defaults, expr)
+			}
+			if n.Patterns != nil {
+				var pattern ExprNode
+				if n.Patterns[i] != nil {
+					outer := inner
+					inner = e.bind(inner, names(PatternVariables(n.Patterns[i]))...)
+					p, err := e.pattern(n.Patterns[i], inner, outer)
+					if err != nil {
+						return nil, err
+					}
+					pattern = p
+				}
+				patterns = append(patterns, pattern)
+			}
+			inner = e.bind(inner, v.Name)
+			varList = append(varList, inner.variable(v))
+		}
+		var rest *VariableNode
+		if n.Rest != nil {
+			inner = e.bind(inner, n.Rest.Name)
+			rest = inner.variable(n.Rest)
+		}
+		expr, err := e.expand(n.Expr, inner)
+		if err != nil {
+			return nil, err
+		}
+		return NewLambdaNodeWithParams(sl, varList, LambdaParams{Patterns: patterns, Defaults: defaults, Rest: rest}, expr), nil
+	case *LetrecNode:
+		vars := []string{}
+		for _, ve := range n.VarExprList {
+			vars = append(vars, names(PatternVariables(ve.Target()))...)
+		}
+		env = e.bind(env, vars...)
+		varExprList, err := e.bindings(n.VarExprList, env, env)
+		if err != nil {
+			return nil, err
+		}
+		expr, err := e.expand(n.Expr, env)
+		if err != nil {
+			return nil, err
+		}
+		return NewLetrecNode(sl, varExprList, expr), nil
+	case *LetNode:
+		var varExprList []*LetrecVarExprItem
+		if n.Sequential {
+			// the expressions of `let*` see the previous variables only.
+			varExprList = []*LetrecVarExprItem{}
+			for _, ve := range n.VarExprList {
+				outer := env
+				env = e.bind(env, names(PatternVariables(ve.Target()))...)
+				list, err := e.bindings([]*LetrecVarExprItem{ve}, outer, env)
+				if err != nil {
+					return nil, err
+				}
+				varExprList = append(varExprList, list...)
+			}
+		} else {
+			vars := []string{}
+			for _, ve := range n.VarExprList {
+				vars = append(vars, names(PatternVariables(ve.Target()))...)
+			}
+			outer := env
+			env = e.bind(env, vars...)
+			list, err := e.bindings(n.VarExprList, outer, env)
+			if err != nil {
+				return nil, err
+			}
+			varExprList = list
+		}
+		expr, err := e.expand(n.Expr, env)
+		if err != nil {
+			return nil, err
+		}
+		return NewLetNode(sl, n.Sequential, varExprList, expr), nil
+	case *LoopNode:
+		inits, err := e.expandList(n.Inits, env)
+		if err != nil {
+			return nil, err
+		}
+		inner := e.bind(env, n.Name.Name)
+		fun, err := e.expand(n.Fun, inner)
+		if err != nil {
+			return nil, err
+		}
+		return NewLoopNode(sl, inner.variable(n.Name), inits, fun.(*LambdaNode)), nil
+	case *IfNode:
+		list, err := e.expandList([]ExprNode{n.Cond, n.Branch1, n.Branch2}, env)
+		if err != nil {
+			return nil, err
+		}
+		return NewIfNode(sl, list[0], list[1], list[2]), nil
+	case *CallNode:
+		if v, ok := n.Callee.(*VariableNode); ok {
+			if rules, ok := env.rules[v.Name]; ok {
+				return e.apply(n, rules, env)
+			}
+		}
+		list, err := e.expandList(append([]ExprNode{n.Callee}, n.ArgList...), env)
+		if err != nil {
+			return nil, err
+		}
+		return NewCallNode(sl, list[0], list[1:]), nil
+	case *SequenceNode:
+		exprList, err := e.expandList(n.ExprList, env)
+		if err != nil {
+			return nil, err
+		}
+		return NewSequenceNode(sl, exprList), nil
+	case *AccessNode:
+		expr, err := e.expand(n.Expr, env)
+		if err != nil {
+			return nil, err
+		}
+		return NewAccessNode(sl, NewVariableNode(n.Variable.Location, n.Variable.Name, n.Variable.Kind), expr), nil
+	case *QuoteNode:
+		// quoted code is data, only the unquoted expressions are expanded.
+		expr, err := e.expandQuoted(n.Expr, env, 0)
+		if err != nil {
+			return nil, err
+		}
+		return NewQuoteNode(sl, expr), nil
+	case *UnquoteNode:
+		expr, err := e.expand(n.Expr, env)
+		if err != nil {
+			return nil, err
+		}
+		return NewUnquoteNode(sl, expr, n.Splicing), nil
+	case *RestNode:
+		return nil, &file.Error{Location: sl, Message: "rest element outside of a macro"}
+	case *RecordNode, *CondNode:
+		return rebuild(n, func(child ExprNode) (ExprNode, *file.Error) {
+			return e.expand(child, env)
+		})
+	case *MatchNode:
+		expr, err := e.expand(n.Expr, env)
+		if err != nil {
+			return nil, err
+		}
+		cases := []*MatchCase{}
+		for _, c := range n.Cases {
+			inner := e.bind(env, names(PatternVariables(c.Pattern))...)
+			pattern, err := e.pattern(c.Pattern, inner, env)
+			if err != nil {
+				return nil, err
+			}
+			var guard ExprNode
+			if c.Guard != nil {
+				if guard, err = e.expand(c.Guard, inner); err != nil {
+					return nil, err
+				}
+			}
+			expr, err := e.expand(c.Expr, inner)
+			if err != nil {
+				return nil, err
+			}
+			cases = append(cases, &MatchCase{Pattern: pattern, Guard: guard, Expr: expr})
+		}
+		return NewMatchNode(sl, expr, cases), nil
+	case *DataNode:
+		inner := e.bind(env, names(dataVariables(n))...)
+		expr, err := e.expand(n.Expr, inner)
+		if err != nil {
+			return nil, err
+		}
+		return copyData(n, expr, func(v *VariableNode) (*VariableNode, *file.Error) {
+			return inner.variable(v), nil
+		})
+	case *MacroNode:
+		if err := check(n); err != nil {
+			return nil, err
+		}
+		inner := make(rules, len(env.rules)+len(n.Rules))
+		for name, rules := range env.rules {
+			inner[name] = rules
+		}
+		defined := make(map[string]bool)
+		for _, r := range n.Rules {
+			// a definition replaces the outer rules of the same name.
+			if !defined[r.Name.Name] {
+				defined[r.Name.Name] = true
+				inner[r.Name.Name] = nil
+			}
+			inner[r.Name.Name] = append(inner[r.Name.Name], &rule{MacroRule: r, free: free(r), names: env.names})
+		}
+		return e.expand(n.Expr, scope{rules: inner, names: env.names})
+	}
+	return nil, &file.Error{Location: sl, Message: "unrecognized node"}
+}
+
+// bindings expands the items of a binding form. Their expressions see outer, and their
+// variables are bound in inner.
+func (e *expander) bindings(varExprList []*LetrecVarExprItem, outer, inner scope) ([]*LetrecVarExprItem, *file.Error) {
+	ret := []*LetrecVarExprItem{}
+	for _, ve := range varExprList {
+		expr, err := e.expand(ve.Expr, outer)
+		if err != nil {
+			return nil, err
+		}
+		if ve.Pattern != nil {
+			pattern, err := e.pattern(ve.Pattern, inner, outer)
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, &LetrecVarExprItem{Pattern: pattern, Expr: expr})
+			continue
+		}
+		ret = append(ret, &LetrecVarExprItem{Variable: inner.variable(ve.Variable), Expr: expr})
+	}
+	return ret, nil
+}
+
+// pattern copies a pattern of `match`, whose variables are bound in inner and whose
+// constructors are looked up in outer.
+func (e *expander) pattern(node ExprNode, inner, outer scope) (ExprNode, *file.Error) {
+	switch n := node.(type) {
+	case *VariableNode:
+		return inner.variable(n), nil
+	case *RestNode:
+		return NewRestNode(n.Location, inner.variable(NewVariableNode(n.Location, n.Name, kind(n.Name))).Name), nil
+	case *SequenceNode:
+		elements := []ExprNode{}
+		for _, element := range n.ExprList {
+			element, err := e.pattern(element, inner, outer)
+			if err != nil {
+				return nil, err
+			}
+			elements = append(elements, element)
+		}
+		return NewSequenceNode(n.Location, elements), nil
+	case *RecordNode:
+		fields := []*RecordField{}
+		for _, f := range n.Fields {
+			expr, err := e.pattern(f.Expr, inner, outer)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, &RecordField{Name: NewVariableNode(f.Name.Location, f.Name.Name, f.Name.Kind), Expr: expr})
+		}
+		return NewRecordNode(n.Location, nil, fields), nil
+	case *CallNode:
+		callee, err := e.expand(n.Callee, outer)
+		if err != nil {
+			return nil, err
+		}
+		args := []ExprNode{}
+		for _, arg := range n.ArgList {
+			arg, err := e.pattern(arg, inner, outer)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+		}
+		return NewCallNode(n.Location, callee, args), nil
+	}
+	return copyPattern(node)
+}
+
+func (e *expander) expandList(list []ExprNode, env scope) ([]ExprNode, *file.Error) {
+	ret := []ExprNode{}
+	for _, node := range list {
+		node, err := e.expand(node, env)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, node)
+	}
+	return ret, nil
+}
+
+func (e *expander) expandQuoted(node ExprNode, env scope, level int) (ExprNode, *file.Error) {
+	switch n := node.(type) {
+	case *QuoteNode:
+		expr, err := e.expandQuoted(n.Expr, env, level+1)
+		if err != nil {
+			return nil, err
+		}
+		return NewQuoteNode(n.Location, expr), nil
+	case *UnquoteNode:
+		var expr ExprNode
+		var err *file.Error
+		if level == 0 {
+			expr, err = e.expand(n.Expr, env)
+		} else {
+			expr, err = e.expandQuoted(n.Expr, env, level-1)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return NewUnquoteNode(n.Location, expr, n.Splicing), nil
+	}
+	return rebuild(node, func(child ExprNode) (ExprNode, *file.Error) {
+		return e.expandQuoted(child, env, level)
+	})
+}
+
+// apply expands a macro use with the first rule whose pattern matches its arguments.
+func (e *expander) apply(n *CallNode, rules []*rule, env scope) (ExprNode, *file.Error) {
+	for _, rule := range rules {
+		bindings := make(map[string]binding)
+		if !match(rule.Pattern, n.ArgList, bindings) {
+			continue
+		}
+		if e.depth == maxDepth {
+			return nil, &file.Error{Location: n.Location, Message: "macro expansion too deep"}
+		}
+		node, err := e.instantiate(n.Location, rule, bindings)
+		if err != nil {
+			return nil, err
+		}
+		e.depth++
+		defer func() {
+			e.depth--
+		}()
+		return e.expand(node, env)
+	}
+	return nil, &file.Error{Location: n.Location, Message: "no macro rule matches"}
+}
+
+// match matches a pattern list against elements. A lexical variable binds a single
+// element, a rest element binds the remaining ones.
+func match(pattern []ExprNode, elements []ExprNode, bindings map[string]binding) bool {
+	for i, p := range pattern {
+		if rest, ok := p.(*RestNode); ok {
+			bindings[rest.Name] = binding{nodes: elements[i:], rest: true}
+			return true
+		}
+		if i >= len(elements) || !matchOne(p, elements[i], bindings) {
+			return false
+		}
+	}
+	return len(pattern) == len(elements)
+}
+
+func matchOne(p ExprNode, element ExprNode, bindings map[string]binding) bool {
+	switch p := p.(type) {
+	case *VariableNode:
+		if p.Kind != Lexical {
+			v, ok := element.(*VariableNode)
+			return ok && v.Name == p.Name
+		}
+		bindings[p.Name] = binding{nodes: []ExprNode{element}}
+		return true
+	case *NumberNode:
+		v, ok := element.(*NumberNode)
+		return ok && v.Numerator == p.Numerator && v.Denominator == p.Denominator
+	case *FloatNode:
+		v, ok := element.(*FloatNode)
+		return ok && v.Value == p.Value
+	case *BoolNode:
+		v, ok := element.(*BoolNode)
+		return ok && v.Value == p.Value
+	case *StringNode:
+		v, ok := element.(*StringNode)
+		return ok && v.Value == p.Value
+	case *IntrinsicNode:
+		v, ok := element.(*IntrinsicNode)
+		return ok && v.Name == p.Name
+	case *CallNode:
+		v, ok := element.(*CallNode)
+		return ok && match(append([]ExprNode{p.Callee}, p.ArgList...), append([]ExprNode{v.Callee}, v.ArgList...), bindings)
+	case *SequenceNode:
+		v, ok := element.(*SequenceNode)
+		return ok && match(p.ExprList, v.ExprList, bindings)
+	}
+	return false
+}
+
+// check reports malformed macro definitions.
+func check(n *MacroNode) *file.Error {
+	for _, rule := range n.Rules {
+		names := make(map[string]bool)
+		var checkList func(list []ExprNode) *file.Error
+		checkOne := func(p ExprNode) *file.Error {
+			switch p := p.(type) {
+			case *VariableNode:
+				if p.Kind == Lexical {
+					if names[p.Name] {
+						return &file.Error{Location: p.Location, Message: "duplicate pattern variable"}
+					}
+					names[p.Name] = true
+				}
+			case *NumberNode, *FloatNode, *BoolNode, *StringNode, *IntrinsicNode:
+			case *CallNode:
+				return checkList(append([]ExprNode{p.Callee}, p.ArgList...))
+			case *SequenceNode:
+				return checkList(p.ExprList)
+			default:
+				return &file.Error{Location: p.GetLocation(), Message: "unsupported macro pattern"}
+			}
+			return nil
+		}
+		checkList = func(list []ExprNode) *file.Error {
+			for i, p := range list {
+				if rest, ok := p.(*RestNode); ok {
+					if i != len(list)-1 {
+						return &file.Error{Location: rest.Location, Message: "rest element must be the last one"}
+					}
+					if names[rest.Name] {
+						return &file.Error{Location: rest.Location, Message: "duplicate pattern variable"}
+					}
+					names[rest.Name] = true
+					continue
+				}
+				if err := checkOne(p); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		if err := checkList(rule.Pattern); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// instantiate builds the template of a rule. The lexical variables bound by the
+// template itself get fresh names, which cannot be written in source code, and the
+// ones it refers to get the names they have where the macro is defined.
+func (e *expander) instantiate(sl file.SourceLocation, rule *rule, bindings map[string]binding) (ExprNode, *file.Error) {
+	renames := make(map[string]string)
+	for _, v := range binders(rule.Template) {
+		if _, ok := bindings[v.Name]; !ok && v.Kind == Lexical {
+			if _, ok := renames[v.Name]; !ok {
+				e.fresh++
+				renames[v.Name] = v.Name + "%" + strconv.Itoa(e.fresh)
+			}
+		}
+	}
+
+	variable := func(v *VariableNode) (*VariableNode, *file.Error) {
+		if bound, ok := bindings[v.Name]; ok {
+			if !bound.rest {
+				if b, ok := bound.nodes[0].(*VariableNode); ok {
+					return NewVariableNode(b.Location, b.Name, b.Kind), nil
+				}
+			}
+			return nil, &file.Error{Location: sl, Message: "macro expansion produced an incorrect variable"}
+		}
+		if name, ok := renames[v.Name]; ok {
+			return NewVariableNode(v.Location, name, v.Kind), nil
+		}
+		return NewVariableNode(v.Location, v.Name, v.Kind), nil
+	}
+
+	reference := func(v *VariableNode) (*VariableNode, *file.Error) {
+		if _, ok := bindings[v.Name]; ok || v.Kind != Lexical {
+			return variable(v)
+		}
+		if _, ok := renames[v.Name]; ok {
+			return variable(v)
+		}
+		name, ok := rule.names[v.Name]
+		if !ok {
+			name = v.Name
+		}
+		ret := NewVariableNode(v.Location, name, v.Kind)
+		e.resolved[ret] = true
+		return ret, nil
+	}
+
+	var build, buildPattern func(node ExprNode) (ExprNode, *file.Error)
+	var buildBindings func(varExprList []*LetrecVarExprItem) ([]*LetrecVarExprItem, *file.Error)
+	buildList := func(list []ExprNode) ([]ExprNode, *file.Error) {
+		ret := []ExprNode{}
+		for _, node := range list {
+			if rest, ok := node.(*RestNode); ok {
+				bound, ok := bindings[rest.Name]
+				if !ok || !bound.rest {
+					return nil, &file.Error{Location: rest.Location, Message: "undefined rest variable"}
+				}
+				ret = append(ret, bound.nodes...)
+				continue
+			}
+			node, err := build(node)
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, node)
+		}
+		return ret, nil
+	}
+	build = func(node ExprNode) (ExprNode, *file.Error) {
+		switch n := node.(type) {
+		case *VariableNode:
+			if bound, ok := bindings[n.Name]; ok {
+				if bound.rest {
+					return nil, &file.Error{Location: n.Location, Message: "rest variable used as an expression"}
+				}
+				return bound.nodes[0], nil
+			}
+			return reference(n)
+		case *LambdaNode:
+			varList := []*VariableNode{}
+			var patterns []ExprNode
+			for i, v := range n.VarList {
+				v, err := variable(v)
+				if err != nil {
+					return nil, err
+				}
+				varList = append(varList, v)
+				if n.Patterns != nil {
+					var pattern ExprNode
+					if n.Patterns[i] != nil {
+						if pattern, err = buildPattern(n.Patterns[i]); err != nil {
+							return nil, err
+						}
+					}
+					patterns = append(patterns, pattern)
+				}
+			}
+			var defaults []ExprNode
+			for _, d := range n.Defaults {
+				d, err := build(d)
+				if err != nil {
+					return nil, err
+				}
+				defaults = append(defaults, d)
+			}
+			var rest *VariableNode
+			if n.Rest != nil {
+				v, err := variable(n.Rest)
+				if err != nil {
+					return nil, err
+				}
+				rest = v
+			}
+			expr, err := build(n.Expr)
+			if err != nil {
+				return nil, err
+			}
+			return NewLambdaNodeWithParams(n.Location, varList, LambdaParams{Patterns: patterns, Defaults: defaults, Rest: rest}, expr), nil
+		case *LetrecNode:
+			varExprList, err := buildBindings(n.VarExprList)
+			if err != nil {
+				return nil, err
+			}
+			expr, err := build(n.Expr)
+			if err != nil {
+				return nil, err
+			}
+			return NewLetrecNode(n.Location, varExprList, expr), nil
+		case *LetNode:
+			varExprList, err := buildBindings(n.VarExprList)
+			if err != nil {
+				return nil, err
+			}
+			expr, err := build(n.Expr)
+			if err != nil {
+				return nil, err
+			}
+			return NewLetNode(n.Location, n.Sequential, varExprList, expr), nil
+		case *LoopNode:
+			name, err := variable(n.Name)
+			if err != nil {
+				return nil, err
+			}
+			inits := []ExprNode{}
+			for _, init := range n.Inits {
+				init, err := build(init)
+				if err != nil {
+					return nil, err
+				}
+				inits = append(inits, init)
+			}
+			fun, err := build(n.Fun)
+			if err != nil {
+				return nil, err
+			}
+			return NewLoopNode(n.Location, name, inits, fun.(*LambdaNode)), nil
+		case *CallNode:
+			list, err := buildList(append([]ExprNode{n.Callee}, n.ArgList...))
+			if err != nil {
+				return nil, err
+			}
+			if len(list) == 0 {
+				return nil, &file.Error{Location: n.Location, Message: "macro expansion produced an empty call"}
+			}
+			return NewCallNode(n.Location, list[0], list[1:]), nil
+		case *SequenceNode:
+			exprList, err := buildList(n.ExprList)
+			if err != nil {
+				return nil, err
+			}
+			if len(exprList) == 0 {
+				return nil, &file.Error{Location: n.Location, Message: "zero-length sequence"}
+			}
+			return NewSequenceNode(n.Location, exprList), nil
+		case *AccessNode:
+			v, err := variable(n.Variable)
+			if err != nil {
+				return nil, err
+			}
+			expr, err := build(n.Expr)
+			if err != nil {
+				return nil, err
+			}
+			return NewAccessNode(n.Location, v, expr), nil
+		case *RestNode:
+			return nil, &file.Error{Location: n.Location, Message: "rest element outside of a sequence or an argument list"}
+		case *RecordNode:
+			var expr ExprNode
+			if n.Expr != nil {
+				var err *file.Error
+				if expr, err = build(n.Expr); err != nil {
+					return nil, err
+				}
+			}
+			fields := []*RecordField{}
+			for _, f := range n.Fields {
+				name, err := variable(f.Name)
+				if err != nil {
+					return nil, err
+				}
+				expr, err := build(f.Expr)
+				if err != nil {
+					return nil, err
+				}
+				fields = append(fields, &RecordField{Name: name, Expr: expr})
+			}
+			return NewRecordNode(n.Location, expr, fields), nil
+		case *MatchNode:
+			expr, err := build(n.Expr)
+			if err != nil {
+				return nil, err
+			}
+			cases := []*MatchCase{}
+			for _, c := range n.Cases {
+				pattern, err := buildPattern(c.Pattern)
+				if err != nil {
+					return nil, err
+				}
+				var guard ExprNode
+				if c.Guard != nil {
+					if guard, err = build(c.Guard); err != nil {
+						return nil, err
+					}
+				}
+				expr, err := build(c.Expr)
+				if err != nil {
+					return nil, err
+				}
+				cases = append(cases, &MatchCase{Pattern: pattern, Guard: guard, Expr: expr})
+			}
+			return NewMatchNode(n.Location, expr, cases), nil
+		case *DataNode:
+			expr, err := build(n.Expr)
+			if err != nil {
+				return nil, err
+			}
+			return copyData(n, expr, variable)
+		}
+		return rebuild(node, build)
+	}
+	buildBindings = func(varExprList []*LetrecVarExprItem) ([]*LetrecVarExprItem, *file.Error) {
+		ret := []*LetrecVarExprItem{}
+		for _, ve := range varExprList {
+			expr, err := build(ve.Expr)
+			if err != nil {
+				return nil, err
+			}
+			if ve.Pattern != nil {
+				pattern, err := buildPattern(ve.Pattern)
+				if err != nil {
+					return nil, err
+				}
+				ret = append(ret, &LetrecVarExprItem{Pattern: pattern, Expr: expr})
+				continue
+			}
+			v, err := variable(ve.Variable)
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, &LetrecVarExprItem{Variable: v, Expr: expr})
+		}
+		return ret, nil
+	}
+	// buildPattern builds a pattern of `match`, where a pattern variable of the macro
+	// stands for a variable or a literal, and a rest variable for several patterns.
+	buildPattern = func(node ExprNode) (ExprNode, *file.Error) {
+		switch n := node.(type) {
+		case *VariableNode:
+			if bound, ok := bindings[n.Name]; ok && !bound.rest {
+				return asPattern(sl, bound.nodes[0])
+			}
+			if n.Name == "_" {
+				return NewVariableNode(n.Location, n.Name, n.Kind), nil
+			}
+			return variable(n)
+		case *RestNode:
+			v, err := variable(NewVariableNode(n.Location, n.Name, kind(n.Name)))
+			if err != nil {
+				return nil, err
+			}
+			return NewRestNode(n.Location, v.Name), nil
+		case *SequenceNode:
+			elements := []ExprNode{}
+			for _, element := range n.ExprList {
+				if rest, ok := element.(*RestNode); ok {
+					if bound, ok := bindings[rest.Name]; ok && bound.rest {
+						for _, b := range bound.nodes {
+							p, err := asPattern(sl, b)
+							if err != nil {
+								return nil, err
+							}
+							elements = append(elements, p)
+						}
+						continue
+					}
+				}
+				element, err := buildPattern(element)
+				if err != nil {
+					return nil, err
+				}
+				elements = append(elements, element)
+			}
+			return NewSequenceNode(n.Location, elements), nil
+		case *RecordNode:
+			fields := []*RecordField{}
+			for _, f := range n.Fields {
+				name, err := variable(f.Name)
+				if err != nil {
+					return nil, err
+				}
+				expr, err := buildPattern(f.Expr)
+				if err != nil {
+					return nil, err
+				}
+				fields = append(fields, &RecordField{Name: name, Expr: expr})
+			}
+			return NewRecordNode(n.Location, nil, fields), nil
+		case *CallNode:
+			callee, ok := n.Callee.(*VariableNode)
+			if !ok {
+				return copyPattern(node)
+			}
+			constructor, err := reference(callee)
+			if err != nil {
+				return nil, err
+			}
+			args := []ExprNode{}
+			for _, arg := range n.ArgList {
+				arg, err := buildPattern(arg)
+				if err != nil {
+					return nil, err
+				}
+				args = append(args, arg)
+			}
+			return NewCallNode(n.Location, constructor, args), nil
+		}
+		return copyPattern(node)
+	}
+	return build(rule.Template)
+}
+
+// binders returns the variables bound in a template.
+func binders(template ExprNode) []*VariableNode {
+	ret := []*VariableNode{}
+	Walk(template, func(node ExprNode) {
+		switch n := node.(type) {
+		case *LambdaNode:
+			for i, v := range n.VarList {
+				if n.Patterns != nil && n.Patterns[i] != nil {
+					ret = append(ret, PatternVariables(n.Patterns[i])...)
+				} else {
+					ret = append(ret, v)
+				}
+			}
+			if n.Rest != nil {
+				ret = append(ret, n.Rest)
+			}
+		case *LetrecNode:
+			for _, ve := range n.VarExprList {
+				ret = append(ret, PatternVariables(ve.Target())...)
+			}
+		case *LetNode:
+			for _, ve := range n.VarExprList {
+				ret = append(ret, PatternVariables(ve.Target())...)
+			}
+		case *LoopNode:
+			ret = append(ret, n.Name)
+		case *MatchNode:
+			for _, c := range n.Cases {
+				ret = append(ret, PatternVariables(c.Pattern)...)
+			}
+		case *DataNode:
+			ret = append(ret, dataVariables(n)...)
+		}
+	})
+	return ret
+}
+
+// free returns the lexical names a rule refers to without binding them, either in its
+// pattern or in its template.
+func free(rule *MacroRule) map[string]bool {
+	bound := map[string]bool{"_": true}
+	for _, p := range rule.Pattern {
+		Walk(p, func(node ExprNode) {
+			switch n := node.(type) {
+			case *VariableNode:
+				bound[n.Name] = true
+			case *RestNode:
+				bound[n.Name] = true
+			}
+		})
+	}
+	for _, v := range binders(rule.Template) {
+		bound[v.Name] = true
+	}
+	names := make(map[*VariableNode]bool)
+	ret := make(map[string]bool)
+	Walk(rule.Template, func(node ExprNode) {
+		switch n := node.(type) {
+		case *AccessNode:
+			names[n.Variable] = true
+		case *RecordNode:
+			for _, f := range n.Fields {
+				names[f.Name] = true
+			}
+		case *VariableNode:
+			if !names[n] && !bound[n.Name] && n.Kind == Lexical {
+				ret[n.Name] = true
+			}
+		}
+	})
+	return ret
+}
+
+// dataVariables returns the variables bound by a declaration of `data`.
+func dataVariables(n *DataNode) []*VariableNode {
+	ret := []*VariableNode{}
+	for _, variant := range n.Variants {
+		ret = append(ret, variant.Name, variant.Predicate)
+	}
+	return append(ret, n.Predicate)
+}
+
+// copyData copies a declaration of `data` with the given expression, mapping the
+// variables it binds with fn.
+func copyData(n *DataNode, expr ExprNode, fn func(*VariableNode) (*VariableNode, *file.Error)) (ExprNode, *file.Error) {
+	copyVariable := func(v *VariableNode) *VariableNode {
+		return NewVariableNode(v.Location, v.Name, v.Kind)
+	}
+	variants := []*DataVariant{}
+	for _, variant := range n.Variants {
+		name, err := fn(variant.Name)
+		if err != nil {
+			return nil, err
+		}
+		predicate, err := fn(variant.Predicate)
+		if err != nil {
+			return nil, err
+		}
+		fields := []*VariableNode{}
+		for _, f := range variant.Fields {
+			fields = append(fields, copyVariable(f))
+		}
+		variants = append(variants, &DataVariant{Name: name, Predicate: predicate, Fields: fields})
+	}
+	predicate, err := fn(n.Predicate)
+	if err != nil {
+		return nil, err
+	}
+	return NewDataNode(n.Location, copyVariable(n.Name), predicate, variants, expr), nil
+}
+
+func kind(name string) ScopeKind {
+	if unicode.IsUpper([]rune(name)[0]) {
+		return Dynamic
+	}
+	return Lexical
+}
+
+func copyPattern(pattern ExprNode) (ExprNode, *file.Error) {
+	return rebuild(pattern, copyPattern)
+}
+
+// asPattern copies an element of a macro use given as a pattern of `match`, which
+// can only be a variable or a literal.
+func asPattern(sl file.SourceLocation, node ExprNode) (ExprNode, *file.Error) {
+	switch node.(type) {
+	case *VariableNode, *NumberNode, *FloatNode, *BoolNode, *StringNode:
+		return copyPattern(node)
+	}
+	return nil, &file.Error{Location: sl, Message: "macro expansion produced an incorrect pattern"}
+}
+
+// rebuild copies a node, building its children with fn.
+func rebuild(node ExprNode, fn func(ExprNode) (ExprNode, *file.Error)) (ExprNode, *file.Error) {
+	list := func(nodes []ExprNode) ([]ExprNode, *file.Error) {
+		ret := []ExprNode{}
+		for _, node := range nodes {
+			node, err := fn(node)
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, node)
+		}
+		return ret, nil
+	}
+	sl := node.GetLocation()
+	switch n := node.(type) {
+	case *NumberNode:
+		return NewNumberNode(sl, n.Numerator, n.Denominator), nil
+	case *FloatNode:
+		return NewFloatNode(sl, n.Value), nil
+	case *BoolNode:
+		return NewBoolNode(sl, n.Value), nil
+	case *StringNode:
+		return NewStringNode(sl, n.Value), nil
+	case *IntrinsicNode:
+		return NewIntrinsicNode(sl, n.Name), nil
+	case *VariableNode:
+		return NewVariableNode(sl, n.Name, n.Kind), nil
+	case *RestNode:
+		return NewRestNode(sl, n.Name), nil
+	case *LambdaNode:
+		var defaults []ExprNode
+		for _, d := range n.Defaults {
+			d, err := fn(d)
+			if err != nil {
+				return nil, err
+			}
+			defaults = append(defaults, d)
+		}
+		expr, err := fn(n.Expr)
+		if err != nil {
+			return nil, err
+		}
+		return NewLambdaNodeWithParams(sl, n.VarList, LambdaParams{Patterns: n.Patterns, Defaults: defaults, Rest: n.Rest}, expr), nil
+	case *LetrecNode:
+		varExprList := []*LetrecVarExprItem{}
+		for _, ve := range n.VarExprList {
+			expr, err := fn(ve.Expr)
+			if err != nil {
+				return nil, err
+			}
+			varExprList = append(varExprList, &LetrecVarExprItem{Variable: ve.Variable, Pattern: ve.Pattern, Expr: expr})
+		}
+		expr, err := fn(n.Expr)
+		if err != nil {
+			return nil, err
+		}
+		return NewLetrecNode(sl, varExprList, expr), nil
+	case *LetNode:
+		varExprList := []*LetrecVarExprItem{}
+		for _, ve := range n.VarExprList {
+			expr, err := fn(ve.Expr)
+			if err != nil {
+				return nil, err
+			}
+			varExprList = append(varExprList, &LetrecVarExprItem{Variable: ve.Variable, Pattern: ve.Pattern, Expr: expr})
+		}
+		expr, err := fn(n.Expr)
+		if err != nil {
+			return nil, err
+		}
+		return NewLetNode(sl, n.Sequential, varExprList, expr), nil
+	case *LoopNode:
+		inits, err := list(n.Inits)
+		if err != nil {
+			return nil, err
+		}
+		fun, err := rebuild(n.Fun, fn)
+		if err != nil {
+			return nil, err
+		}
+		return NewLoopNode(sl, n.Name, inits, fun.(*LambdaNode)), nil
+	case *IfNode:
+		l, err := list([]ExprNode{n.Cond, n.Branch1, n.Branch2})
+		if err != nil {
+			return nil, err
+		}
+		return NewIfNode(sl, l[0], l[1], l[2]), nil
+	case *CallNode:
+		l, err := list(append([]ExprNode{n.Callee}, n.ArgList...))
+		if err != nil {
+			return nil, err
+		}
+		return NewCallNode(sl, l[0], l[1:]), nil
+	case *SequenceNode:
+		l, err := list(n.ExprList)
+		if err != nil {
+			return nil, err
+		}
+		return NewSequenceNode(sl, l), nil
+	case *AccessNode:
+		expr, err := fn(n.Expr)
+		if err != nil {
+			return nil, err
+		}
+		return NewAccessNode(sl, n.Variable, expr), nil
+	case *QuoteNode:
+		expr, err := fn(n.Expr)
+		if err != nil {
+			return nil, err
+		}
+		return NewQuoteNode(sl, expr), nil
+	case *UnquoteNode:
+		expr, err := fn(n.Expr)
+		if err != nil {
+			return nil, err
+		}
+		return NewUnquoteNode(sl, expr, n.Splicing), nil
+	case *MacroNode:
+		rules := []*MacroRule{}
+		for _, rule := range n.Rules {
+			template, err := fn(rule.Template)
+			if err != nil {
+				return nil, err
+			}
+			rules = append(rules, &MacroRule{Name: rule.Name, Pattern: rule.Pattern, Template: template})
+		}
+		expr, err := fn(n.Expr)
+		if err != nil {
+			return nil, err
+		}
+		return NewMacroNode(sl, rules, expr), nil
+	case *RecordNode:
+		var expr ExprNode
+		if n.Expr != nil {
+			var err *file.Error
+			if expr, err = fn(n.Expr); err != nil {
+				return nil, err
+			}
+		}
+		fields := []*RecordField{}
+		for _, f := range n.Fields {
+			expr, err := fn(f.Expr)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, &RecordField{Name: NewVariableNode(f.Name.Location, f.Name.Name, f.Name.Kind), Expr: expr})
+		}
+		return NewRecordNode(sl, expr, fields), nil
+	case *MatchNode:
+		expr, err := fn(n.Expr)
+		if err != nil {
+			return nil, err
+		}
+		cases := []*MatchCase{}
+		for _, c := range n.Cases {
+			pattern, err := fn(c.Pattern)
+			if err != nil {
+				return nil, err
+			}
+			var guard ExprNode
+			if c.Guard != nil {
+				if guard, err = fn(c.Guard); err != nil {
+					return nil, err
+				}
+			}
+			expr, err := fn(c.Expr)
+			if err != nil {
+				return nil, err
+			}
+			cases = append(cases, &MatchCase{Pattern: pattern, Guard: guard, Expr: expr})
+		}
+		return NewMatchNode(sl, expr, cases), nil
+	case *DataNode:
+		expr, err := fn(n.Expr)
+		if err != nil {
+			return nil, err
+		}
+		return copyData(n, expr, func(v *VariableNode) (*VariableNode, *file.Error) {
+			return NewVariableNode(v.Location, v.Name, v.Kind), nil
+		})
+	case *CondNode:
+		cases := []*CondCase{}
+		for _, c := range n.Cases {
+			cond, err := fn(c.Cond)
+			if err != nil {
+				return nil, err
+			}
+			expr, err := fn(c.Expr)
+			if err != nil {
+				return nil, err
+			}
+			cases = append(cases, &CondCase{Cond: cond, Expr: expr})
+		}
+		var otherwise ExprNode
+		if n.Else != nil {
+			var err *file.Error
+			if otherwise, err = fn(n.Else); err != nil {
+				return nil, err
+			}
+		}
+		return NewCondNode(sl, cases, otherwise), nil
+	}
+	return node, nil
+}
@@ -0,0 +1,198 @@
+package macro
+
+import (
+	"testing"
+
+	"github.com/gogim1/goscript/ast"
+	"github.com/gogim1/goscript/file"
+	"github.com/gogim1/goscript/lexer"
+	"github.com/gogim1/goscript/parser"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func parse(t *testing.T, src string) ast.ExprNode {
+	tokens, err := lexer.Lex(file.NewSource(src))
+	require.Nil(t, err)
+
+	node, err := parser.Parse(tokens)
+	require.Nil(t, err)
+
+	return node
+}
+
+func TestExpand(t *testing.T) {
+	tests := []struct {
+		input, output string
+	}{
+		{`(add 1 2)`, `(add 1 2)`},
+		{
+			`macro (when (c @body) { if c then [@body] else (void) }) { (when (lt 1 2) (put "a") 1) }`,
+			`if (lt 1 2) then [(put "a") 1] else (void)`,
+		},
+		{
+			`macro (
+				mylet (body) { body }
+				mylet ((x v) @rest) { (lambda (x) { (mylet @rest) } v) }
+			) { (mylet (a 1) (b 2) (add a b)) }`,
+			`(lambda (a) { (lambda (b) { (add a b) } 2) } 1)`,
+		},
+		{
+			`macro (swap (a b) { letrec (tmp = a) { [(set a b) (set b tmp)] } }) { letrec (tmp = 1 x = 2) { (swap tmp x) } }`,
+			`letrec (tmp = 1 x = 2) { letrec (tmp%1 = tmp) { [(set tmp x) (set x tmp%1)] } }`,
+		},
+		{
+			`macro (with (body) { letrec (It = 1) { body } }) { (with (add It 1)) }`,
+			`letrec (It = 1) { (add It 1) }`,
+		},
+		{
+			`macro (m (1 x) { "one" } m (x) { "other" }) { [(m 1 2) (m 3)] }`,
+			`["one" "other"]`,
+		},
+		{
+			`macro (m ((add a b)) { (sub a b) }) { (m (add 3 2)) }`,
+			`(sub 3 2)`,
+		},
+		{
+			`macro (m () { 1 }) { [lambda (m) { (m) } (m)] }`,
+			`[lambda (m) { (m) } 1]`,
+		},
+		{
+			`macro (m () { 1 }) { [lambda (a b = (m) @m) { (m) } lambda (m = (m)) { m }] }`,
+			`[lambda (a b = 1 @m) { (m) } lambda (m = 1) { m }]`,
+		},
+		{
+			`macro (m (x) { lambda (a = x @r) { (mklist a x r) } }) { (m a) }`,
+			`lambda (a%1 = a @r%2) { (mklist a%1 a r%2) }`,
+		},
+		{
+			`macro (m (x) { letrec ([a @r] = x) { (mklist a r x) } }) { (m a) }`,
+			`letrec ([a%1 @r%2] = a) { (mklist a%1 r%2 a) }`,
+		},
+		{
+			`macro (m (x) { lambda ({a} [b _]) { (mklist a b x) } }) { (m b) }`,
+			`lambda ({a%1 = a%1} [b%2 _]) { (mklist a%1 b%2 b) }`,
+		},
+		{
+			`macro (m () { 1 }) { [letrec ([m] = (m)) { (m) } lambda ([m]) { m }] }`,
+			`[letrec ([m] = (m)) { (m) } lambda ([m]) { m }]`,
+		},
+		{
+			`macro (m (x) { let (a = 1) { (add a x) } }) { (m a) }`,
+			`let (a%1 = 1) { (add a%1 a) }`,
+		},
+		{
+			`macro (m (x) { loop (i = x) { (recur i) } }) { (m recur) }`,
+			`loop recur%1 (i%2 = recur) { (recur%1 i%2) }`,
+		},
+		{
+			`macro (m () { 1 }) { [let (m = (m)) { (m) } let* (m = (m) x = (m)) { x } loop m (x = (m)) { (m) }] }`,
+			`[let (m = 1) { (m) } let* (m = 1 x = (m)) { x } loop m (x = 1) { (m) }]`,
+		},
+		{
+			`macro (m () { 1 }) { [macro (m () { 2 }) { (m) } (m)] }`,
+			`[2 1]`,
+		},
+		{
+			"macro (m (x) { x }) { [`(m ,(m 1)) (m 2)] }",
+			"[`(m ,1) 2]",
+		},
+		{
+			`macro (get (r f) { &f r }) { (get o x) }`,
+			`&x o`,
+		},
+		{
+			`macro (first (l) { match l { [x @r] then x _ then (void) } }) { match (first y) { x then (m x) } }`,
+			`match match y { [x%1 @r%2] then x%1 _ then (void) } { x then (m x) }`,
+		},
+		{
+			`macro (is (v p) { match v { p then 1 _ then 0 } } m () { 1 }) { (is o "s") }`,
+			`match o { "s" then 1 _ then 0 }`,
+		},
+		{
+			`macro (m () { 1 }) { match 1 { m then (m) _ then (m) } }`,
+			`match 1 { m then (m) _ then 1 }`,
+		},
+		{
+			`macro (m (v) { data t (box (x)) { match v { (box y) then y _ then (isbox v) } } }) { (m box) }`,
+			`data t (box%1 (x)) { match box { (box%1 y%4) then y%4 _ then (isbox%2 box) } }`,
+		},
+		{
+			`macro (set (r f v) { {r | f = v} } m () { 1 }) { (set {x = (m)} x (m)) }`,
+			`{{x = 1} | x = 1}`,
+		},
+		{
+			`macro (m (x) { (add x 1) }) { cond { (m 1) then (m 2) else (m 3) } }`,
+			`cond { (add 1 1) then (add 2 1) else (add 3 1) }`,
+		},
+		{
+			`macro (m () { 1 }) { data t (m ()) { (m) } }`,
+			`data t (m ()) { (m) }`,
+		},
+		{
+			`macro (m (1e0) { "float" } m (1) { "number" }) { [(m 1) (m 10e-1)] }`,
+			`["number" "float"]`,
+		},
+		{
+			`letrec (x = 5) { macro (m () { x }) { let (x = 1) { [(m) x] } } }`,
+			`letrec (x = 5) { let (x%1 = 1) { [x x%1] } }`,
+		},
+		{
+			`macro (m () { (recur 1) }) { loop (i = 0) { [(m) (recur i)] } }`,
+			`loop recur%1 (i = 0) { [(recur 1) (recur%1 i)] }`,
+		},
+		{
+			`lambda (x) { macro (m () { x }) { lambda (x) { [(m) x] } } }`,
+			`lambda (x) { lambda (x%1) { [x x%1] } }`,
+		},
+		{
+			`macro (m () { x }) { lambda (x) { macro (n () { (m) }) { let (x = 1) { (n) } } } }`,
+			`lambda (x%1) { let (x%2 = 1) { x } }`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			node, err := Expand(parse(t, test.input))
+			require.Nil(t, err)
+			assert.Equal(t, test.output, ast.Format(node))
+		})
+	}
+}
+
+func TestExpand_error(t *testing.T) {
+	tests := []struct {
+		name, input string
+	}{
+		{
+			"no macro rule matches",
+			`macro (m (a) { a }) { (m 1 2) }`,
+		},
+		{
+			"duplicate pattern variable",
+			`macro (m (a a) { a }) { (m 1 2) }`,
+		},
+		{
+			"rest element must be the last one",
+			`macro (m (@a b) { b }) { (m 1 2) }`,
+		},
+		{
+			"incorrect variable",
+			`macro (m (a) { lambda (a) { a } }) { (m 1) }`,
+		},
+		{
+			"rest variable used as an expression",
+			`macro (m (@a) { a }) { (m 1) }`,
+		},
+		{
+			"expansion too deep",
+			`macro (m () { (m) }) { (m) }`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Expand(parse(t, test.input))
+			assert.NotNil(t, err)
+			t.Log(err)
+		})
+	}
+}
@@ -13,15 +13,24 @@ import (
 
 var intrinsics = [...]string{
 	"void", "id",
-	"isvoid", "isnum", "isstr", "isclo", "iscont",
+	"isvoid", "isnum", "isfloat", "isbool", "isstr", "isclo", "iscont", "ishost", "isweak", "isref", "iserr", "islist",
 	"add", "sub", "mul", "div", "gt", "ge", "lt", "le", "eq", "ne", "and", "or", "not",
-	"quote", "concat", "eval",
+	"floor", "ceil", "round", "quot", "mod", "pow", "abs", "min", "max", "numerator", "denominator", "todec",
+	"exact", "inexact", "sqrt", "exp", "log", "sin", "cos", "tan", "asin", "acos", "atan", "atan2",
+	"quote", "concat", "eval", "evalin", "errmsg",
+	"strlen", "substr", "index", "split", "join", "upper", "lower", "trim", "replace", "tonum", "tostr",
+	"iscode", "codekind", "codelen", "codeget", "codeval", "mkcode",
+	"mklist", "listlen", "listget",
+	"isrec", "recfields", "recget",
 	"getline", "put",
 	"reg", "go",
-	"callcc", "exit",
+	"callcc", "apply", "exit",
+	"finalize", "weakref", "weakget",
+	"ref", "deref", "setref",
 }
 
-func isIntrinsic(name string) bool {
+// IsIntrinsic reports whether name is reserved for an intrinsic function.
+func IsIntrinsic(name string) bool {
 	for _, intrinsic := range intrinsics {
 		if name == intrinsic {
 			return true
@@ -33,6 +42,11 @@ func isIntrinsic(name string) bool {
 type parser struct {
 	tokens    []*lexer.Token
 	currIndex int
+	// quoting is the number of quasiquotations enclosing the current token.
+	quoting int
+	// templating is set while parsing macro patterns and templates, where `@name`
+	// elements are allowed.
+	templating bool
 }
 
 func (p *parser) consume(predicate func(*lexer.Token) bool) (*lexer.Token, *file.Error) {
@@ -55,7 +69,7 @@ func (p *parser) consume(predicate func(*lexer.Token) bool) (*lexer.Token, *file
 	return currToken, nil
 }
 
-func (p *parser) parseNumber() (*NumberNode, *file.Error) {
+func (p *parser) parseNumber() (ExprNode, *file.Error) {
 	currToken, err := p.consume(func(token *lexer.Token) bool {
 		return len(token.Source) > 0 && token.Kind == lexer.Number
 	})
@@ -65,22 +79,49 @@ func (p *parser) parseNumber() (*NumberNode, *file.Error) {
 
 	source := currToken.Source
 
+	if strings.ContainsAny(source, "eE") {
+		f, err := strconv.ParseFloat(source, 64)
+		if err != nil {
+			return nil, &file.Error{Location: currToken.Location, Message: "float literal out of range"}
+		}
+		return NewFloatNode(currToken.Location, f), nil
+	}
+	outOfRange := &file.Error{Location: currToken.Location, Message: "number literal out of range"}
 	if strings.Contains(source, "/") {
 		items := strings.Split(source, "/")
-		n, _ := strconv.Atoi(items[0])
-		d, _ := strconv.Atoi(items[1])
+		n, err1 := strconv.Atoi(items[0])
+		d, err2 := strconv.Atoi(items[1])
+		if err1 != nil || err2 != nil {
+			return nil, outOfRange
+		}
 		return NewNumberNode(currToken.Location, n, d), nil
 	} else if strings.Contains(source, ".") {
 		items := strings.Split(source, ".")
-		n, _ := strconv.Atoi(items[0] + items[1])
+		n, err := strconv.Atoi(items[0] + items[1])
 		d := math.Pow10(len(items[1]))
+		if err != nil || d > math.MaxInt64 {
+			return nil, outOfRange
+		}
 		return NewNumberNode(currToken.Location, n, int(d)), nil
 	} else {
-		n, _ := strconv.Atoi(source)
+		n, err := strconv.Atoi(source)
+		if err != nil {
+			return nil, outOfRange
+		}
 		return NewNumberNode(currToken.Location, n, 1), nil
 	}
 }
 
+func (p *parser) parseBool() (*BoolNode, *file.Error) {
+	currToken, err := p.consume(func(token *lexer.Token) bool {
+		return token.Source == "true" || token.Source == "false"
+	})
+	if err != nil {
+		return nil, err
+	}
+	return NewBoolNode(currToken.Location, currToken.Source == "true"), nil
+}
+
 func (p *parser) parseString() (*StringNode, *file.Error) {
 	currToken, err := p.consume(func(token *lexer.Token) bool {
 		return len(token.Source) > 0 && token.Source[0] == '"'
@@ -129,14 +170,51 @@ func (p *parser) parseLambda() (*LambdaNode, *file.Error) {
 	}
 
 	varList := []*VariableNode{}
+	patterns := []ExprNode{}
+	destructuring := false
+	names := make(map[string]bool)
+	var defaults []ExprNode
+	var rest *VariableNode
 	for p.currIndex < len(p.tokens) {
 		currToken := p.tokens[p.currIndex]
-		if len(currToken.Source) > 0 && currToken.Kind == lexer.Identifier {
+		if (len(currToken.Source) > 0 && currToken.Kind == lexer.Identifier) || isDestructuring(currToken) {
+			var node *VariableNode
+			if isDestructuring(currToken) {
+				pattern, err := p.parsePattern(names)
+				if err != nil {
+					return nil, err
+				}
+				node = NewVariableNode(pattern.GetLocation(), "_", Lexical)
+				patterns = append(patterns, pattern)
+				destructuring = true
+			} else {
+				node, err = p.parseVariable()
+				if err != nil {
+					return nil, err
+				}
+				patterns = append(patterns, nil)
+			}
+			varList = append(varList, node)
+			if p.currIndex < len(p.tokens) && p.tokens[p.currIndex].Source == "=" {
+				p.currIndex++
+				expr, err := p.parseExpr()
+				if err != nil {
+					return nil, err
+				}
+				defaults = append(defaults, expr)
+			} else if len(defaults) != 0 {
+				return nil, &file.Error{Location: node.Location, Message: "required parameter after an optional one"}
+			}
+		} else if currToken.Source == "@" {
+			p.currIndex++
 			node, err := p.parseVariable()
 			if err != nil {
 				return nil, err
 			}
-			varList = append(varList, node)
+			if p.currIndex < len(p.tokens) && p.tokens[p.currIndex].Source != ")" {
+				return nil, &file.Error{Location: currToken.Location, Message: "rest parameter must be the last one"}
+			}
+			rest = node
 		} else {
 			break
 		}
@@ -160,7 +238,16 @@ func (p *parser) parseLambda() (*LambdaNode, *file.Error) {
 		return nil, err
 	}
 
-	return NewLambdaNode(start.Location, varList, expr), nil
+	if !destructuring {
+		patterns = nil
+	}
+	return NewLambdaNodeWithParams(start.Location, varList, LambdaParams{Patterns: patterns, Defaults: defaults, Rest: rest}, expr), nil
+}
+
+// isDestructuring reports whether a token starts a pattern destructuring the value
+// of a binding, rather than a variable.
+func isDestructuring(token *lexer.Token) bool {
+	return token.Source == "[" || token.Source == "{" || token.Source == "(" || token.Source == "_"
 }
 
 func (p *parser) parseLetrec() (*LetrecNode, *file.Error) {
@@ -168,7 +255,94 @@ func (p *parser) parseLetrec() (*LetrecNode, *file.Error) {
 	if err != nil {
 		return nil, err
 	}
-	_, err = p.consume(func(token *lexer.Token) bool { return token.Source == "(" })
+	varExprList, err := p.parseBindings()
+	if err != nil {
+		return nil, err
+	}
+	expr, err := p.parseBody()
+	if err != nil {
+		return nil, err
+	}
+	return NewLetrecNode(start.Location, varExprList, expr), nil
+}
+
+func (p *parser) parseLet() (*LetNode, *file.Error) {
+	start, err := p.consume(func(token *lexer.Token) bool { return token.Source == "let" || token.Source == "let*" })
+	if err != nil {
+		return nil, err
+	}
+	varExprList, err := p.parseBindings()
+	if err != nil {
+		return nil, err
+	}
+	// the variables of `let` are bound at once, whereas those of `let*` may shadow
+	// the previous ones.
+	if start.Source == "let" {
+		names := make(map[string]bool)
+		for _, ve := range varExprList {
+			for _, v := range PatternVariables(ve.Target()) {
+				if names[v.Name] {
+					return nil, &file.Error{Location: v.Location, Message: "duplicate binder"}
+				}
+				names[v.Name] = true
+			}
+		}
+	}
+	expr, err := p.parseBody()
+	if err != nil {
+		return nil, err
+	}
+	return NewLetNode(start.Location, start.Source == "let*", varExprList, expr), nil
+}
+
+// parseLoop parses `loop name (param = init ...) { body }`, the name being `recur`
+// when omitted. The loop is compiled to a lambda of the parameters, which is called
+// with the initial values.
+func (p *parser) parseLoop() (*LoopNode, *file.Error) {
+	start, err := p.consume(func(token *lexer.Token) bool { return token.Source == "loop" })
+	if err != nil {
+		return nil, err
+	}
+	name := NewVariableNode(start.Location, "recur", Lexical)
+	if p.currIndex < len(p.tokens) && p.tokens[p.currIndex].Kind == lexer.Identifier {
+		if name, err = p.parseVariable(); err != nil {
+			return nil, err
+		}
+	}
+	varExprList, err := p.parseBindings()
+	if err != nil {
+		return nil, err
+	}
+	expr, err := p.parseBody()
+	if err != nil {
+		return nil, err
+	}
+
+	varList := []*VariableNode{}
+	patterns := []ExprNode{}
+	destructuring := false
+	inits := []ExprNode{}
+	for _, ve := range varExprList {
+		if ve.Pattern != nil {
+			varList = append(varList, NewVariableNode(ve.Pattern.GetLocation(), "_", Lexical))
+			destructuring = true
+		} else {
+			varList = append(varList, ve.Variable)
+		}
+		patterns = append(patterns, ve.Pattern)
+		inits = append(inits, ve.Expr)
+	}
+	if !destructuring {
+		patterns = nil
+	}
+	fun := NewLambdaNodeWithParams(start.Location, varList, LambdaParams{Patterns: patterns}, expr)
+	return NewLoopNode(start.Location, name, inits, fun), nil
+}
+
+// parseBindings parses the parenthesized `var = expr` bindings of `letrec`, `let`,
+// `let*` and `loop`, where a pattern may stand for the variable.
+func (p *parser) parseBindings() ([]*LetrecVarExprItem, *file.Error) {
+	_, err := p.consume(func(token *lexer.Token) bool { return token.Source == "(" })
 	if err != nil {
 		return nil, err
 	}
@@ -193,6 +367,23 @@ func (p *parser) parseLetrec() (*LetrecNode, *file.Error) {
 				Variable: v,
 				Expr:     e,
 			})
+		} else if isDestructuring(currToken) {
+			pattern, err := p.parsePattern(make(map[string]bool))
+			if err != nil {
+				return nil, err
+			}
+			_, err = p.consume(func(token *lexer.Token) bool { return token.Source == "=" })
+			if err != nil {
+				return nil, err
+			}
+			e, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			varExprList = append(varExprList, &LetrecVarExprItem{
+				Pattern: pattern,
+				Expr:    e,
+			})
 		} else {
 			break
 		}
@@ -202,8 +393,12 @@ func (p *parser) parseLetrec() (*LetrecNode, *file.Error) {
 	if err != nil {
 		return nil, err
 	}
+	return varExprList, nil
+}
 
-	_, err = p.consume(func(token *lexer.Token) bool { return token.Source == "{" })
+// parseBody parses an expression enclosed in braces.
+func (p *parser) parseBody() (ExprNode, *file.Error) {
+	_, err := p.consume(func(token *lexer.Token) bool { return token.Source == "{" })
 	if err != nil {
 		return nil, err
 	}
@@ -217,7 +412,7 @@ func (p *parser) parseLetrec() (*LetrecNode, *file.Error) {
 	if err != nil {
 		return nil, err
 	}
-	return NewLetrecNode(start.Location, varExprList, expr), nil
+	return expr, nil
 }
 
 func (p *parser) parseIf() (*IfNode, *file.Error) {
@@ -256,7 +451,7 @@ func (p *parser) parseIntrinsic() (*IntrinsicNode, *file.Error) {
 		return nil, err
 	}
 
-	if !isIntrinsic(currToken.Source) {
+	if !IsIntrinsic(currToken.Source) {
 		return nil, &file.Error{Location: currToken.Location, Message: "incorrect intrinsic"}
 	}
 	return NewIntrinsicNode(currToken.Location, currToken.Source), nil
@@ -270,7 +465,7 @@ func (p *parser) parseVariable() (*VariableNode, *file.Error) {
 		return nil, err
 	}
 
-	if isIntrinsic(currToken.Source) {
+	if IsIntrinsic(currToken.Source) {
 		return nil, &file.Error{Location: currToken.Location, Message: "incorrect variable name"}
 	}
 
@@ -293,7 +488,7 @@ func (p *parser) parseCall() (*CallNode, *file.Error) {
 	currToken := p.tokens[p.currIndex]
 
 	var callee ExprNode
-	if isIntrinsic(currToken.Source) {
+	if IsIntrinsic(currToken.Source) {
 		callee, err = p.parseIntrinsic()
 		if err != nil {
 			return nil, err
@@ -309,7 +504,7 @@ func (p *parser) parseCall() (*CallNode, *file.Error) {
 	for p.currIndex < len(p.tokens) {
 		currToken = p.tokens[p.currIndex]
 		if currToken.Source != ")" {
-			arg, err := p.parseExpr()
+			arg, err := p.parseElement()
 			if err != nil {
 				return nil, err
 			}
@@ -336,7 +531,7 @@ func (p *parser) parseSequence() (*SequenceNode, *file.Error) {
 	for p.currIndex < len(p.tokens) {
 		currToken := p.tokens[p.currIndex]
 		if currToken.Source != "]" {
-			expr, err := p.parseExpr()
+			expr, err := p.parseElement()
 			if err != nil {
 				return nil, err
 			}
@@ -382,6 +577,538 @@ func (p *parser) parseAccess() (*AccessNode, *file.Error) {
 	return NewAccessNode(start.Location, variable, expr), nil
 }
 
+func (p *parser) parseRecord() (*RecordNode, *file.Error) {
+	start, err := p.consume(func(token *lexer.Token) bool { return token.Source == "{" })
+	if err != nil {
+		return nil, err
+	}
+
+	// an update starts with the expression of the updated record followed by `|`.
+	var expr ExprNode
+	if p.currIndex < len(p.tokens) && p.tokens[p.currIndex].Source != "}" &&
+		(p.tokens[p.currIndex].Kind != lexer.Identifier ||
+			(p.currIndex+1 < len(p.tokens) && p.tokens[p.currIndex+1].Source == "|")) {
+		expr, err = p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		_, err = p.consume(func(token *lexer.Token) bool { return token.Source == "|" })
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	fields := []*RecordField{}
+	for p.currIndex < len(p.tokens) && p.tokens[p.currIndex].Source != "}" {
+		name, err := p.parseVariable()
+		if err != nil {
+			return nil, err
+		}
+		if name.Kind != Lexical {
+			return nil, &file.Error{Location: name.Location, Message: "incorrect field name"}
+		}
+		for _, f := range fields {
+			if f.Name.Name == name.Name {
+				return nil, &file.Error{Location: name.Location, Message: "duplicate field"}
+			}
+		}
+		var value ExprNode = NewVariableNode(name.Location, name.Name, name.Kind)
+		if p.currIndex < len(p.tokens) && p.tokens[p.currIndex].Source == "=" {
+			p.currIndex++
+			value, err = p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+		}
+		fields = append(fields, &RecordField{Name: name, Expr: value})
+	}
+	_, err = p.consume(func(token *lexer.Token) bool { return token.Source == "}" })
+	if err != nil {
+		return nil, err
+	}
+
+	return NewRecordNode(start.Location, expr, fields), nil
+}
+
+func (p *parser) parseQuote() (*QuoteNode, *file.Error) {
+	start, err := p.consume(func(token *lexer.Token) bool { return token.Source == "`" })
+	if err != nil {
+		return nil, err
+	}
+	p.quoting++
+	expr, err := p.parseExpr()
+	p.quoting--
+	if err != nil {
+		return nil, err
+	}
+	return NewQuoteNode(start.Location, expr), nil
+}
+
+func (p *parser) parseUnquote(splicing bool) (*UnquoteNode, *file.Error) {
+	start, err := p.consume(func(token *lexer.Token) bool { return token.Source == "," })
+	if err != nil {
+		return nil, err
+	}
+	if p.quoting == 0 {
+		return nil, &file.Error{Location: start.Location, Message: "unquote outside of quasiquote"}
+	}
+	isSplicing := p.currIndex < len(p.tokens) && p.tokens[p.currIndex].Source == "@"
+	if isSplicing {
+		if !splicing {
+			return nil, &file.Error{Location: start.Location, Message: "unquote splicing outside of a sequence or an argument list"}
+		}
+		p.currIndex++
+	}
+
+	// the unquoted expression is evaluated outside of the innermost quasiquotation.
+	p.quoting--
+	expr, err := p.parseExpr()
+	p.quoting++
+	if err != nil {
+		return nil, err
+	}
+	return NewUnquoteNode(start.Location, expr, isSplicing), nil
+}
+
+// parseElement parses an element of a sequence or an argument list, which can be
+// an unquote splicing.
+func (p *parser) parseElement() (ExprNode, *file.Error) {
+	if p.currIndex < len(p.tokens) && p.tokens[p.currIndex].Source == "," {
+		return p.parseUnquote(true)
+	}
+	if p.templating && p.currIndex < len(p.tokens) && p.tokens[p.currIndex].Source == "@" {
+		return p.parseRest()
+	}
+	return p.parseExpr()
+}
+
+func (p *parser) parseRest() (*RestNode, *file.Error) {
+	start, err := p.consume(func(token *lexer.Token) bool { return token.Source == "@" })
+	if err != nil {
+		return nil, err
+	}
+	variable, err := p.parseVariable()
+	if err != nil {
+		return nil, err
+	}
+	return NewRestNode(start.Location, variable.Name), nil
+}
+
+func (p *parser) parseMacro() (*MacroNode, *file.Error) {
+	start, err := p.consume(func(token *lexer.Token) bool { return token.Source == "macro" })
+	if err != nil {
+		return nil, err
+	}
+	_, err = p.consume(func(token *lexer.Token) bool { return token.Source == "(" })
+	if err != nil {
+		return nil, err
+	}
+
+	rules := []*MacroRule{}
+	for p.currIndex < len(p.tokens) {
+		currToken := p.tokens[p.currIndex]
+		if len(currToken.Source) > 0 && currToken.Kind == lexer.Identifier {
+			rule, err := p.parseMacroRule()
+			if err != nil {
+				return nil, err
+			}
+			rules = append(rules, rule)
+		} else {
+			break
+		}
+	}
+
+	_, err = p.consume(func(token *lexer.Token) bool { return token.Source == ")" })
+	if err != nil {
+		return nil, err
+	}
+	_, err = p.consume(func(token *lexer.Token) bool { return token.Source == "{" })
+	if err != nil {
+		return nil, err
+	}
+
+	expr, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = p.consume(func(token *lexer.Token) bool { return token.Source == "}" })
+	if err != nil {
+		return nil, err
+	}
+	return NewMacroNode(start.Location, rules, expr), nil
+}
+
+func (p *parser) parseMacroRule() (*MacroRule, *file.Error) {
+	name, err := p.parseVariable()
+	if err != nil {
+		return nil, err
+	}
+	if name.Kind != Lexical {
+		return nil, &file.Error{Location: name.Location, Message: "incorrect macro name"}
+	}
+	_, err = p.consume(func(token *lexer.Token) bool { return token.Source == "(" })
+	if err != nil {
+		return nil, err
+	}
+
+	templating := p.templating
+	p.templating = true
+	defer func() {
+		p.templating = templating
+	}()
+
+	pattern := []ExprNode{}
+	for p.currIndex < len(p.tokens) {
+		currToken := p.tokens[p.currIndex]
+		if currToken.Source != ")" {
+			element, err := p.parseElement()
+			if err != nil {
+				return nil, err
+			}
+			pattern = append(pattern, element)
+		} else {
+			break
+		}
+	}
+	_, err = p.consume(func(token *lexer.Token) bool { return token.Source == ")" })
+	if err != nil {
+		return nil, err
+	}
+	_, err = p.consume(func(token *lexer.Token) bool { return token.Source == "{" })
+	if err != nil {
+		return nil, err
+	}
+
+	template, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = p.consume(func(token *lexer.Token) bool { return token.Source == "}" })
+	if err != nil {
+		return nil, err
+	}
+	return &MacroRule{Name: name, Pattern: pattern, Template: template}, nil
+}
+
+func (p *parser) parseData() (*DataNode, *file.Error) {
+	start, err := p.consume(func(token *lexer.Token) bool { return token.Source == "data" })
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]bool)
+	// predicate returns the variable bound to the predicate of a type or a variant.
+	predicate := func(v *VariableNode, message string) (*VariableNode, *file.Error) {
+		name := "is" + v.Name
+		if v.Kind != Lexical || IsIntrinsic(name) || names[v.Name] || names[name] {
+			return nil, &file.Error{Location: v.Location, Message: message}
+		}
+		return NewVariableNode(v.Location, name, Lexical), nil
+	}
+
+	name, err := p.parseVariable()
+	if err != nil {
+		return nil, err
+	}
+	typePredicate, err := predicate(name, "incorrect data type name")
+	if err != nil {
+		return nil, err
+	}
+	names[typePredicate.Name] = true
+
+	_, err = p.consume(func(token *lexer.Token) bool { return token.Source == "(" })
+	if err != nil {
+		return nil, err
+	}
+	variants := []*DataVariant{}
+	for p.currIndex < len(p.tokens) && p.tokens[p.currIndex].Source != ")" {
+		variant, err := p.parseVariable()
+		if err != nil {
+			return nil, err
+		}
+		variantPredicate, err := predicate(variant, "incorrect constructor name")
+		if err != nil {
+			return nil, err
+		}
+		names[variant.Name] = true
+		names[variantPredicate.Name] = true
+
+		_, err = p.consume(func(token *lexer.Token) bool { return token.Source == "(" })
+		if err != nil {
+			return nil, err
+		}
+		fields := []*VariableNode{}
+		for p.currIndex < len(p.tokens) && p.tokens[p.currIndex].Source != ")" {
+			field, err := p.parseVariable()
+			if err != nil {
+				return nil, err
+			}
+			if field.Kind != Lexical {
+				return nil, &file.Error{Location: field.Location, Message: "incorrect field name"}
+			}
+			for _, f := range fields {
+				if f.Name == field.Name {
+					return nil, &file.Error{Location: field.Location, Message: "duplicate field"}
+				}
+			}
+			fields = append(fields, field)
+		}
+		_, err = p.consume(func(token *lexer.Token) bool { return token.Source == ")" })
+		if err != nil {
+			return nil, err
+		}
+		variants = append(variants, &DataVariant{Name: variant, Predicate: variantPredicate, Fields: fields})
+	}
+	end, err := p.consume(func(token *lexer.Token) bool { return token.Source == ")" })
+	if err != nil {
+		return nil, err
+	}
+	if len(variants) == 0 {
+		return nil, &file.Error{Location: end.Location, Message: "zero-length data type"}
+	}
+
+	_, err = p.consume(func(token *lexer.Token) bool { return token.Source == "{" })
+	if err != nil {
+		return nil, err
+	}
+	expr, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	_, err = p.consume(func(token *lexer.Token) bool { return token.Source == "}" })
+	if err != nil {
+		return nil, err
+	}
+	return NewDataNode(start.Location, name, typePredicate, variants, expr), nil
+}
+
+func (p *parser) parseCond() (*CondNode, *file.Error) {
+	start, err := p.consume(func(token *lexer.Token) bool { return token.Source == "cond" })
+	if err != nil {
+		return nil, err
+	}
+	_, err = p.consume(func(token *lexer.Token) bool { return token.Source == "{" })
+	if err != nil {
+		return nil, err
+	}
+
+	cases := []*CondCase{}
+	for p.currIndex < len(p.tokens) && p.tokens[p.currIndex].Source != "}" && p.tokens[p.currIndex].Source != "else" {
+		cond, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		_, err = p.consume(func(token *lexer.Token) bool { return token.Source == "then" })
+		if err != nil {
+			return nil, err
+		}
+		expr, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		cases = append(cases, &CondCase{Cond: cond, Expr: expr})
+	}
+	var otherwise ExprNode
+	if p.currIndex < len(p.tokens) && p.tokens[p.currIndex].Source == "else" {
+		p.currIndex++
+		otherwise, err = p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+	}
+	end, err := p.consume(func(token *lexer.Token) bool { return token.Source == "}" })
+	if err != nil {
+		return nil, err
+	}
+	if len(cases) == 0 && otherwise == nil {
+		return nil, &file.Error{Location: end.Location, Message: "zero-length cond"}
+	}
+	return NewCondNode(start.Location, cases, otherwise), nil
+}
+
+func (p *parser) parseMatch() (*MatchNode, *file.Error) {
+	start, err := p.consume(func(token *lexer.Token) bool { return token.Source == "match" })
+	if err != nil {
+		return nil, err
+	}
+	expr, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	_, err = p.consume(func(token *lexer.Token) bool { return token.Source == "{" })
+	if err != nil {
+		return nil, err
+	}
+
+	cases := []*MatchCase{}
+	for p.currIndex < len(p.tokens) && p.tokens[p.currIndex].Source != "}" {
+		pattern, err := p.parsePattern(make(map[string]bool))
+		if err != nil {
+			return nil, err
+		}
+		var guard ExprNode
+		if p.currIndex < len(p.tokens) && p.tokens[p.currIndex].Source == "if" {
+			p.currIndex++
+			guard, err = p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+		}
+		_, err = p.consume(func(token *lexer.Token) bool { return token.Source == "then" })
+		if err != nil {
+			return nil, err
+		}
+		expr, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		cases = append(cases, &MatchCase{Pattern: pattern, Guard: guard, Expr: expr})
+	}
+	end, err := p.consume(func(token *lexer.Token) bool { return token.Source == "}" })
+	if err != nil {
+		return nil, err
+	}
+	if len(cases) == 0 {
+		return nil, &file.Error{Location: end.Location, Message: "zero-length match"}
+	}
+	return NewMatchNode(start.Location, expr, cases), nil
+}
+
+// parsePattern parses a pattern of `match`. names collects the variables bound by
+// the whole pattern, which must be distinct.
+func (p *parser) parsePattern(names map[string]bool) (ExprNode, *file.Error) {
+	if p.currIndex >= len(p.tokens) {
+		return nil, &file.Error{
+			Location: file.SourceLocation{Line: -1, Col: -1},
+			Message:  "incomplete token stream",
+		}
+	}
+	bind := func(v *VariableNode) *file.Error {
+		if names[v.Name] {
+			return &file.Error{Location: v.Location, Message: "duplicate pattern variable"}
+		}
+		names[v.Name] = true
+		return nil
+	}
+
+	currToken := p.tokens[p.currIndex]
+	if len(currToken.Source) > 0 && currToken.Kind == lexer.Number {
+		return p.parseNumber()
+	} else if currToken.Source == "true" || currToken.Source == "false" {
+		return p.parseBool()
+	} else if len(currToken.Source) > 0 && currToken.Kind == lexer.String {
+		return p.parseString()
+	} else if currToken.Source == "_" {
+		p.currIndex++
+		return NewVariableNode(currToken.Location, "_", Lexical), nil
+	} else if len(currToken.Source) > 0 && currToken.Kind == lexer.Identifier {
+		v, err := p.parseVariable()
+		if err != nil {
+			return nil, err
+		}
+		if err := bind(v); err != nil {
+			return nil, err
+		}
+		return v, nil
+	} else if currToken.Source == "(" {
+		// either `(void)` or a constructor applied to patterns.
+		p.currIndex++
+		if p.currIndex < len(p.tokens) && IsIntrinsic(p.tokens[p.currIndex].Source) {
+			callee, err := p.parseIntrinsic()
+			if err != nil {
+				return nil, err
+			}
+			if callee.Name != "void" {
+				return nil, &file.Error{Location: callee.Location, Message: "unsupported pattern"}
+			}
+			_, err = p.consume(func(token *lexer.Token) bool { return token.Source == ")" })
+			if err != nil {
+				return nil, err
+			}
+			return NewCallNode(currToken.Location, callee, []ExprNode{}), nil
+		}
+		callee, err := p.parseVariable()
+		if err != nil {
+			return nil, err
+		}
+		argList := []ExprNode{}
+		for p.currIndex < len(p.tokens) && p.tokens[p.currIndex].Source != ")" {
+			arg, err := p.parsePattern(names)
+			if err != nil {
+				return nil, err
+			}
+			argList = append(argList, arg)
+		}
+		_, err = p.consume(func(token *lexer.Token) bool { return token.Source == ")" })
+		if err != nil {
+			return nil, err
+		}
+		return NewCallNode(currToken.Location, callee, argList), nil
+	} else if currToken.Source == "[" {
+		p.currIndex++
+		elements := []ExprNode{}
+		for p.currIndex < len(p.tokens) && p.tokens[p.currIndex].Source != "]" {
+			var element ExprNode
+			if p.tokens[p.currIndex].Source == "@" {
+				rest, err := p.parseRest()
+				if err != nil {
+					return nil, err
+				}
+				if p.currIndex < len(p.tokens) && p.tokens[p.currIndex].Source != "]" {
+					return nil, &file.Error{Location: rest.Location, Message: "rest element must be the last one"}
+				}
+				if err := bind(NewVariableNode(rest.Location, rest.Name, Lexical)); err != nil {
+					return nil, err
+				}
+				element = rest
+			} else {
+				e, err := p.parsePattern(names)
+				if err != nil {
+					return nil, err
+				}
+				element = e
+			}
+			elements = append(elements, element)
+		}
+		_, err := p.consume(func(token *lexer.Token) bool { return token.Source == "]" })
+		if err != nil {
+			return nil, err
+		}
+		return NewSequenceNode(currToken.Location, elements), nil
+	} else if currToken.Source == "{" {
+		p.currIndex++
+		fields := []*RecordField{}
+		for p.currIndex < len(p.tokens) && p.tokens[p.currIndex].Source != "}" {
+			name, err := p.parseVariable()
+			if err != nil {
+				return nil, err
+			}
+			if name.Kind != Lexical {
+				return nil, &file.Error{Location: name.Location, Message: "incorrect field name"}
+			}
+			var pattern ExprNode = NewVariableNode(name.Location, name.Name, name.Kind)
+			if p.currIndex < len(p.tokens) && p.tokens[p.currIndex].Source == "=" {
+				p.currIndex++
+				pattern, err = p.parsePattern(names)
+				if err != nil {
+					return nil, err
+				}
+			} else if err := bind(pattern.(*VariableNode)); err != nil {
+				return nil, err
+			}
+			fields = append(fields, &RecordField{Name: name, Expr: pattern})
+		}
+		_, err := p.consume(func(token *lexer.Token) bool { return token.Source == "}" })
+		if err != nil {
+			return nil, err
+		}
+		return NewRecordNode(currToken.Location, nil, fields), nil
+	}
+	return nil, &file.Error{Location: currToken.Location, Message: "unrecognized pattern"}
+}
+
 func (p *parser) parseExpr() (ExprNode, *file.Error) {
 	if p.currIndex >= len(p.tokens) {
 		return nil, &file.Error{
@@ -393,14 +1120,28 @@ func (p *parser) parseExpr() (ExprNode, *file.Error) {
 	currToken := p.tokens[p.currIndex]
 	if len(currToken.Source) > 0 && currToken.Kind == lexer.Number {
 		return p.parseNumber()
+	} else if currToken.Source == "true" || currToken.Source == "false" {
+		return p.parseBool()
 	} else if len(currToken.Source) > 0 && currToken.Kind == lexer.String {
 		return p.parseString()
 	} else if currToken.Source == "lambda" {
 		return p.parseLambda()
 	} else if currToken.Source == "letrec" {
 		return p.parseLetrec()
+	} else if currToken.Source == "let" || currToken.Source == "let*" {
+		return p.parseLet()
+	} else if currToken.Source == "loop" {
+		return p.parseLoop()
 	} else if currToken.Source == "if" {
 		return p.parseIf()
+	} else if currToken.Source == "macro" {
+		return p.parseMacro()
+	} else if currToken.Source == "match" {
+		return p.parseMatch()
+	} else if currToken.Source == "data" {
+		return p.parseData()
+	} else if currToken.Source == "cond" {
+		return p.parseCond()
 	} else if len(currToken.Source) > 0 && currToken.Kind == lexer.Identifier {
 		return p.parseVariable()
 	} else if currToken.Source == "(" {
@@ -409,6 +1150,12 @@ func (p *parser) parseExpr() (ExprNode, *file.Error) {
 		return p.parseSequence()
 	} else if currToken.Source == "&" {
 		return p.parseAccess()
+	} else if currToken.Source == "{" {
+		return p.parseRecord()
+	} else if currToken.Source == "`" {
+		return p.parseQuote()
+	} else if currToken.Source == "," {
+		return p.parseUnquote(false)
 	} else {
 		return nil, &file.Error{Location: currToken.Location, Message: "unrecognized token"}
 	}
@@ -40,6 +40,27 @@ func TestParse(t *testing.T) {
 				Denominator: 20,
 			},
 		},
+		{
+			"-1.5e3",
+			&FloatNode{
+				Base:  Base{Location: file.SourceLocation{Line: 1, Col: 1}},
+				Value: -1500,
+			},
+		},
+		{
+			"true",
+			&BoolNode{
+				Base:  Base{Location: file.SourceLocation{Line: 1, Col: 1}},
+				Value: true,
+			},
+		},
+		{
+			"25E-2",
+			&FloatNode{
+				Base:  Base{Location: file.SourceLocation{Line: 1, Col: 1}},
+				Value: 0.25,
+			},
+		},
 		{
 			`"123.45"`,
 			&StringNode{
@@ -97,6 +118,179 @@ func TestParse(t *testing.T) {
 				},
 			},
 		},
+		{
+			`lambda (a b = a @c) { c }`,
+			&LambdaNode{
+				Base: Base{Location: file.SourceLocation{Line: 1, Col: 1}},
+				VarList: []*VariableNode{
+					{
+						Base: Base{Location: file.SourceLocation{Line: 1, Col: 9}},
+						Name: "a",
+						Kind: Lexical,
+					},
+					{
+						Base: Base{Location: file.SourceLocation{Line: 1, Col: 11}},
+						Name: "b",
+						Kind: Lexical,
+					},
+				},
+				Defaults: []ExprNode{
+					&VariableNode{
+						Base: Base{Location: file.SourceLocation{Line: 1, Col: 15}},
+						Name: "a",
+						Kind: Lexical,
+					},
+				},
+				Rest: &VariableNode{
+					Base: Base{Location: file.SourceLocation{Line: 1, Col: 18}},
+					Name: "c",
+					Kind: Lexical,
+				},
+				Expr: &VariableNode{
+					Base: Base{Location: file.SourceLocation{Line: 1, Col: 23}},
+					Name: "c",
+					Kind: Lexical,
+				},
+			},
+		},
+		{
+			`let* (a = 1) { a }`,
+			&LetNode{
+				Base:       Base{Location: file.SourceLocation{Line: 1, Col: 1}},
+				Sequential: true,
+				VarExprList: []*LetrecVarExprItem{
+					{
+						Variable: &VariableNode{
+							Base: Base{Location: file.SourceLocation{Line: 1, Col: 7}},
+							Name: "a",
+							Kind: Lexical,
+						},
+						Expr: &NumberNode{
+							Base:        Base{Location: file.SourceLocation{Line: 1, Col: 11}},
+							Numerator:   1,
+							Denominator: 1,
+						},
+					},
+				},
+				Expr: &VariableNode{
+					Base: Base{Location: file.SourceLocation{Line: 1, Col: 16}},
+					Name: "a",
+					Kind: Lexical,
+				},
+			},
+		},
+		{
+			`loop f (i = 0) { (f i) }`,
+			&LoopNode{
+				Base: Base{Location: file.SourceLocation{Line: 1, Col: 1}},
+				Name: &VariableNode{
+					Base: Base{Location: file.SourceLocation{Line: 1, Col: 6}},
+					Name: "f",
+					Kind: Lexical,
+				},
+				Inits: []ExprNode{
+					&NumberNode{
+						Base:        Base{Location: file.SourceLocation{Line: 1, Col: 13}},
+						Numerator:   0,
+						Denominator: 1,
+					},
+				},
+				Fun: &LambdaNode{
+					Base: Base{Location: file.SourceLocation{Line: 1, Col: 1}},
+					VarList: []*VariableNode{
+						{
+							Base: Base{Location: file.SourceLocation{Line: 1, Col: 9}},
+							Name: "i",
+							Kind: Lexical,
+						},
+					},
+					Expr: &CallNode{
+						Base: Base{Location: file.SourceLocation{Line: 1, Col: 18}},
+						Callee: &VariableNode{
+							Base: Base{Location: file.SourceLocation{Line: 1, Col: 19}},
+							Name: "f",
+							Kind: Lexical,
+						},
+						ArgList: []ExprNode{
+							&VariableNode{
+								Base: Base{Location: file.SourceLocation{Line: 1, Col: 21}},
+								Name: "i",
+								Kind: Lexical,
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			`letrec ([a @b] = c) { lambda ({d} e) { a } }`,
+			&LetrecNode{
+				Base: Base{Location: file.SourceLocation{Line: 1, Col: 1}},
+				VarExprList: []*LetrecVarExprItem{
+					{
+						Pattern: &SequenceNode{
+							Base: Base{Location: file.SourceLocation{Line: 1, Col: 9}},
+							ExprList: []ExprNode{
+								&VariableNode{
+									Base: Base{Location: file.SourceLocation{Line: 1, Col: 10}},
+									Name: "a",
+									Kind: Lexical,
+								},
+								&RestNode{
+									Base: Base{Location: file.SourceLocation{Line: 1, Col: 12}},
+									Name: "b",
+								},
+							},
+						},
+						Expr: &VariableNode{
+							Base: Base{Location: file.SourceLocation{Line: 1, Col: 18}},
+							Name: "c",
+							Kind: Lexical,
+						},
+					},
+				},
+				Expr: &LambdaNode{
+					Base: Base{Location: file.SourceLocation{Line: 1, Col: 23}},
+					VarList: []*VariableNode{
+						{
+							Base: Base{Location: file.SourceLocation{Line: 1, Col: 31}},
+							Name: "_",
+							Kind: Lexical,
+						},
+						{
+							Base: Base{Location: file.SourceLocation{Line: 1, Col: 35}},
+							Name: "e",
+							Kind: Lexical,
+						},
+					},
+					Patterns: []ExprNode{
+						&RecordNode{
+							Base: Base{Location: file.SourceLocation{Line: 1, Col: 31}},
+							Fields: []*RecordField{
+								{
+									Name: &VariableNode{
+										Base: Base{Location: file.SourceLocation{Line: 1, Col: 32}},
+										Name: "d",
+										Kind: Lexical,
+									},
+									Expr: &VariableNode{
+										Base: Base{Location: file.SourceLocation{Line: 1, Col: 32}},
+										Name: "d",
+										Kind: Lexical,
+									},
+								},
+							},
+						},
+						nil,
+					},
+					Expr: &VariableNode{
+						Base: Base{Location: file.SourceLocation{Line: 1, Col: 40}},
+						Name: "a",
+						Kind: Lexical,
+					},
+				},
+			},
+		},
 		{
 			`letrec () { 1/42 }`,
 			&LetrecNode{
@@ -210,6 +404,289 @@ func TestParse(t *testing.T) {
 				},
 			},
 		},
+		{
+			"`(a ,b ,@`[,c])",
+			&QuoteNode{
+				Base: Base{Location: file.SourceLocation{Line: 1, Col: 1}},
+				Expr: &CallNode{
+					Base: Base{Location: file.SourceLocation{Line: 1, Col: 2}},
+					Callee: &VariableNode{
+						Base: Base{Location: file.SourceLocation{Line: 1, Col: 3}},
+						Name: "a",
+						Kind: Lexical,
+					},
+					ArgList: []ExprNode{
+						&UnquoteNode{
+							Base: Base{Location: file.SourceLocation{Line: 1, Col: 5}},
+							Expr: &VariableNode{
+								Base: Base{Location: file.SourceLocation{Line: 1, Col: 6}},
+								Name: "b",
+								Kind: Lexical,
+							},
+						},
+						&UnquoteNode{
+							Base: Base{Location: file.SourceLocation{Line: 1, Col: 8}},
+							Expr: &QuoteNode{
+								Base: Base{Location: file.SourceLocation{Line: 1, Col: 10}},
+								Expr: &SequenceNode{
+									Base: Base{Location: file.SourceLocation{Line: 1, Col: 11}},
+									ExprList: []ExprNode{
+										&UnquoteNode{
+											Base: Base{Location: file.SourceLocation{Line: 1, Col: 12}},
+											Expr: &VariableNode{
+												Base: Base{Location: file.SourceLocation{Line: 1, Col: 13}},
+												Name: "c",
+												Kind: Lexical,
+											},
+										},
+									},
+								},
+							},
+							Splicing: true,
+						},
+					},
+				},
+			},
+		},
+		{
+			"macro (m (a @b) { [@b a] }) { (m 1) }",
+			&MacroNode{
+				Base: Base{Location: file.SourceLocation{Line: 1, Col: 1}},
+				Rules: []*MacroRule{
+					{
+						Name: &VariableNode{
+							Base: Base{Location: file.SourceLocation{Line: 1, Col: 8}},
+							Name: "m",
+							Kind: Lexical,
+						},
+						Pattern: []ExprNode{
+							&VariableNode{
+								Base: Base{Location: file.SourceLocation{Line: 1, Col: 11}},
+								Name: "a",
+								Kind: Lexical,
+							},
+							&RestNode{
+								Base: Base{Location: file.SourceLocation{Line: 1, Col: 13}},
+								Name: "b",
+							},
+						},
+						Template: &SequenceNode{
+							Base: Base{Location: file.SourceLocation{Line: 1, Col: 19}},
+							ExprList: []ExprNode{
+								&RestNode{
+									Base: Base{Location: file.SourceLocation{Line: 1, Col: 20}},
+									Name: "b",
+								},
+								&VariableNode{
+									Base: Base{Location: file.SourceLocation{Line: 1, Col: 23}},
+									Name: "a",
+									Kind: Lexical,
+								},
+							},
+						},
+					},
+				},
+				Expr: &CallNode{
+					Base: Base{Location: file.SourceLocation{Line: 1, Col: 31}},
+					Callee: &VariableNode{
+						Base: Base{Location: file.SourceLocation{Line: 1, Col: 32}},
+						Name: "m",
+						Kind: Lexical,
+					},
+					ArgList: []ExprNode{
+						&NumberNode{
+							Base:        Base{Location: file.SourceLocation{Line: 1, Col: 34}},
+							Numerator:   1,
+							Denominator: 1,
+						},
+					},
+				},
+			},
+		},
+		{
+			"match a { [_ @r] if r then 0 {f} then f }",
+			&MatchNode{
+				Base: Base{Location: file.SourceLocation{Line: 1, Col: 1}},
+				Expr: &VariableNode{
+					Base: Base{Location: file.SourceLocation{Line: 1, Col: 7}},
+					Name: "a",
+					Kind: Lexical,
+				},
+				Cases: []*MatchCase{
+					{
+						Pattern: &SequenceNode{
+							Base: Base{Location: file.SourceLocation{Line: 1, Col: 11}},
+							ExprList: []ExprNode{
+								&VariableNode{
+									Base: Base{Location: file.SourceLocation{Line: 1, Col: 12}},
+									Name: "_",
+									Kind: Lexical,
+								},
+								&RestNode{
+									Base: Base{Location: file.SourceLocation{Line: 1, Col: 14}},
+									Name: "r",
+								},
+							},
+						},
+						Guard: &VariableNode{
+							Base: Base{Location: file.SourceLocation{Line: 1, Col: 21}},
+							Name: "r",
+							Kind: Lexical,
+						},
+						Expr: &NumberNode{
+							Base:        Base{Location: file.SourceLocation{Line: 1, Col: 28}},
+							Numerator:   0,
+							Denominator: 1,
+						},
+					},
+					{
+						Pattern: &RecordNode{
+							Base: Base{Location: file.SourceLocation{Line: 1, Col: 30}},
+							Fields: []*RecordField{
+								{
+									Name: &VariableNode{
+										Base: Base{Location: file.SourceLocation{Line: 1, Col: 31}},
+										Name: "f",
+										Kind: Lexical,
+									},
+									Expr: &VariableNode{
+										Base: Base{Location: file.SourceLocation{Line: 1, Col: 31}},
+										Name: "f",
+										Kind: Lexical,
+									},
+								},
+							},
+						},
+						Expr: &VariableNode{
+							Base: Base{Location: file.SourceLocation{Line: 1, Col: 39}},
+							Name: "f",
+							Kind: Lexical,
+						},
+					},
+				},
+			},
+		},
+		{
+			"cond { a then 1 else b }",
+			&CondNode{
+				Base: Base{Location: file.SourceLocation{Line: 1, Col: 1}},
+				Cases: []*CondCase{
+					{
+						Cond: &VariableNode{
+							Base: Base{Location: file.SourceLocation{Line: 1, Col: 8}},
+							Name: "a",
+							Kind: Lexical,
+						},
+						Expr: &NumberNode{
+							Base:        Base{Location: file.SourceLocation{Line: 1, Col: 15}},
+							Numerator:   1,
+							Denominator: 1,
+						},
+					},
+				},
+				Else: &VariableNode{
+					Base: Base{Location: file.SourceLocation{Line: 1, Col: 22}},
+					Name: "b",
+					Kind: Lexical,
+				},
+			},
+		},
+		{
+			"{r | a = 1 b}",
+			&RecordNode{
+				Base: Base{Location: file.SourceLocation{Line: 1, Col: 1}},
+				Expr: &VariableNode{
+					Base: Base{Location: file.SourceLocation{Line: 1, Col: 2}},
+					Name: "r",
+					Kind: Lexical,
+				},
+				Fields: []*RecordField{
+					{
+						Name: &VariableNode{
+							Base: Base{Location: file.SourceLocation{Line: 1, Col: 6}},
+							Name: "a",
+							Kind: Lexical,
+						},
+						Expr: &NumberNode{
+							Base:        Base{Location: file.SourceLocation{Line: 1, Col: 10}},
+							Numerator:   1,
+							Denominator: 1,
+						},
+					},
+					{
+						Name: &VariableNode{
+							Base: Base{Location: file.SourceLocation{Line: 1, Col: 12}},
+							Name: "b",
+							Kind: Lexical,
+						},
+						Expr: &VariableNode{
+							Base: Base{Location: file.SourceLocation{Line: 1, Col: 12}},
+							Name: "b",
+							Kind: Lexical,
+						},
+					},
+				},
+			},
+		},
+		{
+			"data t (c (f) d ()) { (d) }",
+			&DataNode{
+				Base: Base{Location: file.SourceLocation{Line: 1, Col: 1}},
+				Name: &VariableNode{
+					Base: Base{Location: file.SourceLocation{Line: 1, Col: 6}},
+					Name: "t",
+					Kind: Lexical,
+				},
+				Predicate: &VariableNode{
+					Base: Base{Location: file.SourceLocation{Line: 1, Col: 6}},
+					Name: "ist",
+					Kind: Lexical,
+				},
+				Variants: []*DataVariant{
+					{
+						Name: &VariableNode{
+							Base: Base{Location: file.SourceLocation{Line: 1, Col: 9}},
+							Name: "c",
+							Kind: Lexical,
+						},
+						Predicate: &VariableNode{
+							Base: Base{Location: file.SourceLocation{Line: 1, Col: 9}},
+							Name: "isc",
+							Kind: Lexical,
+						},
+						Fields: []*VariableNode{
+							{
+								Base: Base{Location: file.SourceLocation{Line: 1, Col: 12}},
+								Name: "f",
+								Kind: Lexical,
+							},
+						},
+					},
+					{
+						Name: &VariableNode{
+							Base: Base{Location: file.SourceLocation{Line: 1, Col: 15}},
+							Name: "d",
+							Kind: Lexical,
+						},
+						Predicate: &VariableNode{
+							Base: Base{Location: file.SourceLocation{Line: 1, Col: 15}},
+							Name: "isd",
+							Kind: Lexical,
+						},
+						Fields: []*VariableNode{},
+					},
+				},
+				Expr: &CallNode{
+					Base: Base{Location: file.SourceLocation{Line: 1, Col: 23}},
+					Callee: &VariableNode{
+						Base: Base{Location: file.SourceLocation{Line: 1, Col: 24}},
+						Name: "d",
+						Kind: Lexical,
+					},
+					ArgList: []ExprNode{},
+				},
+			},
+		},
 	}
 	for _, test := range tests {
 		t.Run(test.input, func(t *testing.T) {
@@ -232,6 +709,26 @@ func TestParse_error(t *testing.T) {
 			"unsupported escape sequence",
 			`"\b"`,
 		},
+		{
+			"float literal out of range",
+			`1e400`,
+		},
+		{
+			"number literal out of range #1",
+			`99999999999999999999`,
+		},
+		{
+			"number literal out of range #2",
+			`1/99999999999999999999`,
+		},
+		{
+			"number literal out of range #3",
+			`0.0000000000000000001`,
+		},
+		{
+			"bool literal used as a variable",
+			`letrec (true = 1) { true }`,
+		},
 		{
 			"malformed lambda #1",
 			`lambda () {}`,
@@ -240,6 +737,34 @@ func TestParse_error(t *testing.T) {
 			"malformed lambda #2",
 			`lambda`,
 		},
+		{
+			"required parameter after an optional one",
+			`lambda (a = 1 b) { a }`,
+		},
+		{
+			"rest parameter not last",
+			`lambda (@a b) { a }`,
+		},
+		{
+			"malformed rest parameter",
+			`lambda (a @) { a }`,
+		},
+		{
+			"malformed default",
+			`lambda (a =) { a }`,
+		},
+		{
+			"duplicate variable in a destructuring binding",
+			`letrec ([a a] = b) { a }`,
+		},
+		{
+			"duplicate variable in a destructuring parameter",
+			`lambda ([a] {a}) { a }`,
+		},
+		{
+			"destructuring binding without expression",
+			`letrec ([a] b = 1) { a }`,
+		},
 		{
 			"malformed letrec #1",
 			`letrec () {}`,
@@ -252,6 +777,30 @@ func TestParse_error(t *testing.T) {
 			"incorrect variable name",
 			`letrec (exit) { 1 }`,
 		},
+		{
+			"let without body",
+			`let (a = 1)`,
+		},
+		{
+			"let* with a missing expression",
+			`let* (a) { a }`,
+		},
+		{
+			"duplicate binder in let",
+			`let (a = 1 a = 2) { a }`,
+		},
+		{
+			"duplicate binder in a destructuring let",
+			`let ([a b] = c b = 2) { a }`,
+		},
+		{
+			"loop without bindings",
+			`loop f { 1 }`,
+		},
+		{
+			"loop named by a keyword",
+			`loop let () { 1 }`,
+		},
 		{
 			"zero-length sequence",
 			`[]`,
@@ -272,6 +821,114 @@ func TestParse_error(t *testing.T) {
 			"incorrect access #2",
 			`&Y l`,
 		},
+		{
+			"unquote outside of quasiquote #1",
+			`,a`,
+		},
+		{
+			"unquote outside of quasiquote #2",
+			"`(a ,,b)",
+		},
+		{
+			"incorrect unquote splicing",
+			"`if ,@a then 1 else 2",
+		},
+		{
+			"malformed quasiquote",
+			"`",
+		},
+		{
+			"incorrect macro name",
+			"macro (M (a) { a }) { 1 }",
+		},
+		{
+			"malformed macro",
+			"macro (m (a) a) { 1 }",
+		},
+		{
+			"rest element outside of a macro",
+			"(f @a)",
+		},
+		{
+			"zero-length match",
+			"match a { }",
+		},
+		{
+			"duplicate pattern variable",
+			"match a { [x {x}] then x }",
+		},
+		{
+			"rest element not last",
+			"match a { [@r x] then x }",
+		},
+		{
+			"unsupported pattern",
+			"match a { (add) then 1 }",
+		},
+		{
+			"incorrect field name",
+			"match a { {X} then 1 }",
+		},
+		{
+			"malformed match",
+			"match a { x if 1 then }",
+		},
+		{
+			"incorrect data type name",
+			"data Maybe (just (value)) { 1 }",
+		},
+		{
+			"incorrect constructor name",
+			"data t (str ()) { 1 }",
+		},
+		{
+			"duplicate constructor",
+			"data t (c () c (x)) { 1 }",
+		},
+		{
+			"duplicate predicate",
+			"data t (t ()) { 1 }",
+		},
+		{
+			"incorrect field name",
+			"data t (c (X)) { 1 }",
+		},
+		{
+			"duplicate field",
+			"data t (c (x x)) { 1 }",
+		},
+		{
+			"zero-length data type",
+			"data t () { 1 }",
+		},
+		{
+			"malformed data type",
+			"data t (c) { 1 }",
+		},
+		{
+			"zero-length cond",
+			"cond { }",
+		},
+		{
+			"malformed cond",
+			"cond { a then 1 else 2 b then 3 }",
+		},
+		{
+			"duplicate record field",
+			"{a = 1 a = 2}",
+		},
+		{
+			"incorrect record field name",
+			"{A = 1}",
+		},
+		{
+			"malformed record update",
+			"{(f) a = 1}",
+		},
+		{
+			"malformed record",
+			"{a = }",
+		},
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
@@ -7,6 +7,7 @@ import (
 	"github.com/gogim1/goscript/conf"
 	"github.com/gogim1/goscript/file"
 	"github.com/gogim1/goscript/lexer"
+	"github.com/gogim1/goscript/macro"
 	"github.com/gogim1/goscript/parser"
 	"github.com/gogim1/goscript/runtime"
 )
@@ -26,6 +27,7 @@ func main() {
 	}
 	tokens, _ := lexer.Lex(file.NewSource(string(bytes)))
 	node, _ := parser.Parse(tokens)
+	node, _ = macro.Expand(node)
 	e := runtime.NewState(node, conf.New(
 		conf.SetGCTrigger(trigger),
 		conf.EnableTCO(true),
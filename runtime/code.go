@@ -0,0 +1,598 @@
+package runtime
+
+import (
+	"math"
+
+	"github.com/gogim1/goscript/ast"
+	"github.com/gogim1/goscript/file"
+	"github.com/gogim1/goscript/lexer"
+	"github.com/gogim1/goscript/parser"
+)
+
+// unquotes returns the unquotes evaluated by a quasiquotation of node, in source order.
+// Unquotes of nested quasiquotations are only evaluated once every enclosing
+// quasiquotation has been unquoted.
+func unquotes(node ast.ExprNode) []*ast.UnquoteNode {
+	ret := []*ast.UnquoteNode{}
+	var walk func(node ast.ExprNode, level int)
+	walk = func(node ast.ExprNode, level int) {
+		switch n := node.(type) {
+		case *ast.QuoteNode:
+			walk(n.Expr, level+1)
+			return
+		case *ast.UnquoteNode:
+			if level == 0 {
+				ret = append(ret, n)
+			} else {
+				walk(n.Expr, level-1)
+			}
+			return
+		}
+		for _, child := range children(node) {
+			walk(child, level)
+		}
+	}
+	walk(node, 0)
+	return ret
+}
+
+// substitute copies node, replacing the unquotes evaluated by its quasiquotation with
+// their values.
+func substitute(node ast.ExprNode, values map[*ast.UnquoteNode]Value) (ast.ExprNode, *file.Error) {
+	var copyNode func(node ast.ExprNode, level int) (ast.ExprNode, *file.Error)
+	copyList := func(list []ast.ExprNode, level int) ([]ast.ExprNode, *file.Error) {
+		ret := []ast.ExprNode{}
+		for _, node := range list {
+			if n, ok := node.(*ast.UnquoteNode); ok && n.Splicing && level == 0 {
+				nodes, err := toNodes(n.GetLocation(), values[n])
+				if err != nil {
+					return nil, err
+				}
+				ret = append(ret, nodes...)
+				continue
+			}
+			node, err := copyNode(node, level)
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, node)
+		}
+		return ret, nil
+	}
+	copyBindings := func(list []*ast.LetrecVarExprItem, level int) ([]*ast.LetrecVarExprItem, *file.Error) {
+		ret := []*ast.LetrecVarExprItem{}
+		for _, ve := range list {
+			expr, err := copyNode(ve.Expr, level)
+			if err != nil {
+				return nil, err
+			}
+			if ve.Pattern != nil {
+				// patterns cannot contain unquotes.
+				ret = append(ret, &ast.LetrecVarExprItem{Pattern: ve.Pattern, Expr: expr})
+				continue
+			}
+			ret = append(ret, &ast.LetrecVarExprItem{
+				Variable: ast.NewVariableNode(ve.Variable.GetLocation(), ve.Variable.Name, ve.Variable.Kind),
+				Expr:     expr,
+			})
+		}
+		return ret, nil
+	}
+	copyNode = func(node ast.ExprNode, level int) (ast.ExprNode, *file.Error) {
+		sl := node.GetLocation()
+		switch n := node.(type) {
+		case *ast.NumberNode:
+			return ast.NewNumberNode(sl, n.Numerator, n.Denominator), nil
+		case *ast.FloatNode:
+			return ast.NewFloatNode(sl, n.Value), nil
+		case *ast.BoolNode:
+			return ast.NewBoolNode(sl, n.Value), nil
+		case *ast.StringNode:
+			return ast.NewStringNode(sl, n.Value), nil
+		case *ast.IntrinsicNode:
+			return ast.NewIntrinsicNode(sl, n.Name), nil
+		case *ast.VariableNode:
+			return ast.NewVariableNode(sl, n.Name, n.Kind), nil
+		case *ast.LambdaNode:
+			varList := []*ast.VariableNode{}
+			for _, v := range n.VarList {
+				varList = append(varList, ast.NewVariableNode(v.GetLocation(), v.Name, v.Kind))
+			}
+			defaults, err := copyList(n.Defaults, level)
+			if err != nil {
+				return nil, err
+			}
+			var rest *ast.VariableNode
+			if n.Rest != nil {
+				rest = ast.NewVariableNode(n.Rest.GetLocation(), n.Rest.Name, n.Rest.Kind)
+			}
+			expr, err := copyNode(n.Expr, level)
+			if err != nil {
+				return nil, err
+			}
+			return ast.NewLambdaNodeWithParams(sl, varList, ast.LambdaParams{Patterns: n.Patterns, Defaults: defaults, Rest: rest}, expr), nil
+		case *ast.LetrecNode:
+			varExprList, err := copyBindings(n.VarExprList, level)
+			if err != nil {
+				return nil, err
+			}
+			expr, err := copyNode(n.Expr, level)
+			if err != nil {
+				return nil, err
+			}
+			return ast.NewLetrecNode(sl, varExprList, expr), nil
+		case *ast.LetNode:
+			varExprList, err := copyBindings(n.VarExprList, level)
+			if err != nil {
+				return nil, err
+			}
+			expr, err := copyNode(n.Expr, level)
+			if err != nil {
+				return nil, err
+			}
+			return ast.NewLetNode(sl, n.Sequential, varExprList, expr), nil
+		case *ast.LoopNode:
+			inits, err := copyList(n.Inits, level)
+			if err != nil {
+				return nil, err
+			}
+			fun, err := copyNode(n.Fun, level)
+			if err != nil {
+				return nil, err
+			}
+			return ast.NewLoopNode(sl, ast.NewVariableNode(n.Name.GetLocation(), n.Name.Name, n.Name.Kind), inits, fun.(*ast.LambdaNode)), nil
+		case *ast.IfNode:
+			list, err := copyList([]ast.ExprNode{n.Cond, n.Branch1, n.Branch2}, level)
+			if err != nil {
+				return nil, err
+			}
+			return ast.NewIfNode(sl, list[0], list[1], list[2]), nil
+		case *ast.CallNode:
+			var callee ast.ExprNode
+			if n, ok := n.Callee.(*ast.UnquoteNode); ok && level == 0 {
+				if code, ok := values[n].(*Code); ok {
+					callee = code.Node
+				}
+			}
+			if callee == nil {
+				c, err := copyNode(n.Callee, level)
+				if err != nil {
+					return nil, err
+				}
+				callee = c
+			}
+			argList, err := copyList(n.ArgList, level)
+			if err != nil {
+				return nil, err
+			}
+			return ast.NewCallNode(sl, callee, argList), nil
+		case *ast.SequenceNode:
+			exprList, err := copyList(n.ExprList, level)
+			if err != nil {
+				return nil, err
+			}
+			if len(exprList) == 0 {
+				return nil, &file.Error{Location: sl, Message: "zero-length sequence"}
+			}
+			return ast.NewSequenceNode(sl, exprList), nil
+		case *ast.AccessNode:
+			expr, err := copyNode(n.Expr, level)
+			if err != nil {
+				return nil, err
+			}
+			return ast.NewAccessNode(sl, ast.NewVariableNode(n.Variable.GetLocation(), n.Variable.Name, n.Variable.Kind), expr), nil
+		case *ast.RecordNode:
+			var expr ast.ExprNode
+			if n.Expr != nil {
+				e, err := copyNode(n.Expr, level)
+				if err != nil {
+					return nil, err
+				}
+				expr = e
+			}
+			fields := []*ast.RecordField{}
+			for _, f := range n.Fields {
+				e, err := copyNode(f.Expr, level)
+				if err != nil {
+					return nil, err
+				}
+				fields = append(fields, &ast.RecordField{Name: ast.NewVariableNode(f.Name.GetLocation(), f.Name.Name, f.Name.Kind), Expr: e})
+			}
+			return ast.NewRecordNode(sl, expr, fields), nil
+		case *ast.MatchNode:
+			expr, err := copyNode(n.Expr, level)
+			if err != nil {
+				return nil, err
+			}
+			cases := []*ast.MatchCase{}
+			for _, c := range n.Cases {
+				var guard ast.ExprNode
+				if c.Guard != nil {
+					if guard, err = copyNode(c.Guard, level); err != nil {
+						return nil, err
+					}
+				}
+				e, err := copyNode(c.Expr, level)
+				if err != nil {
+					return nil, err
+				}
+				// patterns cannot contain unquotes.
+				cases = append(cases, &ast.MatchCase{Pattern: c.Pattern, Guard: guard, Expr: e})
+			}
+			return ast.NewMatchNode(sl, expr, cases), nil
+		case *ast.CondNode:
+			cases := []*ast.CondCase{}
+			for _, c := range n.Cases {
+				list, err := copyList([]ast.ExprNode{c.Cond, c.Expr}, level)
+				if err != nil {
+					return nil, err
+				}
+				cases = append(cases, &ast.CondCase{Cond: list[0], Expr: list[1]})
+			}
+			var els ast.ExprNode
+			if n.Else != nil {
+				e, err := copyNode(n.Else, level)
+				if err != nil {
+					return nil, err
+				}
+				els = e
+			}
+			return ast.NewCondNode(sl, cases, els), nil
+		case *ast.DataNode:
+			variable := func(v *ast.VariableNode) *ast.VariableNode {
+				return ast.NewVariableNode(v.GetLocation(), v.Name, v.Kind)
+			}
+			variants := []*ast.DataVariant{}
+			for _, v := range n.Variants {
+				fields := []*ast.VariableNode{}
+				for _, f := range v.Fields {
+					fields = append(fields, variable(f))
+				}
+				variants = append(variants, &ast.DataVariant{Name: variable(v.Name), Predicate: variable(v.Predicate), Fields: fields})
+			}
+			expr, err := copyNode(n.Expr, level)
+			if err != nil {
+				return nil, err
+			}
+			return ast.NewDataNode(sl, variable(n.Name), variable(n.Predicate), variants, expr), nil
+		case *ast.QuoteNode:
+			expr, err := copyNode(n.Expr, level+1)
+			if err != nil {
+				return nil, err
+			}
+			return ast.NewQuoteNode(sl, expr), nil
+		case *ast.UnquoteNode:
+			if level == 0 {
+				return toNode(sl, values[n])
+			}
+			expr, err := copyNode(n.Expr, level-1)
+			if err != nil {
+				return nil, err
+			}
+			return ast.NewUnquoteNode(sl, expr, n.Splicing), nil
+		}
+		return node, nil
+	}
+	return copyNode(node, 0)
+}
+
+// toNode converts the value of an unquote to an expression.
+func toNode(sl file.SourceLocation, value Value) (ast.ExprNode, *file.Error) {
+	switch v := value.(type) {
+	case *Code:
+		if _, ok := v.Node.(*ast.IntrinsicNode); ok {
+			return nil, &file.Error{Location: sl, Message: "intrinsic code can only be called"}
+		}
+		return v.Node, nil
+	case *Number:
+		return ast.NewNumberNode(sl, v.Numerator, v.Denominator), nil
+	case *Float:
+		if !math.IsNaN(v.Value) && !math.IsInf(v.Value, 0) {
+			return ast.NewFloatNode(sl, v.Value), nil
+		}
+	case *Bool:
+		return ast.NewBoolNode(sl, v.Value), nil
+	case *String:
+		return ast.NewStringNode(sl, v.Value), nil
+	}
+	return nil, &file.Error{Location: sl, Message: "unquote expects code, a number, a finite float, a bool or a string"}
+}
+
+// toNodes converts the value of an unquote splicing, which is either a sequence
+// code or void for nothing, to expressions.
+func toNodes(sl file.SourceLocation, value Value) ([]ast.ExprNode, *file.Error) {
+	if _, ok := value.(*Void); ok {
+		return nil, nil
+	}
+	if code, ok := value.(*Code); ok {
+		if n, ok := code.Node.(*ast.SequenceNode); ok {
+			return n.ExprList, nil
+		}
+	}
+	return nil, &file.Error{Location: sl, Message: "unquote splicing expects sequence code or void"}
+}
+
+func kindOf(node ast.ExprNode) string {
+	switch n := node.(type) {
+	case *ast.NumberNode:
+		return "number"
+	case *ast.FloatNode:
+		return "float"
+	case *ast.BoolNode:
+		return "bool"
+	case *ast.StringNode:
+		return "string"
+	case *ast.IntrinsicNode:
+		return "intrinsic"
+	case *ast.VariableNode:
+		return "variable"
+	case *ast.LambdaNode:
+		return "lambda"
+	case *ast.LetrecNode:
+		return "letrec"
+	case *ast.LetNode:
+		if n.Sequential {
+			return "let*"
+		}
+		return "let"
+	case *ast.LoopNode:
+		return "loop"
+	case *ast.IfNode:
+		return "if"
+	case *ast.CallNode:
+		return "call"
+	case *ast.SequenceNode:
+		return "sequence"
+	case *ast.AccessNode:
+		return "access"
+	case *ast.RecordNode:
+		return "record"
+	case *ast.MatchNode:
+		return "match"
+	case *ast.CondNode:
+		return "cond"
+	case *ast.DataNode:
+		return "data"
+	case *ast.QuoteNode:
+		return "quote"
+	case *ast.UnquoteNode:
+		return "unquote"
+	}
+	return "unknown"
+}
+
+// children returns the parts of a node, in the order `mkcode` takes them. Patterns
+// destructuring bindings, as well as the defaults and the rest parameter of a lambda,
+// cannot be given to `mkcode`, and neither can the parts of:
+//   - a `let` or a `let*`, which are those of a `letrec`;
+//   - a `loop`, its name followed by the parameter and the initial value of every
+//     binding, then its body;
+//   - a record, the updated expression if any followed by the name and the expression
+//     of every field;
+//   - a `match`, its expression followed by the pattern, the guard if any and the
+//     expression of every case;
+//   - a `cond`, the condition and the expression of every case followed by the else
+//     expression if any;
+//   - a `data`, its name followed by the name and the fields of every variant, then its
+//     expression.
+func children(node ast.ExprNode) []ast.ExprNode {
+	switch n := node.(type) {
+	case *ast.LambdaNode:
+		ret := []ast.ExprNode{}
+		for i := range n.VarList {
+			ret = append(ret, n.Param(i))
+			if d := n.Default(i); d != nil {
+				ret = append(ret, d)
+			}
+		}
+		if n.Rest != nil {
+			ret = append(ret, n.Rest)
+		}
+		return append(ret, n.Expr)
+	case *ast.LetrecNode:
+		ret := []ast.ExprNode{}
+		for _, ve := range n.VarExprList {
+			ret = append(ret, ve.Target(), ve.Expr)
+		}
+		return append(ret, n.Expr)
+	case *ast.LetNode:
+		ret := []ast.ExprNode{}
+		for _, ve := range n.VarExprList {
+			ret = append(ret, ve.Target(), ve.Expr)
+		}
+		return append(ret, n.Expr)
+	case *ast.LoopNode:
+		ret := []ast.ExprNode{n.Name}
+		for i, init := range n.Inits {
+			ret = append(ret, n.Fun.Param(i), init)
+		}
+		return append(ret, n.Fun.Expr)
+	case *ast.IfNode:
+		return []ast.ExprNode{n.Cond, n.Branch1, n.Branch2}
+	case *ast.CallNode:
+		return append([]ast.ExprNode{n.Callee}, n.ArgList...)
+	case *ast.SequenceNode:
+		return n.ExprList
+	case *ast.AccessNode:
+		return []ast.ExprNode{n.Variable, n.Expr}
+	case *ast.RecordNode:
+		ret := []ast.ExprNode{}
+		if n.Expr != nil {
+			ret = append(ret, n.Expr)
+		}
+		for _, f := range n.Fields {
+			ret = append(ret, f.Name, f.Expr)
+		}
+		return ret
+	case *ast.MatchNode:
+		ret := []ast.ExprNode{n.Expr}
+		for _, c := range n.Cases {
+			ret = append(ret, c.Pattern)
+			if c.Guard != nil {
+				ret = append(ret, c.Guard)
+			}
+			ret = append(ret, c.Expr)
+		}
+		return ret
+	case *ast.CondNode:
+		ret := []ast.ExprNode{}
+		for _, c := range n.Cases {
+			ret = append(ret, c.Cond, c.Expr)
+		}
+		if n.Else != nil {
+			ret = append(ret, n.Else)
+		}
+		return ret
+	case *ast.DataNode:
+		ret := []ast.ExprNode{n.Name}
+		for _, v := range n.Variants {
+			ret = append(ret, v.Name)
+			for _, f := range v.Fields {
+				ret = append(ret, f)
+			}
+		}
+		return append(ret, n.Expr)
+	case *ast.QuoteNode:
+		return []ast.ExprNode{n.Expr}
+	case *ast.UnquoteNode:
+		return []ast.ExprNode{n.Expr}
+	}
+	return nil
+}
+
+// makeCode builds a node of the given kind from the arguments of `mkcode`.
+func makeCode(sl file.SourceLocation, kind string, args []Value) (ast.ExprNode, *file.Error) {
+	wrongArgs := &file.Error{Location: sl, Message: "wrong arguments given to mkcode"}
+	exprs := func(args []Value) ([]ast.ExprNode, bool) {
+		ret := []ast.ExprNode{}
+		for _, arg := range args {
+			code, ok := arg.(*Code)
+			if !ok {
+				return nil, false
+			}
+			if _, ok := code.Node.(*ast.IntrinsicNode); ok {
+				return nil, false
+			}
+			ret = append(ret, code.Node)
+		}
+		return ret, true
+	}
+	variable := func(arg Value) (*ast.VariableNode, bool) {
+		if code, ok := arg.(*Code); ok {
+			v, ok := code.Node.(*ast.VariableNode)
+			return v, ok
+		}
+		return nil, false
+	}
+
+	switch kind {
+	case "number":
+		if len(args) == 1 {
+			if v, ok := args[0].(*Number); ok {
+				return ast.NewNumberNode(sl, v.Numerator, v.Denominator), nil
+			}
+		}
+	case "float":
+		if len(args) == 1 {
+			if v, ok := args[0].(*Float); ok && !math.IsNaN(v.Value) && !math.IsInf(v.Value, 0) {
+				return ast.NewFloatNode(sl, v.Value), nil
+			}
+		}
+	case "bool":
+		if len(args) == 1 {
+			if v, ok := args[0].(*Bool); ok {
+				return ast.NewBoolNode(sl, v.Value), nil
+			}
+		}
+	case "string":
+		if len(args) == 1 {
+			if v, ok := args[0].(*String); ok {
+				return ast.NewStringNode(sl, v.Value), nil
+			}
+		}
+	case "variable":
+		if len(args) == 1 {
+			if v, ok := args[0].(*String); ok {
+				tokens, err := lexer.Lex(file.NewSource(v.Value))
+				if err == nil && len(tokens) == 1 && tokens[0].Kind == lexer.Identifier && !parser.IsIntrinsic(v.Value) {
+					kind := ast.Lexical
+					if !isLexical(v.Value) {
+						kind = ast.Dynamic
+					}
+					return ast.NewVariableNode(sl, v.Value, kind), nil
+				}
+			}
+		}
+	case "intrinsic":
+		if len(args) == 1 {
+			if v, ok := args[0].(*String); ok && parser.IsIntrinsic(v.Value) {
+				return ast.NewIntrinsicNode(sl, v.Value), nil
+			}
+		}
+	case "lambda":
+		if len(args) >= 1 {
+			varList := []*ast.VariableNode{}
+			for _, arg := range args[:len(args)-1] {
+				v, ok := variable(arg)
+				if !ok {
+					return nil, wrongArgs
+				}
+				varList = append(varList, v)
+			}
+			if expr, ok := exprs(args[len(args)-1:]); ok {
+				return ast.NewLambdaNode(sl, varList, expr[0]), nil
+			}
+		}
+	case "letrec":
+		if len(args)%2 == 1 {
+			varExprList := []*ast.LetrecVarExprItem{}
+			for i := 0; i < len(args)-1; i += 2 {
+				v, ok := variable(args[i])
+				if !ok {
+					return nil, wrongArgs
+				}
+				expr, ok := exprs(args[i+1 : i+2])
+				if !ok {
+					return nil, wrongArgs
+				}
+				varExprList = append(varExprList, &ast.LetrecVarExprItem{Variable: v, Expr: expr[0]})
+			}
+			if expr, ok := exprs(args[len(args)-1:]); ok {
+				return ast.NewLetrecNode(sl, varExprList, expr[0]), nil
+			}
+		}
+	case "if":
+		if expr, ok := exprs(args); ok && len(expr) == 3 {
+			return ast.NewIfNode(sl, expr[0], expr[1], expr[2]), nil
+		}
+	case "call":
+		if len(args) >= 1 {
+			code, ok := args[0].(*Code)
+			if !ok {
+				return nil, wrongArgs
+			}
+			if argList, ok := exprs(args[1:]); ok {
+				return ast.NewCallNode(sl, code.Node, argList), nil
+			}
+		}
+	case "sequence":
+		if expr, ok := exprs(args); ok && len(expr) > 0 {
+			return ast.NewSequenceNode(sl, expr), nil
+		}
+	case "access":
+		if len(args) == 2 {
+			v, ok := variable(args[0])
+			if !ok || v.Kind != ast.Lexical {
+				return nil, wrongArgs
+			}
+			if expr, ok := exprs(args[1:]); ok {
+				return ast.NewAccessNode(sl, v, expr[0]), nil
+			}
+		}
+	case "quote":
+		if expr, ok := exprs(args); ok && len(expr) == 1 {
+			return ast.NewQuoteNode(sl, expr[0]), nil
+		}
+	}
+	return nil, wrongArgs
+}
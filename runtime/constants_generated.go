@@ -3,8 +3,8 @@
 package runtime
 
 var (
-	trueValue     = NewNumber(1, 1)
-	falseValue    = NewNumber(0, 1)
+	trueValue     = NewBool(true)
+	falseValue    = NewBool(false)
 	voidValue     = NewVoid()
 	emptyStrValue = NewString("")
 )
@@ -0,0 +1,234 @@
+package runtime
+
+import (
+	"encoding/binary"
+	"hash"
+	"hash/fnv"
+	"math"
+
+	"github.com/gogim1/goscript/ast"
+)
+
+// Equal reports whether two values are equal. Void values are equal to each other,
+// numbers, floats, bools, strings, code and errors are compared by value, lists, data and records
+// structurally, and other values by identity. A number and a float are equal when the
+// float is exactly the number, as they are ordered by `lt`.
+func Equal(lhs, rhs Value) bool {
+	switch lhs := lhs.(type) {
+	case *Void:
+		_, ok := rhs.(*Void)
+		return ok
+	case *Number:
+		switch rhs := rhs.(type) {
+		case *Number:
+			return !lhs.lt(rhs) && !rhs.lt(lhs)
+		case *Float:
+			return lhs.compareFloat(rhs.Value) == 0
+		}
+		return false
+	case *Float:
+		// NaN is not equal to itself.
+		switch rhs := rhs.(type) {
+		case *Number:
+			return rhs.compareFloat(lhs.Value) == 0
+		case *Float:
+			return lhs.Value == rhs.Value
+		}
+		return false
+	case *Bool:
+		rhs, ok := rhs.(*Bool)
+		return ok && lhs.Value == rhs.Value
+	case *String:
+		rhs, ok := rhs.(*String)
+		return ok && lhs.Value == rhs.Value
+	case *Code:
+		rhs, ok := rhs.(*Code)
+		return ok && ast.Format(lhs.Node) == ast.Format(rhs.Node)
+	case *Error:
+		rhs, ok := rhs.(*Error)
+		return ok && *lhs.Err == *rhs.Err
+	case *List:
+		rhs, ok := rhs.(*List)
+		return ok && lhs.equal(rhs)
+	case *Data:
+		rhs, ok := rhs.(*Data)
+		return ok && lhs.equal(rhs)
+	case *Record:
+		rhs, ok := rhs.(*Record)
+		return ok && lhs.equal(rhs)
+	}
+	return lhs == rhs
+}
+
+func (v *List) equal(other *List) bool {
+	if len(v.Elems) != len(other.Elems) {
+		return false
+	}
+	for i, elem := range v.Elems {
+		if !Equal(elem, other.Elems[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// equal reports whether two data values are built by the same constructor from
+// equal values.
+func (v *Data) equal(other *Data) bool {
+	if v.Constructor != other.Constructor {
+		return false
+	}
+	for i, value := range v.Values {
+		if !Equal(value, other.Values[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// equal reports whether two records have the same fields with equal values,
+// regardless of the order of their fields.
+func (v *Record) equal(other *Record) bool {
+	if len(v.Fields) != len(other.Fields) {
+		return false
+	}
+	for i, f := range v.Fields {
+		if value, ok := other.get(f); !ok || !Equal(v.Values[i], value) {
+			return false
+		}
+	}
+	return true
+}
+
+// the kinds of values written first by hashes, so that values of different kinds
+// with the same content rarely collide.
+const (
+	identityHash byte = iota
+	voidHash
+	numberHash
+	floatHash
+	boolHash
+	stringHash
+	codeHash
+	errorHash
+	listHash
+	dataHash
+	recordHash
+)
+
+func newHash(kind byte) hash.Hash64 {
+	h := fnv.New64a()
+	h.Write([]byte{kind})
+	return h
+}
+
+func writeUint64(h hash.Hash64, n uint64) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], n)
+	h.Write(b[:])
+}
+
+// Hash hashes the identity of values compared by identity.
+func (n *Base) Hash() uint64 {
+	h := newHash(identityHash)
+	writeUint64(h, uint64(n.Id))
+	return h.Sum64()
+}
+
+func (v *Void) Hash() uint64 {
+	return newHash(voidHash).Sum64()
+}
+
+func (v *Number) Hash() uint64 {
+	// a number equal to a float has its hash.
+	if f, exact := v.float(); exact {
+		return NewFloat(f).Hash()
+	}
+	// numbers are not always reduced, 2/4 and 1/2 must have the same hash.
+	n, d := v.Numerator, v.Denominator
+	if d < 0 {
+		n, d = -n, -d
+	}
+	abs := n
+	if abs < 0 {
+		abs = -abs
+	}
+	if g := gcd(abs, d); g > 1 {
+		n, d = n/g, d/g
+	}
+	h := newHash(numberHash)
+	writeUint64(h, uint64(n))
+	writeUint64(h, uint64(d))
+	return h.Sum64()
+}
+
+func (v *Float) Hash() uint64 {
+	f := v.Value
+	if f == 0 {
+		// -0 is equal to 0.
+		f = 0
+	}
+	h := newHash(floatHash)
+	writeUint64(h, math.Float64bits(f))
+	return h.Sum64()
+}
+
+func (v *Bool) Hash() uint64 {
+	h := newHash(boolHash)
+	if v.Value {
+		h.Write([]byte{1})
+	}
+	return h.Sum64()
+}
+
+func (v *String) Hash() uint64 {
+	h := newHash(stringHash)
+	h.Write([]byte(v.Value))
+	return h.Sum64()
+}
+
+func (v *Code) Hash() uint64 {
+	h := newHash(codeHash)
+	h.Write([]byte(ast.Format(v.Node)))
+	return h.Sum64()
+}
+
+func (v *Error) Hash() uint64 {
+	h := newHash(errorHash)
+	writeUint64(h, uint64(v.Err.Kind))
+	writeUint64(h, uint64(v.Err.Location.Line))
+	writeUint64(h, uint64(v.Err.Location.Col))
+	h.Write([]byte(v.Err.Message))
+	return h.Sum64()
+}
+
+func (v *List) Hash() uint64 {
+	h := newHash(listHash)
+	for _, elem := range v.Elems {
+		writeUint64(h, elem.Hash())
+	}
+	return h.Sum64()
+}
+
+func (v *Data) Hash() uint64 {
+	h := newHash(dataHash)
+	writeUint64(h, uint64(v.Constructor.GetId()))
+	for _, value := range v.Values {
+		writeUint64(h, value.Hash())
+	}
+	return h.Sum64()
+}
+
+func (v *Record) Hash() uint64 {
+	// fields are combined by a sum, which does not depend on their order.
+	sum := uint64(0)
+	for i, f := range v.Fields {
+		field := newHash(recordHash)
+		field.Write([]byte(f))
+		writeUint64(field, v.Values[i].Hash())
+		sum += field.Sum64()
+	}
+	h := newHash(recordHash)
+	writeUint64(h, sum)
+	return h.Sum64()
+}
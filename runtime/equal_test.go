@@ -0,0 +1,61 @@
+package runtime_test
+
+import (
+	"testing"
+
+	"github.com/gogim1/goscript/conf"
+	. "github.com/gogim1/goscript/runtime"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHash(t *testing.T) {
+	// each program evaluates to a list whose first two elements are equal, and whose
+	// other elements differ from the first one.
+	tests := []string{
+		`(mklist (void) (void) 0 "")`,
+		`(mklist 2/4 1/2 1 -1/2 "1/2")`,
+		`(mklist -0 0/3 1 (void))`,
+		`(mklist 0e0 -0e0 1 1e-300 "0e0")`,
+		`(mklist 0 -0e0 1/2 "0")`,
+		`(mklist 1.5e0 (add 1 0.5e0) 3/4 (inexact 1))`,
+		`(mklist 3/2 1.5e0 1/3 (div 1e0 3) "3/2")`,
+		`(mklist 9007199254740992 9007199254740992e0 9007199254740993)`,
+		`(mklist true (eq 1 1) false 1 "true")`,
+		`(mklist false (not true) true 0 (void))`,
+		`(mklist "ab" (concat "a" "b") "ba")`,
+		`(mklist (mklist 1 "a") (mklist 1 "a") (mklist "a" 1) (mklist 1))`,
+		`(mklist {a = 1 b = 2} {b = 2 a = 1} {a = 2 b = 1} {a = 1})`,
+		`data t (a (x) b (x)) { (mklist (a 1) (a 1) (b 1) (a 2)) }`,
+		"(mklist `(add 1 2) `(add 1 ,(add 1 1)) `(add 2 1))",
+		`letrec (f = lambda () { 1 }) { (mklist f f lambda () { 1 }) }`,
+		`(mklist (evalin "(div 1 0)" lambda () { 0 } true) (evalin "(div 1 0)" lambda () { 0 } true) (evalin "(div 1 \"a\")" lambda () { 0 } true))`,
+	}
+	for _, test := range tests {
+		t.Run(test, func(t *testing.T) {
+			state := NewState(lexAndParse(t, test), conf.New())
+			require.Nil(t, state.Execute())
+			elems := state.Value().(*List).Elems
+
+			assert.True(t, Equal(elems[0], elems[1]))
+			assert.Equal(t, elems[0].Hash(), elems[1].Hash())
+			for _, other := range elems[2:] {
+				assert.False(t, Equal(elems[0], other), other.String())
+				assert.NotEqual(t, elems[0].Hash(), other.Hash(), other.String())
+			}
+
+			// values can be used as keys of a map bucketed by hash.
+			keys := map[uint64][]Value{}
+			for _, elem := range elems {
+				found := false
+				for _, key := range keys[elem.Hash()] {
+					found = found || Equal(key, elem)
+				}
+				if !found {
+					keys[elem.Hash()] = append(keys[elem.Hash()], elem)
+				}
+			}
+			assert.Len(t, keys, len(elems)-1)
+		})
+	}
+}
@@ -7,11 +7,14 @@ import (
 	"os"
 	"reflect"
 	"strconv"
+	"strings"
+	"unicode/utf8"
 
 	"github.com/gogim1/goscript/ast"
 	"github.com/gogim1/goscript/conf"
 	"github.com/gogim1/goscript/file"
 	"github.com/gogim1/goscript/lexer"
+	"github.com/gogim1/goscript/macro"
 	"github.com/gogim1/goscript/parser"
 )
 
@@ -23,19 +26,92 @@ func (s *state) preserve() []*layer {
 
 func (s *state) restore(layers []*layer) {
 	deepcopy(&s.stack, layers)
+	s.capabilities = s.sandbox()
 }
 
 func lexAndParse(src string) (ast.ExprNode, *file.Error) {
-	tokens, err := lexer.Lex(file.NewSource(src))
+	node, err := parse(src)
 	if err != nil {
 		return nil, err
 	}
+	return macro.Expand(node)
+}
 
-	node, err := parser.Parse(tokens)
+// parse parses src without expanding macros, as code values are kept unexpanded.
+func parse(src string) (ast.ExprNode, *file.Error) {
+	tokens, err := lexer.Lex(file.NewSource(src))
 	if err != nil {
 		return nil, err
 	}
-	return node, nil
+	return parser.Parse(tokens)
+}
+
+// isEvaluating reports whether the layer is an `evalin` call whose program is running.
+func (s *state) isEvaluating(l *layer) bool {
+	n, ok := l.expr.(*ast.CallNode)
+	if !ok || l.pc != len(n.ArgList)+2 {
+		return false
+	}
+	callee, ok := n.Callee.(*ast.IntrinsicNode)
+	return ok && callee.Name == "evalin"
+}
+
+// sandbox returns the capabilities of the code on top of the stack, which are those
+// of `eval` inside a program loaded by `evalin`.
+func (s *state) sandbox() *conf.Capabilities {
+	for _, l := range s.stack {
+		if s.isEvaluating(l) {
+			return s.config.Eval().Capabilities
+		}
+	}
+	return s.config.Capabilities
+}
+
+// capabilitiesOf returns the capabilities of the code of node. Code of a program loaded
+// by `evalin` has those of `eval` wherever it runs, such as in a closure called once
+// `evalin` returned, and other code has those of the code on top of the stack.
+func (s *state) capabilitiesOf(node ast.ExprNode) *conf.Capabilities {
+	if _, ok := s.owners[node]; ok {
+		return s.config.Eval().Capabilities
+	}
+	return s.capabilities
+}
+
+// isCatching reports whether the layer is an `evalin` call that receives errors as values.
+func (s *state) isCatching(l *layer) bool {
+	n, ok := l.expr.(*ast.CallNode)
+	if !ok {
+		return false
+	}
+	if callee, ok := n.Callee.(*ast.IntrinsicNode); !ok || callee.Name != "evalin" {
+		return false
+	}
+	if len(l.args) != 3 {
+		return false
+	}
+	flag, ok := s.truth(l.args[2])
+	return ok && flag
+}
+
+// truth returns the truth of a bool. Numbers are accepted too with numeric conditions,
+// nonzero numbers being true.
+func (s *state) truth(value Value) (bool, bool) {
+	switch v := value.(type) {
+	case *Bool:
+		return v.Value, true
+	case *Number:
+		if s.config.NumericConditions {
+			return v.Numerator != 0, true
+		}
+	}
+	return false, false
+}
+
+func boolValue(v bool) Value {
+	if v {
+		return trueValue
+	}
+	return falseValue
 }
 
 func run(src string, conf *conf.Config) (Value, *file.Error) {
@@ -43,8 +119,12 @@ func run(src string, conf *conf.Config) (Value, *file.Error) {
 	if err != nil {
 		return nil, err
 	}
+	return runNode(node, conf)
+}
+
+func runNode(node ast.ExprNode, conf *conf.Config) (Value, *file.Error) {
 	state := NewState(node, conf)
-	if err = state.Execute(); err != nil {
+	if err := state.Execute(); err != nil {
 		return nil, err
 	}
 	return state.Value(), nil
@@ -64,6 +144,18 @@ func (s *state) VisitNumberNode(n *ast.NumberNode) *file.Error {
 	return nil
 }
 
+func (s *state) VisitFloatNode(n *ast.FloatNode) *file.Error {
+	s.value = NewFloat(n.Value)
+	s.stack = s.stack[:len(s.stack)-1]
+	return nil
+}
+
+func (s *state) VisitBoolNode(n *ast.BoolNode) *file.Error {
+	s.value = boolValue(n.Value)
+	s.stack = s.stack[:len(s.stack)-1]
+	return nil
+}
+
 func retrieveStringValue(v string) Value {
 	if len(v) == 0 {
 		return emptyStrValue
@@ -80,6 +172,20 @@ func (s *state) VisitStringNode(n *ast.StringNode) *file.Error {
 
 func (s *state) VisitIntrinsicNode(n *ast.IntrinsicNode) *file.Error {
 	l := s.stack[len(s.stack)-1]
+	if s.isEvaluating(l) {
+		// the program loaded by `evalin` returned, and its value is the result.
+		s.stack = s.stack[:len(s.stack)-1]
+		s.capabilities = s.sandbox()
+		return nil
+	}
+	if !s.capabilitiesOf(l.expr).AllowsIntrinsic(n.Name) {
+		s.value = voidValue
+		return &file.Error{
+			Location: l.expr.GetLocation(),
+			Message:  "permission denied to call intrinsic " + n.Name,
+			Kind:     file.PermissionError,
+		}
+	}
 
 	switch n.Name {
 	case "void":
@@ -114,6 +220,26 @@ func (s *state) VisitIntrinsicNode(n *ast.IntrinsicNode) *file.Error {
 		} else {
 			s.value = falseValue
 		}
+	case "isfloat":
+		if err := typeCheck(l.expr.GetLocation(), l.args, []reflect.Type{ValueType}); err != nil {
+			s.value = voidValue
+			return err
+		}
+		if _, ok := l.args[0].(*Float); ok {
+			s.value = trueValue
+		} else {
+			s.value = falseValue
+		}
+	case "isbool":
+		if err := typeCheck(l.expr.GetLocation(), l.args, []reflect.Type{ValueType}); err != nil {
+			s.value = voidValue
+			return err
+		}
+		if _, ok := l.args[0].(*Bool); ok {
+			s.value = trueValue
+		} else {
+			s.value = falseValue
+		}
 	case "isstr":
 		if err := typeCheck(l.expr.GetLocation(), l.args, []reflect.Type{ValueType}); err != nil {
 			s.value = voidValue
@@ -144,7 +270,41 @@ func (s *state) VisitIntrinsicNode(n *ast.IntrinsicNode) *file.Error {
 		} else {
 			s.value = falseValue
 		}
+	case "ishost":
+		if err := typeCheck(l.expr.GetLocation(), l.args, []reflect.Type{ValueType}); err != nil {
+			s.value = voidValue
+			return err
+		}
+		if _, ok := l.args[0].(*HostObject); ok {
+			s.value = trueValue
+		} else {
+			s.value = falseValue
+		}
+	case "isweak":
+		if err := typeCheck(l.expr.GetLocation(), l.args, []reflect.Type{ValueType}); err != nil {
+			s.value = voidValue
+			return err
+		}
+		if _, ok := l.args[0].(*WeakRef); ok {
+			s.value = trueValue
+		} else {
+			s.value = falseValue
+		}
+	case "isref":
+		if err := typeCheck(l.expr.GetLocation(), l.args, []reflect.Type{ValueType}); err != nil {
+			s.value = voidValue
+			return err
+		}
+		if _, ok := l.args[0].(*Ref); ok {
+			s.value = trueValue
+		} else {
+			s.value = falseValue
+		}
 	case "add":
+		if f, ok := inexact(l.args, 2); ok {
+			s.value = NewFloat(f[0] + f[1])
+			break
+		}
 		if err := typeCheck(l.expr.GetLocation(), l.args, []reflect.Type{NumberType, NumberType}); err != nil {
 			s.value = voidValue
 			return err
@@ -155,6 +315,10 @@ func (s *state) VisitIntrinsicNode(n *ast.IntrinsicNode) *file.Error {
 		g1 := gcd(int(math.Abs(float64(n1))), d1)
 		s.value = retrieveNumberValue(n1/g1, d1/g1)
 	case "sub":
+		if f, ok := inexact(l.args, 2); ok {
+			s.value = NewFloat(f[0] - f[1])
+			break
+		}
 		if err := typeCheck(l.expr.GetLocation(), l.args, []reflect.Type{NumberType, NumberType}); err != nil {
 			s.value = voidValue
 			return err
@@ -165,6 +329,10 @@ func (s *state) VisitIntrinsicNode(n *ast.IntrinsicNode) *file.Error {
 		g1 := gcd(int(math.Abs(float64(n1))), d1)
 		s.value = retrieveNumberValue(n1/g1, d1/g1)
 	case "mul":
+		if f, ok := inexact(l.args, 2); ok {
+			s.value = NewFloat(f[0] * f[1])
+			break
+		}
 		if err := typeCheck(l.expr.GetLocation(), l.args, []reflect.Type{NumberType, NumberType}); err != nil {
 			s.value = voidValue
 			return err
@@ -175,6 +343,11 @@ func (s *state) VisitIntrinsicNode(n *ast.IntrinsicNode) *file.Error {
 		g1 := gcd(int(math.Abs(float64(n1))), d1)
 		s.value = retrieveNumberValue(n1/g1, d1/g1)
 	case "div":
+		// dividing floats by zero gives infinities or NaN.
+		if f, ok := inexact(l.args, 2); ok {
+			s.value = NewFloat(f[0] / f[1])
+			break
+		}
 		if err := typeCheck(l.expr.GetLocation(), l.args, []reflect.Type{NumberType, NumberType}); err != nil {
 			s.value = voidValue
 			return err
@@ -195,128 +368,291 @@ func (s *state) VisitIntrinsicNode(n *ast.IntrinsicNode) *file.Error {
 		}
 		g1 := gcd(int(math.Abs(float64(n1))), d1)
 		s.value = retrieveNumberValue(n1/g1, d1/g1)
-	case "lt":
-		if err := typeCheck(l.expr.GetLocation(), l.args, []reflect.Type{NumberType, NumberType}); err != nil {
+	case "floor", "ceil", "round":
+		if f, ok := inexact(l.args, 1); ok {
+			switch n.Name {
+			case "floor":
+				s.value = NewFloat(math.Floor(f[0]))
+			case "ceil":
+				s.value = NewFloat(math.Ceil(f[0]))
+			default:
+				s.value = NewFloat(math.Round(f[0]))
+			}
+			break
+		}
+		if err := typeCheck(l.expr.GetLocation(), l.args, []reflect.Type{NumberType}); err != nil {
 			s.value = voidValue
 			return err
 		}
-		lhs, rhs := l.args[0].(*Number), l.args[1].(*Number)
-		if lhs.lt(rhs) {
-			s.value = trueValue
-		} else {
-			s.value = falseValue
+		v := l.args[0].(*Number)
+		s.value = retrieveNumberValue(roundDiv(v.Numerator, v.Denominator, n.Name), 1)
+	case "quot", "mod":
+		if f, ok := inexact(l.args, 2); ok {
+			if n.Name == "quot" {
+				s.value = NewFloat(math.Floor(f[0] / f[1]))
+			} else {
+				s.value = NewFloat(floorMod(f[0], f[1]))
+			}
+			break
 		}
-	case "gt":
 		if err := typeCheck(l.expr.GetLocation(), l.args, []reflect.Type{NumberType, NumberType}); err != nil {
 			s.value = voidValue
 			return err
 		}
 		lhs, rhs := l.args[0].(*Number), l.args[1].(*Number)
-		if rhs.lt(lhs) {
-			s.value = trueValue
+		if rhs.Numerator == 0 {
+			s.value = voidValue
+			return &file.Error{
+				Location: l.expr.GetLocation(),
+				Message:  "division by zero",
+			}
+		}
+		// quot rounds the quotient toward negative infinity, so that mod has the sign of the divisor.
+		n1, d1 := lhs.Numerator*rhs.Denominator, lhs.Denominator*rhs.Numerator
+		if d1 < 0 {
+			n1, d1 = -n1, -d1
+		}
+		q := floorDiv(n1, d1)
+		if n.Name == "quot" {
+			s.value = retrieveNumberValue(q, 1)
 		} else {
-			s.value = falseValue
+			s.value = rational(lhs.Numerator*rhs.Denominator-q*rhs.Numerator*lhs.Denominator, lhs.Denominator*rhs.Denominator)
+		}
+	case "pow":
+		if f, ok := inexact(l.args, 2); ok {
+			s.value = NewFloat(math.Pow(f[0], f[1]))
+			break
 		}
-	case "ge":
 		if err := typeCheck(l.expr.GetLocation(), l.args, []reflect.Type{NumberType, NumberType}); err != nil {
 			s.value = voidValue
 			return err
 		}
-		lhs, rhs := l.args[0].(*Number), l.args[1].(*Number)
-		if !lhs.lt(rhs) {
-			s.value = trueValue
-		} else {
-			s.value = falseValue
+		base, exp := l.args[0].(*Number), l.args[1].(*Number)
+		if exp.Denominator != 1 {
+			s.value = voidValue
+			return &file.Error{
+				Location: l.expr.GetLocation(),
+				Message:  "non-integer exponent",
+			}
 		}
-	case "le":
-		if err := typeCheck(l.expr.GetLocation(), l.args, []reflect.Type{NumberType, NumberType}); err != nil {
+		if base.Numerator == 0 && exp.Numerator < 0 {
 			s.value = voidValue
-			return err
+			return &file.Error{
+				Location: l.expr.GetLocation(),
+				Message:  "division by zero",
+			}
 		}
-		lhs, rhs := l.args[0].(*Number), l.args[1].(*Number)
-		if !rhs.lt(lhs) {
-			s.value = trueValue
-		} else {
-			s.value = falseValue
+		e := uint(exp.Numerator)
+		if exp.Numerator < 0 {
+			e = -e
 		}
-	case "eq":
-		if len(l.args) != 2 || reflect.TypeOf(l.args[0]) != reflect.TypeOf(l.args[1]) ||
-			(reflect.TypeOf(l.args[0]).Elem() != NumberType && reflect.TypeOf(l.args[0]).Elem() != StringType) {
+		n1, ok1 := powInt(base.Numerator, e)
+		d1, ok2 := powInt(base.Denominator, e)
+		if exp.Numerator < 0 {
+			n1, d1 = d1, n1
+		}
+		// the denominator of the result must be negatable.
+		if !ok1 || !ok2 || d1 == math.MinInt {
 			s.value = voidValue
 			return &file.Error{
 				Location: l.expr.GetLocation(),
-				Message:  "wrong number/type of arguments given to eq",
+				Message:  "number out of range",
 			}
 		}
-		if lhs, ok := l.args[0].(*Number); ok {
-			rhs := l.args[1].(*Number)
-			if !lhs.lt(rhs) && !rhs.lt(lhs) {
-				s.value = trueValue
+		s.value = rational(n1, d1)
+	case "abs":
+		if f, ok := inexact(l.args, 1); ok {
+			s.value = NewFloat(math.Abs(f[0]))
+			break
+		}
+		if err := typeCheck(l.expr.GetLocation(), l.args, []reflect.Type{NumberType}); err != nil {
+			s.value = voidValue
+			return err
+		}
+		v := l.args[0].(*Number)
+		s.value = retrieveNumberValue(abs(v.Numerator), v.Denominator)
+	case "min", "max":
+		if f, ok := inexact(l.args, 2); ok {
+			if n.Name == "min" {
+				s.value = NewFloat(math.Min(f[0], f[1]))
 			} else {
-				s.value = falseValue
+				s.value = NewFloat(math.Max(f[0], f[1]))
 			}
+			break
+		}
+		if err := typeCheck(l.expr.GetLocation(), l.args, []reflect.Type{NumberType, NumberType}); err != nil {
+			s.value = voidValue
+			return err
+		}
+		lhs, rhs := l.args[0].(*Number), l.args[1].(*Number)
+		if lhs.lt(rhs) == (n.Name == "min") {
+			s.value = lhs
 		} else {
-			lhs, rhs := l.args[0].(*String), l.args[1].(*String)
-			if lhs.Value == rhs.Value {
-				s.value = trueValue
-			} else {
-				s.value = falseValue
+			s.value = rhs
+		}
+	case "numerator":
+		if err := typeCheck(l.expr.GetLocation(), l.args, []reflect.Type{NumberType}); err != nil {
+			s.value = voidValue
+			return err
+		}
+		s.value = retrieveNumberValue(l.args[0].(*Number).Numerator, 1)
+	case "denominator":
+		if err := typeCheck(l.expr.GetLocation(), l.args, []reflect.Type{NumberType}); err != nil {
+			s.value = voidValue
+			return err
+		}
+		s.value = retrieveNumberValue(l.args[0].(*Number).Denominator, 1)
+	case "todec":
+		// the rounding mode is optional and defaults to "round".
+		types := []reflect.Type{NumberType, NumberType, StringType}
+		if len(l.args) == 2 {
+			types = types[:2]
+		}
+		if err := typeCheck(l.expr.GetLocation(), l.args, types); err != nil {
+			s.value = voidValue
+			return err
+		}
+		v, precision, mode := l.args[0].(*Number), l.args[1].(*Number), "round"
+		if len(l.args) == 3 {
+			mode = l.args[2].(*String).Value
+		}
+		if precision.Denominator != 1 || precision.Numerator < 0 || precision.Numerator > maxPrecision {
+			s.value = voidValue
+			return &file.Error{
+				Location: l.expr.GetLocation(),
+				Message:  "incorrect precision",
 			}
 		}
-	case "ne":
-		if len(l.args) != 2 || reflect.TypeOf(l.args[0]) != reflect.TypeOf(l.args[1]) ||
-			(reflect.TypeOf(l.args[0]).Elem() != NumberType && reflect.TypeOf(l.args[0]).Elem() != StringType) {
+		if !isRoundingMode(mode) {
 			s.value = voidValue
 			return &file.Error{
 				Location: l.expr.GetLocation(),
-				Message:  "wrong number/type of arguments given to eq",
+				Message:  "incorrect rounding mode",
 			}
 		}
-		if lhs, ok := l.args[0].(*Number); ok {
-			rhs := l.args[1].(*Number)
-			if lhs.lt(rhs) || rhs.lt(lhs) {
-				s.value = trueValue
-			} else {
-				s.value = falseValue
+		s.value = retrieveStringValue(formatDecimal(v.Numerator, v.Denominator, precision.Numerator, mode))
+	case "exact":
+		if err := typeCheck(l.expr.GetLocation(), l.args, []reflect.Type{ValueType}); err != nil {
+			s.value = voidValue
+			return err
+		}
+		switch v := l.args[0].(type) {
+		case *Number:
+			s.value = v
+		case *Float:
+			value, ok := exact(v.Value)
+			if !ok {
+				s.value = voidValue
+				return &file.Error{
+					Location: l.expr.GetLocation(),
+					Message:  "float has no exact representation",
+				}
 			}
-		} else {
-			lhs, rhs := l.args[0].(*String), l.args[1].(*String)
-			if lhs.Value != rhs.Value {
-				s.value = trueValue
-			} else {
-				s.value = falseValue
+			s.value = value
+		default:
+			s.value = voidValue
+			return &file.Error{
+				Location: l.expr.GetLocation(),
+				Message:  "wrong type of arguments given to callee",
 			}
 		}
-	case "and":
-		if err := typeCheck(l.expr.GetLocation(), l.args, []reflect.Type{NumberType, NumberType}); err != nil {
+	case "inexact":
+		f, err := toFloats(l.expr.GetLocation(), l.args, 1)
+		if err != nil {
+			s.value = voidValue
+			return err
+		}
+		if v, ok := l.args[0].(*Float); ok {
+			s.value = v
+		} else {
+			s.value = NewFloat(f[0])
+		}
+	case "sqrt", "exp", "log", "sin", "cos", "tan", "asin", "acos", "atan", "atan2":
+		fn := mathFunctions[n.Name]
+		f, err := toFloats(l.expr.GetLocation(), l.args, fn.arity)
+		if err != nil {
+			s.value = voidValue
+			return err
+		}
+		s.value = NewFloat(fn.fn(f))
+	case "lt":
+		c, err := compare(l.expr.GetLocation(), l.args)
+		if err != nil {
 			s.value = voidValue
 			return err
 		}
-		if l.args[0].(*Number).Numerator != 0 && l.args[1].(*Number).Numerator != 0 {
+		if c < 0 {
 			s.value = trueValue
 		} else {
 			s.value = falseValue
 		}
-	case "or":
-		if err := typeCheck(l.expr.GetLocation(), l.args, []reflect.Type{NumberType, NumberType}); err != nil {
+	case "gt":
+		c, err := compare(l.expr.GetLocation(), l.args)
+		if err != nil {
 			s.value = voidValue
 			return err
 		}
-		if l.args[0].(*Number).Numerator != 0 || l.args[1].(*Number).Numerator != 0 {
+		if c > 0 {
 			s.value = trueValue
 		} else {
 			s.value = falseValue
 		}
-	case "not":
-		if err := typeCheck(l.expr.GetLocation(), l.args, []reflect.Type{NumberType}); err != nil {
+	case "ge":
+		c, err := compare(l.expr.GetLocation(), l.args)
+		if err != nil {
+			s.value = voidValue
+			return err
+		}
+		if c >= 0 {
+			s.value = trueValue
+		} else {
+			s.value = falseValue
+		}
+	case "le":
+		c, err := compare(l.expr.GetLocation(), l.args)
+		if err != nil {
+			s.value = voidValue
+			return err
+		}
+		if c <= 0 {
+			s.value = trueValue
+		} else {
+			s.value = falseValue
+		}
+	case "eq":
+		if err := typeCheck(l.expr.GetLocation(), l.args, []reflect.Type{ValueType, ValueType}); err != nil {
+			s.value = voidValue
+			return err
+		}
+		if Equal(l.args[0], l.args[1]) {
+			s.value = trueValue
+		} else {
+			s.value = falseValue
+		}
+	case "ne":
+		if err := typeCheck(l.expr.GetLocation(), l.args, []reflect.Type{ValueType, ValueType}); err != nil {
 			s.value = voidValue
 			return err
 		}
-		if l.args[0].(*Number).Numerator == 0 {
+		if !Equal(l.args[0], l.args[1]) {
 			s.value = trueValue
 		} else {
 			s.value = falseValue
 		}
+	case "not":
+		if err := typeCheck(l.expr.GetLocation(), l.args, []reflect.Type{ValueType}); err != nil {
+			s.value = voidValue
+			return err
+		}
+		v, ok := s.truth(l.args[0])
+		if !ok {
+			s.value = voidValue
+			return &file.Error{
+				Location: l.expr.GetLocation(),
+				Message:  "wrong type of arguments given to callee",
+			}
+		}
+		s.value = boolValue(!v)
 	case "put":
 		if len(l.args) == 0 {
 			s.value = voidValue
@@ -351,43 +687,479 @@ func (s *state) VisitIntrinsicNode(n *ast.IntrinsicNode) *file.Error {
 		str := l.args[0].(*String).Value
 		s.value = retrieveStringValue(strconv.Quote(str))
 	case "concat":
-		if err := typeCheck(l.expr.GetLocation(), l.args, []reflect.Type{StringType, StringType}); err != nil {
+		b := &strings.Builder{}
+		for _, arg := range l.args {
+			str, ok := arg.(*String)
+			if !ok {
+				s.value = voidValue
+				return &file.Error{
+					Location: l.expr.GetLocation(),
+					Message:  "wrong type of arguments given to callee",
+				}
+			}
+			b.WriteString(str.Value)
+		}
+		s.value = retrieveStringValue(b.String())
+	case "strlen":
+		if err := typeCheck(l.expr.GetLocation(), l.args, []reflect.Type{StringType}); err != nil {
 			s.value = voidValue
 			return err
 		}
-		s.value = retrieveStringValue(l.args[0].(*String).Value + (l.args[1].(*String)).Value)
-	case "eval":
-		if err := typeCheck(l.expr.GetLocation(), l.args, []reflect.Type{StringType}); err != nil {
+		s.value = retrieveNumberValue(utf8.RuneCountInString(l.args[0].(*String).Value), 1)
+	case "substr":
+		if err := typeCheck(l.expr.GetLocation(), l.args, []reflect.Type{StringType, NumberType, NumberType}); err != nil {
 			s.value = voidValue
 			return err
 		}
-		v, err := run(l.args[0].(*String).String(), s.config)
-		if err != nil {
+		runes := []rune(l.args[0].(*String).Value)
+		start, end := l.args[1].(*Number), l.args[2].(*Number)
+		if start.Denominator != 1 || end.Denominator != 1 ||
+			start.Numerator < 0 || end.Numerator < start.Numerator || end.Numerator > len(runes) {
+			s.value = voidValue
+			return &file.Error{
+				Location: l.expr.GetLocation(),
+				Message:  "string index out of range",
+			}
+		}
+		s.value = retrieveStringValue(string(runes[start.Numerator:end.Numerator]))
+	case "index":
+		if err := typeCheck(l.expr.GetLocation(), l.args, []reflect.Type{StringType, StringType}); err != nil {
+			s.value = voidValue
 			return err
+		}
+		str := l.args[0].(*String).Value
+		if i := strings.Index(str, l.args[1].(*String).Value); i != -1 {
+			s.value = retrieveNumberValue(utf8.RuneCountInString(str[:i]), 1)
 		} else {
-			s.value = v
+			s.value = retrieveNumberValue(-1, 1)
 		}
-	case "callcc":
-		if err := typeCheck(l.expr.GetLocation(), l.args, []reflect.Type{ClosureType}); err != nil {
+	case "split":
+		if err := typeCheck(l.expr.GetLocation(), l.args, []reflect.Type{StringType, StringType}); err != nil {
 			s.value = voidValue
 			return err
 		}
-		s.stack = s.stack[:len(s.stack)-1]
-
-		contStack := s.preserve()
-		addr := s.new(NewContinuation(l.expr.GetLocation(), contStack))
-		closure := l.args[0].(*Closure)
+		parts := []Value{}
+		for _, part := range strings.Split(l.args[0].(*String).Value, l.args[1].(*String).Value) {
+			parts = append(parts, retrieveStringValue(part))
+		}
+		s.value = NewList(parts)
+	case "join":
+		if err := typeCheck(l.expr.GetLocation(), l.args, []reflect.Type{ListType, StringType}); err != nil {
+			s.value = voidValue
+			return err
+		}
+		parts := []string{}
+		for _, elem := range l.args[0].(*List).Elems {
+			str, ok := elem.(*String)
+			if !ok {
+				s.value = voidValue
+				return &file.Error{
+					Location: l.expr.GetLocation(),
+					Message:  "join expects a list of strings",
+				}
+			}
+			parts = append(parts, str.Value)
+		}
+		s.value = retrieveStringValue(strings.Join(parts, l.args[1].(*String).Value))
+	case "upper":
+		if err := typeCheck(l.expr.GetLocation(), l.args, []reflect.Type{StringType}); err != nil {
+			s.value = voidValue
+			return err
+		}
+		s.value = retrieveStringValue(strings.ToUpper(l.args[0].(*String).Value))
+	case "lower":
+		if err := typeCheck(l.expr.GetLocation(), l.args, []reflect.Type{StringType}); err != nil {
+			s.value = voidValue
+			return err
+		}
+		s.value = retrieveStringValue(strings.ToLower(l.args[0].(*String).Value))
+	case "trim":
+		if err := typeCheck(l.expr.GetLocation(), l.args, []reflect.Type{StringType}); err != nil {
+			s.value = voidValue
+			return err
+		}
+		s.value = retrieveStringValue(strings.TrimSpace(l.args[0].(*String).Value))
+	case "replace":
+		if err := typeCheck(l.expr.GetLocation(), l.args, []reflect.Type{StringType, StringType, StringType}); err != nil {
+			s.value = voidValue
+			return err
+		}
+		str, from, to := l.args[0].(*String).Value, l.args[1].(*String).Value, l.args[2].(*String).Value
+		s.value = retrieveStringValue(strings.ReplaceAll(str, from, to))
+	case "tonum":
+		if err := typeCheck(l.expr.GetLocation(), l.args, []reflect.Type{StringType}); err != nil {
+			s.value = voidValue
+			return err
+		}
+		// strings which are not number literals convert to void, except the spellings
+		// `tostr` gives to the non-finite floats, which have no literal.
+		s.value = voidValue
+		str := l.args[0].(*String).Value
+		switch str {
+		case "+inf":
+			s.value = NewFloat(math.Inf(1))
+		case "-inf":
+			s.value = NewFloat(math.Inf(-1))
+		case "nan":
+			s.value = NewFloat(math.NaN())
+		}
+		if node, err := parse(str); err == nil {
+			switch n := node.(type) {
+			case *ast.NumberNode:
+				s.value = retrieveNumberValue(n.Numerator, n.Denominator)
+			case *ast.FloatNode:
+				s.value = NewFloat(n.Value)
+			}
+		}
+	case "tostr":
+		if err := typeCheck(l.expr.GetLocation(), l.args, []reflect.Type{ValueType}); err != nil {
+			s.value = voidValue
+			return err
+		}
+		s.value = retrieveStringValue(l.args[0].String())
+	case "eval":
+		if err := typeCheck(l.expr.GetLocation(), l.args, []reflect.Type{ValueType}); err != nil {
+			s.value = voidValue
+			return err
+		}
+		var v Value
+		var err *file.Error
+		switch arg := l.args[0].(type) {
+		case *String:
+			v, err = run(arg.Value, s.config.Eval())
+		case *Code:
+			var node ast.ExprNode
+			if node, err = macro.Expand(arg.Node); err == nil {
+				v, err = runNode(node, s.config.Eval())
+			}
+		default:
+			s.value = voidValue
+			return &file.Error{
+				Location: l.expr.GetLocation(),
+				Message:  "wrong type of arguments given to callee",
+			}
+		}
+		if err != nil {
+			s.value = voidValue
+			return err
+		} else {
+			s.value = v
+		}
+	case "evalin":
+		types := []reflect.Type{ValueType, ClosureType}
+		if len(l.args) == 3 {
+			types = append(types, ValueType)
+		}
+		if err := typeCheck(l.expr.GetLocation(), l.args, types); err != nil {
+			s.value = voidValue
+			return err
+		}
+		if _, ok := s.truth(l.args[len(l.args)-1]); len(l.args) == 3 && !ok {
+			s.value = voidValue
+			return &file.Error{
+				Location: l.expr.GetLocation(),
+				Message:  "wrong type of arguments given to callee",
+			}
+		}
+		var node ast.ExprNode
+		var src string
+		var err *file.Error
+		switch arg := l.args[0].(type) {
+		case *String:
+			src = arg.Value
+			node, err = lexAndParse(src)
+		case *Code:
+			// the unexpanded code is kept as source, as expanded names cannot be parsed.
+			src = ast.Format(arg.Node)
+			node, err = macro.Expand(arg.Node)
+		default:
+			s.value = voidValue
+			return &file.Error{
+				Location: l.expr.GetLocation(),
+				Message:  "wrong type of arguments given to callee",
+			}
+		}
+		if err != nil {
+			if !s.isCatching(l) {
+				s.value = voidValue
+				return err
+			}
+			s.value = NewError(err)
+			break
+		}
+		s.programs = append(s.programs, node)
+		s.sources[node] = src
+		ast.Walk(node, func(n ast.ExprNode) {
+			s.owners[n] = node
+		})
 
+		// the program runs in a copy of the closure environment with the
+		// capabilities of `eval`, and its value is the result once the layer of
+		// `evalin` is visited again.
+		closure := l.args[1].(*Closure)
 		env := make([]envItem, len(closure.Env))
 		copy(env, closure.Env)
-		env = append(env, envItem{closure.Fun.VarList[0].Name, addr})
-
+		l.pc++
+		s.capabilities = s.config.Eval().Capabilities
 		s.stack = append(s.stack, &layer{
 			env:   &env,
 			frame: true,
-			expr:  closure.Fun.Expr,
+			expr:  node,
 		})
 		return nil
+	case "iserr":
+		if err := typeCheck(l.expr.GetLocation(), l.args, []reflect.Type{ValueType}); err != nil {
+			s.value = voidValue
+			return err
+		}
+		if _, ok := l.args[0].(*Error); ok {
+			s.value = trueValue
+		} else {
+			s.value = falseValue
+		}
+	case "errmsg":
+		if err := typeCheck(l.expr.GetLocation(), l.args, []reflect.Type{ErrorType}); err != nil {
+			s.value = voidValue
+			return err
+		}
+		s.value = retrieveStringValue(l.args[0].(*Error).Err.Message)
+	case "iscode":
+		if err := typeCheck(l.expr.GetLocation(), l.args, []reflect.Type{ValueType}); err != nil {
+			s.value = voidValue
+			return err
+		}
+		if _, ok := l.args[0].(*Code); ok {
+			s.value = trueValue
+		} else {
+			s.value = falseValue
+		}
+	case "codekind":
+		if err := typeCheck(l.expr.GetLocation(), l.args, []reflect.Type{CodeType}); err != nil {
+			s.value = voidValue
+			return err
+		}
+		s.value = retrieveStringValue(kindOf(l.args[0].(*Code).Node))
+	case "codelen":
+		if err := typeCheck(l.expr.GetLocation(), l.args, []reflect.Type{CodeType}); err != nil {
+			s.value = voidValue
+			return err
+		}
+		s.value = retrieveNumberValue(len(children(l.args[0].(*Code).Node)), 1)
+	case "codeget":
+		if err := typeCheck(l.expr.GetLocation(), l.args, []reflect.Type{CodeType, NumberType}); err != nil {
+			s.value = voidValue
+			return err
+		}
+		parts := children(l.args[0].(*Code).Node)
+		i := l.args[1].(*Number)
+		if i.Denominator != 1 || i.Numerator < 0 || i.Numerator >= len(parts) {
+			s.value = voidValue
+			return &file.Error{
+				Location: l.expr.GetLocation(),
+				Message:  "code part index out of range",
+			}
+		}
+		s.value = NewCode(parts[i.Numerator])
+	case "codeval":
+		if err := typeCheck(l.expr.GetLocation(), l.args, []reflect.Type{CodeType}); err != nil {
+			s.value = voidValue
+			return err
+		}
+		switch n := l.args[0].(*Code).Node.(type) {
+		case *ast.NumberNode:
+			s.value = retrieveNumberValue(n.Numerator, n.Denominator)
+		case *ast.FloatNode:
+			s.value = NewFloat(n.Value)
+		case *ast.BoolNode:
+			s.value = boolValue(n.Value)
+		case *ast.StringNode:
+			s.value = retrieveStringValue(n.Value)
+		case *ast.VariableNode:
+			s.value = retrieveStringValue(n.Name)
+		case *ast.IntrinsicNode:
+			s.value = retrieveStringValue(n.Name)
+		default:
+			s.value = voidValue
+			return &file.Error{
+				Location: l.expr.GetLocation(),
+				Message:  "codeval expects number, float, bool, string, variable or intrinsic code",
+			}
+		}
+	case "mkcode":
+		if len(l.args) == 0 || reflect.TypeOf(l.args[0]).Elem() != StringType {
+			s.value = voidValue
+			return &file.Error{
+				Location: l.expr.GetLocation(),
+				Message:  "mkcode expects a code kind as the first argument",
+			}
+		}
+		node, err := makeCode(l.expr.GetLocation(), l.args[0].(*String).Value, l.args[1:])
+		if err != nil {
+			s.value = voidValue
+			return err
+		}
+		s.value = NewCode(node)
+	case "islist":
+		if err := typeCheck(l.expr.GetLocation(), l.args, []reflect.Type{ValueType}); err != nil {
+			s.value = voidValue
+			return err
+		}
+		if _, ok := l.args[0].(*List); ok {
+			s.value = trueValue
+		} else {
+			s.value = falseValue
+		}
+	case "mklist":
+		elems := make([]Value, len(l.args))
+		copy(elems, l.args)
+		s.value = NewList(elems)
+	case "listlen":
+		if err := typeCheck(l.expr.GetLocation(), l.args, []reflect.Type{ListType}); err != nil {
+			s.value = voidValue
+			return err
+		}
+		s.value = retrieveNumberValue(len(l.args[0].(*List).Elems), 1)
+	case "listget":
+		if err := typeCheck(l.expr.GetLocation(), l.args, []reflect.Type{ListType, NumberType}); err != nil {
+			s.value = voidValue
+			return err
+		}
+		elems := l.args[0].(*List).Elems
+		i := l.args[1].(*Number)
+		if i.Denominator != 1 || i.Numerator < 0 || i.Numerator >= len(elems) {
+			s.value = voidValue
+			return &file.Error{
+				Location: l.expr.GetLocation(),
+				Message:  "list index out of range",
+			}
+		}
+		s.value = elems[i.Numerator]
+	case "isrec":
+		if err := typeCheck(l.expr.GetLocation(), l.args, []reflect.Type{ValueType}); err != nil {
+			s.value = voidValue
+			return err
+		}
+		if _, ok := l.args[0].(*Record); ok {
+			s.value = trueValue
+		} else {
+			s.value = falseValue
+		}
+	case "recfields":
+		if err := typeCheck(l.expr.GetLocation(), l.args, []reflect.Type{RecordType}); err != nil {
+			s.value = voidValue
+			return err
+		}
+		fields := []Value{}
+		for _, f := range l.args[0].(*Record).Fields {
+			fields = append(fields, retrieveStringValue(f))
+		}
+		s.value = NewList(fields)
+	case "recget":
+		if err := typeCheck(l.expr.GetLocation(), l.args, []reflect.Type{RecordType, StringType}); err != nil {
+			s.value = voidValue
+			return err
+		}
+		value, ok := l.args[0].(*Record).get(l.args[1].(*String).Value)
+		if !ok {
+			s.value = voidValue
+			return &file.Error{
+				Location: l.expr.GetLocation(),
+				Message:  "undefined field",
+			}
+		}
+		s.value = value
+	case "callcc":
+		if err := typeCheck(l.expr.GetLocation(), l.args, []reflect.Type{ClosureType}); err != nil {
+			s.value = voidValue
+			return err
+		}
+		closure := l.args[0].(*Closure)
+		if err := checkArity(l.expr.GetLocation(), closure.Fun, 1); err != nil {
+			s.value = voidValue
+			return err
+		}
+		s.stack = s.stack[:len(s.stack)-1]
+
+		contStack := s.preserve()
+		continuation := NewContinuation(l.expr.GetLocation(), contStack)
+		if err := s.call(&layer{env: l.env}, closure, []Value{continuation}); err != nil {
+			s.value = voidValue
+			return err
+		}
+		return nil
+	case "finalize":
+		if err := typeCheck(l.expr.GetLocation(), l.args, []reflect.Type{ValueType, ClosureType}); err != nil {
+			s.value = voidValue
+			return err
+		}
+		closure := l.args[1].(*Closure)
+		if !isCollectable(l.args[0]) || checkArity(l.expr.GetLocation(), closure.Fun, 1) != nil {
+			s.value = voidValue
+			return &file.Error{
+				Location: l.expr.GetLocation(),
+				Message:  "finalize expects a collectable value and a closure of one parameter",
+			}
+		}
+		s.finalizers[l.args[0].GetId()] = &finalizer{value: l.args[0], script: closure}
+		s.value = voidValue
+	case "weakref":
+		if err := typeCheck(l.expr.GetLocation(), l.args, []reflect.Type{ValueType}); err != nil {
+			s.value = voidValue
+			return err
+		}
+		if !isCollectable(l.args[0]) {
+			s.value = voidValue
+			return &file.Error{
+				Location: l.expr.GetLocation(),
+				Message:  "weak reference to non-collectable value",
+			}
+		}
+		s.value = s.weakref(l.args[0])
+	case "weakget":
+		if err := typeCheck(l.expr.GetLocation(), l.args, []reflect.Type{WeakRefType}); err != nil {
+			s.value = voidValue
+			return err
+		}
+		s.value = l.args[0].(*WeakRef).Target
+	case "apply":
+		if l.pc == len(l.expr.(*ast.CallNode).ArgList)+1 {
+			var list *List
+			if len(l.args) >= 2 {
+				list, _ = l.args[len(l.args)-1].(*List)
+			}
+			if list == nil {
+				s.value = voidValue
+				return &file.Error{
+					Location: l.expr.GetLocation(),
+					Message:  "apply expects a callee, arguments and a list of arguments",
+				}
+			}
+			// the layer becomes a call to the callee, visited again once it returns.
+			args := make([]Value, 0, len(l.args)-2+len(list.Elems))
+			args = append(args, l.args[1:len(l.args)-1]...)
+			args = append(args, list.Elems...)
+			l.callee, l.args = l.args[0], args
+			return s.apply(l, l.expr.GetLocation(), l.expr.GetLocation())
+		}
+	case "ref":
+		if err := typeCheck(l.expr.GetLocation(), l.args, []reflect.Type{ValueType}); err != nil {
+			s.value = voidValue
+			return err
+		}
+		s.value = NewRef(s.new(l.args[0]))
+	case "deref":
+		if err := typeCheck(l.expr.GetLocation(), l.args, []reflect.Type{RefType}); err != nil {
+			s.value = voidValue
+			return err
+		}
+		s.value = s.heap[l.args[0].(*Ref).Cell]
+	case "setref":
+		if err := typeCheck(l.expr.GetLocation(), l.args, []reflect.Type{RefType, ValueType}); err != nil {
+			s.value = voidValue
+			return err
+		}
+		s.heap[l.args[0].(*Ref).Cell] = l.args[1]
+		s.value = voidValue
 	case "reg":
 		if err := typeCheck(l.expr.GetLocation(), l.args, []reflect.Type{StringType, ClosureType}); err != nil {
 			s.value = voidValue
@@ -400,23 +1172,60 @@ func (s *state) VisitIntrinsicNode(n *ast.IntrinsicNode) *file.Error {
 		})
 		s.value = voidValue
 	case "go":
+		if len(l.args) >= 2 && reflect.TypeOf(l.args[0]).Elem() == HostObjectType &&
+			reflect.TypeOf(l.args[1]).Elem() == StringType {
+			object, name := l.args[0].(*HostObject), l.args[1].(*String).Value
+			args := append([]Value{object}, l.args[2:]...)
+			if !s.capabilitiesOf(l.expr).AllowsFFI(object.TypeName + "." + name) {
+				s.value = voidValue
+				return &file.Error{
+					Location: l.expr.GetLocation(),
+					Message:  "permission denied to call FFI method " + object.TypeName + "." + name,
+					Kind:     file.PermissionError,
+				}
+			}
+			if f, ok := object.Methods[name]; !ok {
+				s.value = voidValue
+				return &file.Error{
+					Location: l.expr.GetLocation(),
+					Message:  "FFI encountered undefined method",
+				}
+			} else if v, err := s.callFFI(l.expr.GetLocation(), f, args); err != nil {
+				s.value = voidValue
+				return err
+			} else {
+				s.value = v
+			}
+			break
+		}
 		if len(l.args) == 0 || reflect.TypeOf(l.args[0]).Elem() != StringType {
 			s.value = voidValue
 			return &file.Error{
 				Location: l.expr.GetLocation(),
-				Message:  "FFI expects a string (Golang function name) as the first argument",
+				Message:  "FFI expects a string (Golang function name) or a host object and a method name as the first arguments",
 			}
 		}
 		name := l.args[0].(*String).Value
 		args := l.args[1:]
+		if !s.capabilitiesOf(l.expr).AllowsFFI(name) {
+			s.value = voidValue
+			return &file.Error{
+				Location: l.expr.GetLocation(),
+				Message:  "permission denied to call FFI function " + name,
+				Kind:     file.PermissionError,
+			}
+		}
 		if f, ok := s.ffi[name]; !ok {
 			s.value = voidValue
 			return &file.Error{
 				Location: l.expr.GetLocation(),
 				Message:  "FFI encountered unregistered function",
 			}
+		} else if v, err := s.callFFI(l.expr.GetLocation(), f, args); err != nil {
+			s.value = voidValue
+			return err
 		} else {
-			s.value = f(args...)
+			s.value = v
 		}
 	default:
 		s.value = voidValue
@@ -425,6 +1234,10 @@ func (s *state) VisitIntrinsicNode(n *ast.IntrinsicNode) *file.Error {
 			Message:  "unrecognized intrinsic function call",
 		}
 	}
+	if s.suspended {
+		// the layer is popped by Resume.
+		return nil
+	}
 	s.stack = s.stack[:len(s.stack)-1]
 	return nil
 }
@@ -451,27 +1264,73 @@ func (s *state) VisitVariableNode(n *ast.VariableNode) *file.Error {
 
 func (s *state) VisitLambdaNode(n *ast.LambdaNode) *file.Error {
 	l := s.stack[len(s.stack)-1]
-	s.value = NewClosure(filterLexical(*l.env), n)
+	if l.callee == nil {
+		s.value = NewClosure(filterLexical(*l.env), n)
+		s.stack = s.stack[:len(s.stack)-1]
+		return nil
+	}
+
+	// the layer is the prologue of a call to l.callee, which evaluates in turn the
+	// defaults of the missing optional parameters. Each default sees the previous
+	// parameters, and its value is appended to the arguments.
+	closure := l.callee.(*Closure)
+	if l.pc > 0 {
+		l.args = append(l.args, s.value)
+	}
+	if i := len(l.args); i < len(n.VarList) {
+		env := make([]envItem, len(closure.Env))
+		copy(env, closure.Env)
+		env, err := s.bind(env, n, l.args)
+		if err != nil {
+			s.value = voidValue
+			return err
+		}
+		s.stack = append(s.stack, &layer{
+			env:   &env,
+			frame: true,
+			expr:  n.Default(i),
+		})
+		l.pc++
+		return nil
+	}
 	s.stack = s.stack[:len(s.stack)-1]
+	if err := s.call(l, closure, l.args); err != nil {
+		s.value = voidValue
+		return err
+	}
 	return nil
 }
 
 func (s *state) VisitLetrecNode(n *ast.LetrecNode) *file.Error {
 	l := s.stack[len(s.stack)-1]
 	if 1 < l.pc && l.pc <= len(n.VarExprList)+1 {
-		v := n.VarExprList[l.pc-2].Variable
-		lastLocation := lookupEnv(v.Name, *l.env)
-		if lastLocation == -1 {
-			panic("this should not happened. panic for testing.")
+		ve := n.VarExprList[l.pc-2]
+		var bindings []matched
+		if ve.Pattern != nil {
+			var err *file.Error
+			if bindings, err = s.destructure(ve.Pattern, s.value, *l.env, nil); err != nil {
+				s.value = voidValue
+				return err
+			}
+		} else {
+			bindings = []matched{{name: ve.Variable.Name, value: s.value}}
+		}
+		for _, b := range bindings {
+			lastLocation := lookupEnv(b.name, *l.env)
+			if lastLocation == -1 {
+				panic("this should not happened. panic for testing.")
+			}
+			s.heap[lastLocation] = b.value
 		}
-		s.heap[lastLocation] = s.value
 	}
 	if l.pc == 0 {
 		for _, ve := range n.VarExprList {
-			*l.env = append(*l.env, envItem{
-				name:     ve.Variable.Name,
-				location: s.new(voidValue),
-			})
+			for _, v := range ast.PatternVariables(ve.Target()) {
+				*l.env = append(*l.env, envItem{
+					name:     v.Name,
+					location: s.new(voidValue),
+				})
+			}
 		}
 		l.pc++
 	} else if l.pc <= len(n.VarExprList) {
@@ -488,7 +1347,110 @@ func (s *state) VisitLetrecNode(n *ast.LetrecNode) *file.Error {
 		})
 		l.pc++
 	} else {
-		*l.env = (*l.env)[:len(*l.env)-len(n.VarExprList)]
+		count := 0
+		for _, ve := range n.VarExprList {
+			count += bound(ve.Target())
+		}
+		*l.env = (*l.env)[:len(*l.env)-count]
+		s.stack = s.stack[:len(s.stack)-1]
+	}
+	return nil
+}
+
+func (s *state) VisitLetNode(n *ast.LetNode) *file.Error {
+	l := s.stack[len(s.stack)-1]
+	// a sequential let binds each variable as soon as its expression is evaluated,
+	// while a parallel one keeps the values in args until they are all evaluated.
+	if 0 < l.pc && l.pc <= len(n.VarExprList) {
+		if n.Sequential {
+			if err := s.declare(l.env, n.VarExprList[l.pc-1], s.value); err != nil {
+				s.value = voidValue
+				return err
+			}
+		} else {
+			l.args = append(l.args, s.value)
+		}
+	}
+	if l.pc < len(n.VarExprList) {
+		s.stack = append(s.stack, &layer{
+			env:  l.env,
+			expr: n.VarExprList[l.pc].Expr,
+		})
+		l.pc++
+	} else if l.pc == len(n.VarExprList) {
+		if !n.Sequential {
+			for i, ve := range n.VarExprList {
+				if err := s.declare(l.env, ve, l.args[i]); err != nil {
+					s.value = voidValue
+					return err
+				}
+			}
+		}
+		s.stack = append(s.stack, &layer{
+			env:  l.env,
+			tail: l.frame || l.tail,
+			expr: n.Expr,
+		})
+		l.pc++
+	} else {
+		count := 0
+		for _, ve := range n.VarExprList {
+			count += bound(ve.Target())
+		}
+		*l.env = (*l.env)[:len(*l.env)-count]
+		s.stack = s.stack[:len(s.stack)-1]
+	}
+	return nil
+}
+
+// declare appends to env the variables of ve bound to value, which is destructured
+// if ve has a pattern.
+func (s *state) declare(env *[]envItem, ve *ast.LetrecVarExprItem, value Value) *file.Error {
+	var bindings []matched
+	if ve.Pattern != nil {
+		var err *file.Error
+		if bindings, err = s.destructure(ve.Pattern, value, *env, nil); err != nil {
+			return err
+		}
+	} else {
+		bindings = []matched{{name: ve.Variable.Name, value: value}}
+	}
+	for _, b := range bindings {
+		*env = append(*env, envItem{
+			name:     b.name,
+			location: s.new(b.value),
+		})
+	}
+	return nil
+}
+
+func (s *state) VisitLoopNode(n *ast.LoopNode) *file.Error {
+	l := s.stack[len(s.stack)-1]
+	// the initial values are evaluated outside of the loop, which is then entered by
+	// a call, in tail position, to a closure of Fun seeing itself as Name.
+	if 0 < l.pc && l.pc <= len(n.Inits) {
+		l.args = append(l.args, s.value)
+	}
+	if l.pc < len(n.Inits) {
+		s.stack = append(s.stack, &layer{
+			env:  l.env,
+			expr: n.Inits[l.pc],
+		})
+		l.pc++
+	} else if l.pc == len(n.Inits) {
+		location := s.new(voidValue)
+		env := append(filterLexical(*l.env), envItem{
+			name:     n.Name.Name,
+			location: location,
+		})
+		closure := NewClosure(env, n.Fun)
+		s.heap[location] = closure
+		if err := s.call(l, closure, l.args); err != nil {
+			s.value = voidValue
+			return err
+		}
+		l.pc++
+	} else {
 		s.stack = s.stack[:len(s.stack)-1]
 	}
 	return nil
@@ -503,7 +1465,7 @@ func (s *state) VisitIfNode(n *ast.IfNode) *file.Error {
 		})
 		l.pc++
 	} else if l.pc == 1 {
-		if v, ok := s.value.(*Number); !ok {
+		if v, ok := s.truth(s.value); !ok {
 			s.value = voidValue
 			return &file.Error{
 				Location: n.Cond.GetLocation(),
@@ -514,23 +1476,66 @@ func (s *state) VisitIfNode(n *ast.IfNode) *file.Error {
 				env:  l.env,
 				tail: l.frame || l.tail,
 			}
-			if v.Numerator != 0 {
+			if v {
 				newLayer.expr = n.Branch1
 			} else {
 				newLayer.expr = n.Branch2
 			}
-			s.stack = append(s.stack, newLayer)
+			s.stack = append(s.stack, newLayer)
+		}
+		l.pc++
+	} else {
+		s.stack = s.stack[:len(s.stack)-1]
+	}
+	return nil
+}
+
+// visitLogic evaluates the operands of `and` and `or` from left to right, until one
+// decides the result. The value of the last operand, evaluated in tail position, is
+// the result if no other operand decided it.
+func (s *state) visitLogic(n *ast.CallNode, and bool) *file.Error {
+	l := s.stack[len(s.stack)-1]
+	if len(n.ArgList) == 0 {
+		if and {
+			s.value = trueValue
+		} else {
+			s.value = falseValue
+		}
+		s.stack = s.stack[:len(s.stack)-1]
+		return nil
+	}
+	if l.pc == len(n.ArgList) {
+		s.stack = s.stack[:len(s.stack)-1]
+		return nil
+	}
+	if l.pc > 0 {
+		v, ok := s.truth(s.value)
+		if !ok {
+			s.value = voidValue
+			return &file.Error{
+				Location: n.ArgList[l.pc-1].GetLocation(),
+				Message:  "wrong type of arguments given to callee",
+			}
+		}
+		if v != and {
+			s.stack = s.stack[:len(s.stack)-1]
+			return nil
 		}
-		l.pc++
-	} else {
-		s.stack = s.stack[:len(s.stack)-1]
 	}
+	s.stack = append(s.stack, &layer{
+		env:  l.env,
+		tail: l.pc == len(n.ArgList)-1 && (l.frame || l.tail),
+		expr: n.ArgList[l.pc],
+	})
+	l.pc++
 	return nil
 }
 
 func (s *state) VisitCallNode(n *ast.CallNode) *file.Error {
 	l := s.stack[len(s.stack)-1]
-	if callee, ok := n.Callee.(*ast.IntrinsicNode); ok {
+	if callee, ok := n.Callee.(*ast.IntrinsicNode); ok && (callee.Name == "and" || callee.Name == "or") {
+		return s.visitLogic(n, callee.Name == "and")
+	} else if ok {
 		if 1 < l.pc && l.pc <= len(n.ArgList)+1 {
 			l.args = append(l.args, s.value)
 		}
@@ -565,63 +1570,177 @@ func (s *state) VisitCallNode(n *ast.CallNode) *file.Error {
 			})
 			l.pc++
 		} else if l.pc == len(n.ArgList)+2 {
-			if closure, ok := l.callee.(*Closure); ok {
-				if len(l.args) != len(closure.Fun.VarList) {
-					s.value = voidValue
-					return &file.Error{
-						Location: n.GetLocation(),
-						Message:  "wrong number of arguments given to callee",
-					}
-				}
-				env := make([]envItem, len(closure.Env))
-				copy(env, closure.Env)
-
-				if s.config.EnableTCO && (l.frame || l.tail) {
-					for _, old := range *l.env {
-						if !isLexical(old.name) {
-							overlap := false
-							for _, new := range closure.Fun.VarList {
-								if new.Name == old.name {
-									overlap = true
-									break
-								}
-							}
-							if !overlap {
-								env = append(env, old)
-							}
-						}
-					}
-				}
-				for i, v := range closure.Fun.VarList {
-					env = append(env, envItem{
-						name:     v.Name,
-						location: s.new(l.args[i]),
-					})
-				}
-				if s.config.EnableTCO && (l.frame || l.tail) {
-					for !s.stack[len(s.stack)-1].frame {
-						s.stack = s.stack[:len(s.stack)-1]
+			return s.apply(l, n.GetLocation(), n.Callee.GetLocation())
+		} else {
+			s.stack = s.stack[:len(s.stack)-1]
+		}
+	}
+	return nil
+}
+
+// apply applies the callee of the call layer l to its arguments. Errors about the
+// arguments are located at sl, and errors about the callee at calleeSl.
+func (s *state) apply(l *layer, sl, calleeSl file.SourceLocation) *file.Error {
+	if closure, ok := l.callee.(*Closure); ok {
+		if err := checkArity(sl, closure.Fun, len(l.args)); err != nil {
+			s.value = voidValue
+			return err
+		}
+		if err := s.call(l, closure, l.args); err != nil {
+			s.value = voidValue
+			return err
+		}
+		l.pc++
+	} else if continuation, ok := l.callee.(*Continuation); ok {
+		// a continuation captured outside of the innermost synchronous call would
+		// resume the code that waits for the call to return.
+		if n := len(s.barriers); n != 0 && !continuation.within(s.barriers[n-1]) {
+			s.value = voidValue
+			return &file.Error{
+				Location: sl,
+				Message:  "continuation escaped from callback",
+			}
+		}
+		// the continuation resumes with the value of the last argument.
+		if len(l.args) != 0 {
+			s.value = l.args[len(l.args)-1]
+		}
+		s.restore(continuation.Stack)
+	} else if constructor, ok := l.callee.(*Constructor); ok {
+		if len(l.args) != len(constructor.Fields) {
+			s.value = voidValue
+			return &file.Error{
+				Location: sl,
+				Message:  "wrong number of arguments given to callee",
+			}
+		}
+		values := make([]Value, len(l.args))
+		copy(values, l.args)
+		s.value = NewData(constructor, values)
+		s.stack = s.stack[:len(s.stack)-1]
+	} else if predicate, ok := l.callee.(*Predicate); ok {
+		if len(l.args) != 1 {
+			s.value = voidValue
+			return &file.Error{
+				Location: sl,
+				Message:  "wrong number of arguments given to callee",
+			}
+		}
+		if predicate.test(l.args[0]) {
+			s.value = trueValue
+		} else {
+			s.value = falseValue
+		}
+		s.stack = s.stack[:len(s.stack)-1]
+	} else {
+		s.value = voidValue
+		return &file.Error{
+			Location: calleeSl,
+			Message:  "calling non-callable object",
+		}
+	}
+	return nil
+}
+
+// call pushes the frame of a closure applied to args, which the arity of the closure
+// accepts. l is the layer of the call: a tail call replaces the frame of the caller
+// when TCO is enabled. If optional parameters are missing, a prologue evaluating their
+// defaults is pushed instead, see VisitLambdaNode.
+func (s *state) call(l *layer, closure *Closure, args []Value) *file.Error {
+	fun := closure.Fun
+	if len(args) < len(fun.VarList) {
+		given := make([]Value, len(args))
+		copy(given, args)
+		s.stack = append(s.stack, &layer{
+			env:    l.env,
+			tail:   l.frame || l.tail,
+			expr:   fun,
+			args:   given,
+			callee: closure,
+		})
+		return nil
+	}
+
+	env := make([]envItem, len(closure.Env))
+	copy(env, closure.Env)
+	if s.config.EnableTCO && (l.frame || l.tail) {
+		for _, old := range *l.env {
+			if !isLexical(old.name) {
+				overlap := fun.Rest != nil && fun.Rest.Name == old.name
+				for _, new := range fun.VarList {
+					if new.Name == old.name {
+						overlap = true
+						break
 					}
-					s.stack = s.stack[:len(s.stack)-1]
 				}
-				s.stack = append(s.stack, &layer{
-					env:   &env,
-					frame: true,
-					expr:  closure.Fun.Expr,
-				})
-				l.pc++
-			} else if continuation, ok := l.callee.(*Continuation); ok {
-				s.restore(continuation.Stack)
-			} else {
-				s.value = voidValue
-				return &file.Error{
-					Location: n.Callee.GetLocation(),
-					Message:  "calling non-callable object",
+				if !overlap {
+					env = append(env, old)
 				}
 			}
-		} else {
+		}
+	}
+	env, err := s.bind(env, fun, args)
+	if err != nil {
+		return err
+	}
+	if s.config.EnableTCO && (l.frame || l.tail) {
+		for !s.stack[len(s.stack)-1].frame {
 			s.stack = s.stack[:len(s.stack)-1]
 		}
+		s.stack = s.stack[:len(s.stack)-1]
+	}
+	s.stack = append(s.stack, &layer{
+		env:   &env,
+		frame: true,
+		expr:  fun.Expr,
+	})
+	return nil
+}
+
+// bind appends to env the parameters of fun bound to args. The trailing optional
+// parameters may be missing, in which case the rest parameter is not bound either.
+// Constructors of patterns destructuring arguments are looked up in env.
+func (s *state) bind(env []envItem, fun *ast.LambdaNode, args []Value) ([]envItem, *file.Error) {
+	for i, v := range fun.VarList {
+		if i == len(args) {
+			return env, nil
+		}
+		if fun.Patterns != nil && fun.Patterns[i] != nil {
+			bindings, err := s.destructure(fun.Patterns[i], args[i], env, nil)
+			if err != nil {
+				return nil, err
+			}
+			for _, b := range bindings {
+				env = append(env, envItem{
+					name:     b.name,
+					location: s.new(b.value),
+				})
+			}
+			continue
+		}
+		env = append(env, envItem{
+			name:     v.Name,
+			location: s.new(args[i]),
+		})
+	}
+	if fun.Rest != nil {
+		rest := make([]Value, len(args)-len(fun.VarList))
+		copy(rest, args[len(fun.VarList):])
+		env = append(env, envItem{
+			name:     fun.Rest.Name,
+			location: s.new(NewList(rest)),
+		})
+	}
+	return env, nil
+}
+
+// checkArity reports an error unless a closure of fun accepts n arguments.
+func checkArity(sl file.SourceLocation, fun *ast.LambdaNode, n int) *file.Error {
+	if n < len(fun.VarList)-len(fun.Defaults) || (n > len(fun.VarList) && fun.Rest == nil) {
+		return &file.Error{
+			Location: sl,
+			Message:  "wrong number of arguments given to callee",
+		}
 	}
 	return nil
 }
@@ -667,6 +1786,30 @@ func (s *state) VisitAccessNode(n *ast.AccessNode) *file.Error {
 			}
 			s.value = s.heap[location]
 			s.stack = s.stack[:len(s.stack)-1]
+		} else if data, ok := s.value.(*Data); ok {
+			for i, f := range data.Constructor.Fields {
+				if f == n.Variable.Name {
+					s.value = data.Values[i]
+					s.stack = s.stack[:len(s.stack)-1]
+					return nil
+				}
+			}
+			s.value = voidValue
+			return &file.Error{
+				Location: n.GetLocation(),
+				Message:  "undefined field",
+			}
+		} else if record, ok := s.value.(*Record); ok {
+			value, ok := record.get(n.Variable.Name)
+			if !ok {
+				s.value = voidValue
+				return &file.Error{
+					Location: n.GetLocation(),
+					Message:  "undefined field",
+				}
+			}
+			s.value = value
+			s.stack = s.stack[:len(s.stack)-1]
 		} else {
 			s.value = voidValue
 			return &file.Error{
@@ -677,3 +1820,279 @@ func (s *state) VisitAccessNode(n *ast.AccessNode) *file.Error {
 	}
 	return nil
 }
+
+func (s *state) VisitQuoteNode(n *ast.QuoteNode) *file.Error {
+	l := s.stack[len(s.stack)-1]
+	unquotes := unquotes(n.Expr)
+	if 0 < l.pc && l.pc <= len(unquotes) {
+		l.args = append(l.args, s.value)
+	}
+	if l.pc < len(unquotes) {
+		s.stack = append(s.stack, &layer{
+			env:  l.env,
+			expr: unquotes[l.pc].Expr,
+		})
+		l.pc++
+		return nil
+	}
+
+	values := make(map[*ast.UnquoteNode]Value)
+	for i, unquote := range unquotes {
+		values[unquote] = l.args[i]
+	}
+	node, err := substitute(n.Expr, values)
+	if err != nil {
+		s.value = voidValue
+		return err
+	}
+	s.value = NewCode(node)
+	s.stack = s.stack[:len(s.stack)-1]
+	return nil
+}
+
+func (s *state) VisitUnquoteNode(n *ast.UnquoteNode) *file.Error {
+	s.value = voidValue
+	return &file.Error{
+		Location: n.GetLocation(),
+		Message:  "unquote outside of quasiquote",
+	}
+}
+
+func (s *state) VisitRestNode(n *ast.RestNode) *file.Error {
+	s.value = voidValue
+	return &file.Error{
+		Location: n.GetLocation(),
+		Message:  "rest element outside of a macro",
+	}
+}
+
+func (s *state) VisitMacroNode(n *ast.MacroNode) *file.Error {
+	s.value = voidValue
+	return &file.Error{
+		Location: n.GetLocation(),
+		Message:  "macro must be expanded before execution",
+	}
+}
+
+func (s *state) VisitRecordNode(n *ast.RecordNode) *file.Error {
+	l := s.stack[len(s.stack)-1]
+	exprs := []ast.ExprNode{}
+	if n.Expr != nil {
+		exprs = append(exprs, n.Expr)
+	}
+	for _, f := range n.Fields {
+		exprs = append(exprs, f.Expr)
+	}
+	if 0 < l.pc && l.pc <= len(exprs) {
+		l.args = append(l.args, s.value)
+	}
+	if l.pc < len(exprs) {
+		s.stack = append(s.stack, &layer{
+			env:  l.env,
+			expr: exprs[l.pc],
+		})
+		l.pc++
+		return nil
+	}
+
+	fields, values := []string{}, l.args
+	if n.Expr != nil {
+		record, ok := l.args[0].(*Record)
+		if !ok {
+			s.value = voidValue
+			return &file.Error{
+				Location: n.GetLocation(),
+				Message:  "record update applied to non-record type",
+			}
+		}
+		fields = record.Fields
+		values = make([]Value, len(record.Values))
+		copy(values, record.Values)
+	updates:
+		for i, f := range n.Fields {
+			for j, name := range fields {
+				if name == f.Name.Name {
+					values[j] = l.args[i+1]
+					continue updates
+				}
+			}
+			s.value = voidValue
+			return &file.Error{
+				Location: f.Name.GetLocation(),
+				Message:  "undefined field",
+			}
+		}
+	} else {
+		for _, f := range n.Fields {
+			fields = append(fields, f.Name.Name)
+		}
+		values = make([]Value, len(l.args))
+		copy(values, l.args)
+	}
+	s.value = NewRecord(fields, values)
+	s.stack = s.stack[:len(s.stack)-1]
+	return nil
+}
+
+func (s *state) VisitMatchNode(n *ast.MatchNode) *file.Error {
+	l := s.stack[len(s.stack)-1]
+	// the value being matched is kept in args. Once case i matched, pc is 2*i+2
+	// while its guard is evaluated, then 2*i+3 while its expression is evaluated.
+	next := 0
+	if l.pc == 0 {
+		s.stack = append(s.stack, &layer{
+			env:  l.env,
+			expr: n.Expr,
+		})
+		l.pc++
+		return nil
+	} else if l.pc == 1 {
+		l.args = append(l.args, s.value)
+	} else if l.pc%2 == 0 {
+		c := n.Cases[(l.pc-2)/2]
+		v, ok := s.truth(s.value)
+		if !ok {
+			s.value = voidValue
+			return &file.Error{
+				Location: c.Guard.GetLocation(),
+				Message:  "wrong guard type",
+			}
+		}
+		if v {
+			s.stack = append(s.stack, &layer{
+				env:  l.env,
+				tail: l.frame || l.tail,
+				expr: c.Expr,
+			})
+			l.pc++
+			return nil
+		}
+		*l.env = (*l.env)[:len(*l.env)-bound(c.Pattern)]
+		next = (l.pc-2)/2 + 1
+	} else {
+		*l.env = (*l.env)[:len(*l.env)-bound(n.Cases[(l.pc-3)/2].Pattern)]
+		s.stack = s.stack[:len(s.stack)-1]
+		return nil
+	}
+
+	for i := next; i < len(n.Cases); i++ {
+		c := n.Cases[i]
+		bindings, ok, err := s.match(c.Pattern, l.args[0], *l.env, nil)
+		if err != nil {
+			s.value = voidValue
+			return err
+		}
+		if !ok {
+			continue
+		}
+		for _, b := range bindings {
+			*l.env = append(*l.env, envItem{
+				name:     b.name,
+				location: s.new(b.value),
+			})
+		}
+		if c.Guard != nil {
+			s.stack = append(s.stack, &layer{
+				env:  l.env,
+				expr: c.Guard,
+			})
+			l.pc = 2*i + 2
+		} else {
+			s.stack = append(s.stack, &layer{
+				env:  l.env,
+				tail: l.frame || l.tail,
+				expr: c.Expr,
+			})
+			l.pc = 2*i + 3
+		}
+		return nil
+	}
+	s.value = voidValue
+	return &file.Error{
+		Location: n.GetLocation(),
+		Message:  "non-exhaustive match",
+	}
+}
+
+func (s *state) VisitDataNode(n *ast.DataNode) *file.Error {
+	l := s.stack[len(s.stack)-1]
+	if l.pc == 0 {
+		t := newDataType(n)
+		for i, variant := range n.Variants {
+			*l.env = append(*l.env, envItem{
+				name:     variant.Name.Name,
+				location: s.new(t.Constructors[i]),
+			}, envItem{
+				name:     variant.Predicate.Name,
+				location: s.new(newPredicate(t, t.Constructors[i])),
+			})
+		}
+		*l.env = append(*l.env, envItem{
+			name:     n.Predicate.Name,
+			location: s.new(newPredicate(t, nil)),
+		})
+		s.stack = append(s.stack, &layer{
+			env:  l.env,
+			tail: l.frame || l.tail,
+			expr: n.Expr,
+		})
+		l.pc++
+	} else {
+		*l.env = (*l.env)[:len(*l.env)-2*len(n.Variants)-1]
+		s.stack = s.stack[:len(s.stack)-1]
+	}
+	return nil
+}
+
+func (s *state) VisitCondNode(n *ast.CondNode) *file.Error {
+	l := s.stack[len(s.stack)-1]
+	// the condition of case i is evaluated at pc 2*i, and checked at pc 2*i+1. Once an
+	// expression is chosen, pc is past them.
+	if l.pc > 2*len(n.Cases) {
+		s.stack = s.stack[:len(s.stack)-1]
+		return nil
+	}
+	i := l.pc / 2
+	if l.pc%2 == 1 {
+		v, ok := s.truth(s.value)
+		if !ok {
+			s.value = voidValue
+			return &file.Error{
+				Location: n.Cases[i].Cond.GetLocation(),
+				Message:  "wrong condition type",
+			}
+		}
+		if v {
+			s.stack = append(s.stack, &layer{
+				env:  l.env,
+				tail: l.frame || l.tail,
+				expr: n.Cases[i].Expr,
+			})
+			l.pc = 2*len(n.Cases) + 1
+			return nil
+		}
+		i++
+		l.pc++
+	}
+	if i < len(n.Cases) {
+		s.stack = append(s.stack, &layer{
+			env:  l.env,
+			expr: n.Cases[i].Cond,
+		})
+		l.pc++
+	} else if n.Else != nil {
+		s.stack = append(s.stack, &layer{
+			env:  l.env,
+			tail: l.frame || l.tail,
+			expr: n.Else,
+		})
+		l.pc = 2*len(n.Cases) + 1
+	} else {
+		s.value = voidValue
+		return &file.Error{
+			Location: n.GetLocation(),
+			Message:  "non-exhaustive cond",
+		}
+	}
+	return nil
+}
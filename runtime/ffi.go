@@ -0,0 +1,94 @@
+package runtime
+
+import (
+	"github.com/gogim1/goscript/file"
+)
+
+// Function is the signature of golang functions that can be invoked through `go`.
+// Returning a non-nil error aborts the script at the location of the `go` call.
+type Function func(ctx *Context, args ...Value) (Value, *file.Error)
+
+// Context is passed to FFI functions. It is only valid during the call it is given to.
+type Context struct {
+	state     *state
+	location  file.SourceLocation
+	roots     []Value
+	suspended bool
+}
+
+func (c *Context) Location() file.SourceLocation {
+	return c.location
+}
+
+// Error builds an error located at the `go` call that invoked the FFI function.
+func (c *Context) Error(message string) *file.Error {
+	return &file.Error{
+		Location: c.location,
+		Message:  message,
+	}
+}
+
+// Suspend makes the FFI call asynchronous: `return ctx.Suspend()` stops the execution
+// once the FFI function returns, and the result is later given to Resume (or the call
+// is failed by Reject). It is an error to suspend inside a synchronous call into
+// goscript, such as Call, a callback or a finalizer.
+func (c *Context) Suspend() (Value, *file.Error) {
+	c.suspended = true
+	return nil, nil
+}
+
+// Call synchronously invokes a goscript closure and returns its result.
+// The callee, the arguments and the result are kept alive until the FFI function returns.
+func (c *Context) Call(callee Value, args ...Value) (Value, *file.Error) {
+	closure, ok := callee.(*Closure)
+	if !ok {
+		return nil, c.Error("FFI can only call back into closures")
+	}
+	c.roots = append(c.roots, callee)
+	c.roots = append(c.roots, args...)
+
+	v, err := c.state.invoke(closure, args)
+	if err != nil {
+		if err.Location.Line <= 0 {
+			err.Location = c.location
+		}
+		return nil, err
+	}
+	c.roots = append(c.roots, v)
+	return v, nil
+}
+
+func (s *state) Register(name string, fun func(...Value) Value) *state {
+	return s.RegisterFunction(name, func(_ *Context, args ...Value) (Value, *file.Error) {
+		return fun(args...), nil
+	})
+}
+
+func (s *state) RegisterFunction(name string, fun Function) *state {
+	s.ffi[name] = fun
+	return s
+}
+
+func (s *state) callFFI(sl file.SourceLocation, fun Function, args []Value) (Value, *file.Error) {
+	ctx := &Context{state: s, location: sl}
+	s.contexts = append(s.contexts, ctx)
+	defer func() {
+		s.contexts = s.contexts[:len(s.contexts)-1]
+	}()
+
+	v, err := fun(ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+	if ctx.suspended {
+		if s.blocking != 0 {
+			return nil, ctx.Error("FFI cannot suspend inside a synchronous call")
+		}
+		s.suspended = true
+		return voidValue, nil
+	}
+	if v == nil {
+		return voidValue, nil
+	}
+	return v, nil
+}
@@ -1,6 +1,7 @@
 package runtime
 
 import (
+	"github.com/gogim1/goscript/ast"
 	"github.com/gogim1/goscript/file"
 )
 
@@ -12,57 +13,145 @@ func (s *state) new(value Value) int {
 
 func (s *state) gc() int {
 	s.mark()
+	s.prune()
 	s.sweep()
 	s.relocate()
+	s.collections++
+	s.collected += s.removed
 	return s.removed
 }
 
+type finalizer struct {
+	value  Value
+	host   func(Value)
+	script *Closure
+}
+
 type collector struct {
 	*state
 	values     map[int64]struct{}
 	locations  map[int]struct{}
 	relocation map[int]int
 	removed    int
+
+	collections int
+	collected   int
+
+	// retained are the programs loaded by `evalin` that reachable values refer to.
+	retained map[ast.ExprNode]struct{}
+
+	finalizers map[int64]*finalizer
+	pending    []*finalizer
+	finalizing bool
+	weakrefs   []*WeakRef
+}
+
+// isCollectable reports whether the value has an identity the collector can track.
+func isCollectable(value Value) bool {
+	switch value.(type) {
+	case *Closure, *Continuation, *HostObject, *Ref:
+		return true
+	}
+	return false
+}
+
+// SetFinalizer registers a function called with the value once it becomes unreachable.
+// A nil function removes the registered finalizer. Only values the collector tracks can
+// have finalizers, since the others may be shared or never become unreachable.
+func (s *state) SetFinalizer(value Value, fun func(Value)) *file.Error {
+	if !isCollectable(value) {
+		return &file.Error{
+			Location: file.SourceLocation{Line: -1, Col: -1},
+			Message:  "finalizer of a non-collectable value",
+		}
+	}
+	if fun == nil {
+		delete(s.finalizers, value.GetId())
+	} else {
+		s.finalizers[value.GetId()] = &finalizer{value: value, host: fun}
+	}
+	return nil
+}
+
+func (s *state) weakref(value Value) *WeakRef {
+	w := NewWeakRef(value)
+	s.weakrefs = append(s.weakrefs, w)
+	return w
 }
 
 func (c *collector) traverse(value Value, visitor func(Value)) {
+	if _, visited := c.values[value.GetId()]; visited {
+		return
+	}
+	c.values[value.GetId()] = struct{}{}
+	if visitor != nil {
+		visitor(value)
+	}
 	if closure, ok := value.(*Closure); ok {
-		if _, visited := c.values[value.GetId()]; !visited {
-			if visitor != nil {
-				visitor(value)
-			}
-			c.values[value.GetId()] = struct{}{}
-			for _, item := range closure.Env {
-				if _, visited := c.locations[item.location]; !visited {
-					c.locations[item.location] = struct{}{}
-					c.traverse(c.heap[item.location], visitor)
-				}
+		for _, item := range closure.Env {
+			if _, visited := c.locations[item.location]; !visited {
+				c.locations[item.location] = struct{}{}
+				c.traverse(c.heap[item.location], visitor)
 			}
 		}
 	} else if continuation, ok := value.(*Continuation); ok {
-		if _, visited := c.values[value.GetId()]; !visited {
-			if visitor != nil {
-				visitor(value)
-			}
-			c.values[value.GetId()] = struct{}{}
-			for _, layer := range continuation.Stack {
-				if layer.frame {
-					for _, item := range *layer.env {
-						if _, visited := c.locations[item.location]; !visited {
-							c.locations[item.location] = struct{}{}
-							c.traverse(c.heap[item.location], visitor)
-						}
-					}
-				}
-				if len(layer.args) != 0 {
-					for _, v := range layer.args {
-						c.traverse(v, visitor)
+		for _, layer := range continuation.Stack {
+			if layer.frame {
+				for _, item := range *layer.env {
+					if _, visited := c.locations[item.location]; !visited {
+						c.locations[item.location] = struct{}{}
+						c.traverse(c.heap[item.location], visitor)
 					}
 				}
-				if layer.callee != nil {
-					c.traverse(layer.callee, visitor)
+			}
+			if len(layer.args) != 0 {
+				for _, v := range layer.args {
+					c.traverse(v, visitor)
 				}
 			}
+			if layer.callee != nil {
+				c.traverse(layer.callee, visitor)
+			}
+		}
+	} else if ref, ok := value.(*Ref); ok {
+		if _, visited := c.locations[ref.Cell]; !visited {
+			c.locations[ref.Cell] = struct{}{}
+			c.traverse(c.heap[ref.Cell], visitor)
+		}
+	} else if list, ok := value.(*List); ok {
+		for _, v := range list.Elems {
+			c.traverse(v, visitor)
+		}
+	} else if data, ok := value.(*Data); ok {
+		for _, v := range data.Values {
+			c.traverse(v, visitor)
+		}
+	} else if record, ok := value.(*Record); ok {
+		for _, v := range record.Values {
+			c.traverse(v, visitor)
+		}
+	}
+}
+
+func (c *collector) roots(visitor func(Value)) {
+	c.traverse(NewContinuation(file.SourceLocation{Line: -1, Col: -1}, c.stack), visitor)
+	if c.value != nil {
+		c.traverse(c.value, visitor)
+	}
+	for _, ctx := range c.contexts {
+		for _, v := range ctx.roots {
+			c.traverse(v, visitor)
+		}
+	}
+	for _, f := range c.finalizers {
+		if f.script != nil {
+			c.traverse(f.script, visitor)
+		}
+	}
+	for _, f := range c.pending {
+		c.traverse(f.value, visitor)
+		if f.script != nil {
+			c.traverse(f.script, visitor)
 		}
 	}
 }
@@ -70,15 +159,79 @@ func (c *collector) traverse(value Value, visitor func(Value)) {
 func (c *collector) mark() {
 	clear(c.values)
 	clear(c.locations)
+	clear(c.retained)
 
-	c.traverse(NewContinuation(file.SourceLocation{Line: -1, Col: -1}, c.stack), nil)
-	if c.value != nil {
-		c.traverse(c.value, nil)
+	c.roots(c.retain)
+
+	for _, w := range c.weakrefs {
+		if _, ok := c.values[w.Target.GetId()]; w.Target != voidValue && !ok {
+			w.Target = voidValue
+		}
+	}
+	for id, f := range c.finalizers {
+		if _, ok := c.values[id]; !ok {
+			c.pending = append(c.pending, f)
+			delete(c.finalizers, id)
+			// keep the value alive until its finalizer has been run.
+			c.traverse(f.value, c.retain)
+		}
+	}
+	weakrefs := c.weakrefs[:0]
+	for _, w := range c.weakrefs {
+		if _, ok := c.values[w.GetId()]; ok {
+			weakrefs = append(weakrefs, w)
+		}
 	}
+	clear(c.weakrefs[len(weakrefs):])
+	c.weakrefs = weakrefs
+}
+
+// retain records the program loaded by `evalin` that the code of a value is part of.
+func (c *collector) retain(value Value) {
+	nodes := []ast.ExprNode{}
+	switch v := value.(type) {
+	case *Closure:
+		nodes = append(nodes, v.Fun)
+	case *Continuation:
+		for _, l := range v.Stack {
+			nodes = append(nodes, l.expr)
+		}
+	case *Constructor:
+		nodes = append(nodes, v.Type.Node)
+	case *Predicate:
+		nodes = append(nodes, v.Type.Node)
+	case *Data:
+		nodes = append(nodes, v.Constructor.Type.Node)
+	}
+	for _, node := range nodes {
+		if program, ok := c.owners[node]; ok {
+			c.retained[program] = struct{}{}
+		}
+	}
+}
+
+// prune drops the programs loaded by `evalin` that are no longer retained.
+func (c *collector) prune() {
+	programs := c.programs[:0]
+	for _, program := range c.programs {
+		if _, ok := c.sources[program]; ok {
+			if _, ok := c.retained[program]; !ok {
+				delete(c.sources, program)
+				ast.Walk(program, func(node ast.ExprNode) {
+					delete(c.owners, node)
+				})
+				continue
+			}
+		}
+		programs = append(programs, program)
+	}
+	clear(c.programs[len(programs):])
+	c.programs = programs
 }
 
 func (c *collector) sweep() {
 	clear(c.relocation)
+	c.removed = 0
 
 	if len(c.locations) == len(c.heap) {
 		return
@@ -121,11 +274,36 @@ func (c *collector) relocate() {
 					}
 				}
 			}
+		} else if ref, ok := value.(*Ref); ok {
+			ref.Cell = c.relocation[ref.Cell]
 		}
 	}
 
-	c.traverse(NewContinuation(file.SourceLocation{Line: -1, Col: -1}, c.stack), patcher)
-	if c.value != nil {
-		c.traverse(c.value, patcher)
+	c.roots(patcher)
+}
+
+// finalize runs the finalizers of values found unreachable by previous collections.
+// It may run between any two steps, so the value being computed is kept intact.
+func (c *collector) finalize() *file.Error {
+	if c.finalizing {
+		return nil
+	}
+	c.finalizing = true
+	value := c.value
+	defer func() {
+		c.finalizing = false
+		c.value = value
+	}()
+
+	for len(c.pending) != 0 {
+		f := c.pending[0]
+		if f.host != nil {
+			f.host(f.value)
+		} else if _, err := c.invoke(f.script, []Value{f.value}); err != nil {
+			c.pending = c.pending[1:]
+			return err
+		}
+		c.pending = c.pending[1:]
 	}
+	return nil
 }
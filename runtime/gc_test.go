@@ -0,0 +1,240 @@
+package runtime
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/gogim1/goscript/conf"
+	"github.com/gogim1/goscript/file"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestState(t *testing.T, src string, opts ...conf.Option) *state {
+	node, err := lexAndParse(src)
+	require.Nil(t, err)
+	opts = append([]conf.Option{conf.SetGCTrigger(func() bool { return false })}, opts...)
+	return NewState(node, conf.New(opts...))
+}
+
+func TestFinalizer(t *testing.T) {
+	t.Run("host", func(t *testing.T) {
+		var s *state
+		finalized := []Value{}
+		open := func(ctx *Context, args ...Value) (Value, *file.Error) {
+			object := NewHostObject("file", nil)
+			return object, s.SetFinalizer(object, func(v Value) { finalized = append(finalized, v) })
+		}
+
+		s = newTestState(t, `[letrec (a = (go "open")) { a } letrec (b = (go "open")) { lambda () { b } }]`).RegisterFunction("open", open)
+		require.Nil(t, s.Execute())
+		s.gc()
+		require.Nil(t, s.finalize())
+		assert.Len(t, finalized, 1)
+
+		s.gc()
+		require.Nil(t, s.finalize())
+		assert.Len(t, finalized, 1)
+
+		s.value = voidValue
+		s.gc()
+		require.Nil(t, s.finalize())
+		assert.Len(t, finalized, 2)
+		assert.NotEqual(t, finalized[0], finalized[1])
+	})
+
+	t.Run("script", func(t *testing.T) {
+		closed := 0
+		close := func(ctx *Context, args ...Value) (Value, *file.Error) {
+			closed++
+			assert.IsType(t, &HostObject{}, args[0])
+			return nil, nil
+		}
+		open := func(ctx *Context, args ...Value) (Value, *file.Error) {
+			return NewHostObject("file", nil), nil
+		}
+
+		src := `letrec (
+			onclose = lambda (v) { (go "close" v) }
+		) {[
+			(finalize (go "open") onclose)
+			(finalize (go "open") onclose)
+			(void)
+		]}`
+		s := newTestState(t, src).RegisterFunction("open", open).RegisterFunction("close", close)
+		require.Nil(t, s.Execute())
+		assert.Equal(t, 0, closed)
+		s.gc()
+		require.Nil(t, s.finalize())
+		assert.Equal(t, 2, closed)
+
+		s = newTestState(t, src, conf.SetGCTrigger(func() bool { return true })).
+			RegisterFunction("open", open).RegisterFunction("close", close)
+		require.Nil(t, s.Execute())
+		assert.Equal(t, 4, closed)
+	})
+
+	t.Run("value", func(t *testing.T) {
+		src := `letrec (fin = lambda (v) { 999 }) { (add 10 letrec (x = lambda () { 1 }) { [(finalize x fin) 5] }) }`
+		for _, trigger := range []bool{true, false} {
+			s := newTestState(t, src, conf.SetGCTrigger(func() bool { return trigger }))
+			require.Nil(t, s.Execute())
+			assert.Equal(t, "15", s.Value().String())
+		}
+	})
+
+	t.Run("suspended", func(t *testing.T) {
+		wait := func(ctx *Context, args ...Value) (Value, *file.Error) {
+			return ctx.Suspend()
+		}
+		src := `letrec (
+			fin = lambda (v) { 999 }
+		) {[
+			letrec (x = lambda () { 1 }) { (finalize x fin) }
+			(add 10 (go "wait"))
+		]}`
+		// the collection finding x unreachable happens as the call suspends.
+		var s *state
+		s = newTestState(t, src, conf.SetGCTrigger(func() bool { return s.suspended })).RegisterFunction("wait", wait)
+		require.Nil(t, s.Execute())
+		require.True(t, s.Suspended())
+		assert.Len(t, s.pending, 1)
+		require.Nil(t, s.Resume(NewNumber(5, 1)))
+		assert.Equal(t, "15", s.Value().String())
+
+		require.Nil(t, s.finalize())
+		assert.Len(t, s.pending, 0)
+	})
+
+	t.Run("resurrection", func(t *testing.T) {
+		src := `letrec (
+			saved = lambda () { 0 }
+		) {[
+			(finalize letrec (x = 42) { lambda () { x } } lambda (v) { (reg "saved" v) })
+			(void)
+		]}`
+		s := newTestState(t, src)
+		require.Nil(t, s.Execute())
+		s.new(NewString("garbage"))
+		s.gc()
+		require.Nil(t, s.finalize())
+		s.gc()
+		v, err := s.Call("saved")
+		require.Nil(t, err)
+		assert.Equal(t, "42", v.String())
+	})
+
+	t.Run("non-collectable", func(t *testing.T) {
+		s := newTestState(t, `1`)
+		for _, v := range []Value{retrieveNumberValue(1, 1), retrieveStringValue("a"), voidValue, NewList(nil)} {
+			assert.NotNil(t, s.SetFinalizer(v, func(Value) {}))
+		}
+		assert.Len(t, s.finalizers, 0)
+		assert.Nil(t, s.SetFinalizer(NewHostObject("file", nil), func(Value) {}))
+		assert.Len(t, s.finalizers, 1)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		s := newTestState(t, `[(finalize lambda () { 1 } lambda (v) { (add v 1) }) 1]`)
+		require.Nil(t, s.Execute())
+		s.gc()
+		assert.NotNil(t, s.finalize())
+	})
+}
+
+func TestWeakRef(t *testing.T) {
+	src := `letrec (
+		keep = lambda () { 1 }
+		strong = (weakref keep)
+		weak = (weakref lambda () { 2 })
+	) {[
+		(reg "strong" lambda () { (weakget strong) })
+		(reg "weak" lambda () { (weakget weak) })
+		(isweak weak)
+	]}`
+	s := newTestState(t, src)
+	require.Nil(t, s.Execute())
+	assert.Equal(t, "true", s.Value().String())
+
+	v, err := s.Call("weak")
+	require.Nil(t, err)
+	assert.IsType(t, &Closure{}, v)
+
+	s.value = voidValue
+	s.gc()
+	v, err = s.Call("weak")
+	require.Nil(t, err)
+	assert.Equal(t, "<void>", v.String())
+	v, err = s.Call("strong")
+	require.Nil(t, err)
+	assert.IsType(t, &Closure{}, v)
+	assert.Len(t, s.weakrefs, 2)
+
+	s = newTestState(t, `[(weakref lambda () { 1 }) 1]`)
+	require.Nil(t, s.Execute())
+	s.gc()
+	assert.Len(t, s.weakrefs, 0)
+
+	for _, src := range []string{`(weakref 1)`, `(weakget 1)`, `(finalize "str" lambda (v) { v })`, `(finalize lambda () { 1 } lambda () { 1 })`} {
+		s = newTestState(t, src)
+		assert.NotNil(t, s.Execute())
+	}
+}
+
+func TestRef(t *testing.T) {
+	s := newTestState(t, `letrec (r = (ref 1)) {[
+		(setref r (ref 2))
+		(ref 3)
+		(reg "get" lambda () { (deref (deref r)) })
+		(weakref r)
+	]}`)
+	require.Nil(t, s.Execute())
+	w := s.Value().(*WeakRef)
+	s.value = voidValue
+	assert.Equal(t, 1, s.gc())
+
+	v, err := s.Call("get")
+	require.Nil(t, err)
+	assert.Equal(t, "2", v.String())
+	assert.IsType(t, &Ref{}, w.Target)
+}
+
+func TestGC_containers(t *testing.T) {
+	srcs := []string{
+		`letrec (r = {f = letrec (x = 42) { lambda () { x } } n = 1}) { [(mklist 1 2 3) (&f {r | n = 2})] }`,
+		`data box (mkbox (f)) { letrec (b = (mkbox letrec (x = 42) { lambda () { x } })) { [(mklist 1 2 3) (&f b)] } }`,
+		`letrec (l = (mklist letrec (x = 42) { lambda () { x } })) { [(mklist 1 2 3) ((listget l 0))] }`,
+		`letrec (r = (ref 0)) { [(setref r letrec (x = 42) { lambda () { x } }) (mklist 1 2 3) ((deref r))] }`,
+		`letrec (f = lambda (a b = letrec (x = a) { lambda () { x } } @r) { [(mklist 1 2 3) (b)] }) { (f 42) }`,
+		`let (f = let (x = 42) { lambda () { x } }) { [(mklist 1 2 3) (f)] }`,
+		`loop (f = let (x = 42) { lambda () { x } } n = 0) { if (eq n 2) then (f) else [(mklist 1 2 3) (recur f (add n 1))] }`,
+	}
+	for _, src := range srcs {
+		s := newTestState(t, src, conf.SetGCTrigger(func() bool { return true }))
+		require.Nil(t, s.Execute())
+		assert.Equal(t, "42", s.Value().String())
+		assert.NotZero(t, s.collected)
+	}
+}
+
+func TestGC_programs(t *testing.T) {
+	src := `[
+		loop (i = 0) { if (eq i 100) then (void) else [(evalin "(add 1 1)" lambda () { 0 }) (recur (add i 1))] }
+		(evalin "lambda () { 42 }" lambda () { 0 })
+	]`
+	s := newTestState(t, src, conf.SetGCTrigger(func() bool { return true }))
+	require.Nil(t, s.Execute())
+	assert.Len(t, s.programs, 2)
+	assert.Len(t, s.sources, 1)
+	require.Nil(t, s.Snapshot(&bytes.Buffer{}))
+
+	v, err := s.invoke(s.Value().(*Closure), nil)
+	require.Nil(t, err)
+	assert.Equal(t, "42", v.String())
+
+	s.value = voidValue
+	s.gc()
+	assert.Len(t, s.programs, 1)
+	assert.Len(t, s.sources, 0)
+	assert.Len(t, s.owners, 0)
+}
@@ -7,8 +7,8 @@ const tpl = `// Code generated by runtime/helper/main.go. DO NOT EDIT.
 package runtime
 
 var (
-	trueValue     = NewNumber(1, 1)
-	falseValue    = NewNumber(0, 1)
+	trueValue     = NewBool(true)
+	falseValue    = NewBool(false)
 	voidValue     = NewVoid()
 	emptyStrValue = NewString("")
 )
@@ -0,0 +1,196 @@
+package runtime
+
+import (
+	"io"
+	"os"
+
+	"github.com/gogim1/goscript/ast"
+	"github.com/gogim1/goscript/conf"
+	"github.com/gogim1/goscript/file"
+)
+
+// Interpreter is the embedding API of goscript. Programs loaded into the same
+// interpreter share its global environment, heap and registered FFI functions.
+type Interpreter struct {
+	state   *state
+	config  *conf.Config
+	pending []ast.ExprNode
+}
+
+type Stats struct {
+	HeapSize    int // number of cells currently allocated
+	StackDepth  int
+	Collections int // number of GC cycles completed
+	Collected   int // number of cells reclaimed by all GC cycles
+}
+
+func NewInterpreter(opts ...conf.Option) *Interpreter {
+	config := conf.New(opts...)
+	return &Interpreter{
+		state:  newState(config),
+		config: config,
+	}
+}
+
+// Run executes a program in a fresh interpreter and returns its value.
+func Run(src string, opts ...conf.Option) (Value, *file.Error) {
+	i := NewInterpreter(opts...)
+	if err := i.Load(src); err != nil {
+		return nil, err
+	}
+	return i.Execute()
+}
+
+// Load parses a program and queues it for the next Execute.
+func (i *Interpreter) Load(src string) *file.Error {
+	node, err := lexAndParse(src)
+	if err != nil {
+		return err
+	}
+	i.LoadNode(node)
+	return nil
+}
+
+func (i *Interpreter) LoadFile(filepath string) *file.Error {
+	bytes, err := os.ReadFile(filepath)
+	if err != nil {
+		return &file.Error{
+			Location: file.SourceLocation{Line: -1, Col: -1},
+			Message:  err.Error(),
+		}
+	}
+	return i.Load(string(bytes))
+}
+
+func (i *Interpreter) LoadNode(expr ast.ExprNode) {
+	i.pending = append(i.pending, expr)
+}
+
+// Execute runs the queued programs in the order they were loaded and returns
+// the value of the last one. If an asynchronous FFI call suspends the execution,
+// Execute returns a nil value and the remaining programs run once it is resumed.
+func (i *Interpreter) Execute() (Value, *file.Error) {
+	if i.state.suspended {
+		return nil, suspendedError()
+	}
+	return i.run()
+}
+
+func (i *Interpreter) run() (Value, *file.Error) {
+	for {
+		if err := i.state.Execute(); err != nil {
+			i.abort()
+			return nil, err
+		}
+		if i.state.suspended {
+			return nil, nil
+		}
+		if len(i.pending) == 0 {
+			return i.state.Value(), nil
+		}
+		expr := i.pending[0]
+		i.pending = i.pending[1:]
+		i.state.load(expr)
+	}
+}
+
+func (i *Interpreter) abort() {
+	i.state.stack = i.state.stack[:1]
+	i.state.capabilities = i.state.sandbox()
+	i.pending = nil
+}
+
+func (i *Interpreter) Suspended() bool {
+	return i.state.suspended
+}
+
+// Resume continues a suspended execution with value as the result of the suspended
+// FFI call, and returns like Execute.
+func (i *Interpreter) Resume(value Value) (Value, *file.Error) {
+	if err := i.state.resume(value); err != nil {
+		return nil, err
+	}
+	return i.run()
+}
+
+// Reject fails a suspended FFI call with an error located at the call.
+func (i *Interpreter) Reject(message string) *file.Error {
+	if !i.state.suspended {
+		return notSuspendedError()
+	}
+	err := i.state.Reject(message)
+	i.abort()
+	return err
+}
+
+func (i *Interpreter) Value() Value {
+	return i.state.Value()
+}
+
+func (i *Interpreter) Call(name string, args ...any) (Value, *file.Error) {
+	return i.state.Call(name, args...)
+}
+
+func (i *Interpreter) CallDynamic(name string, bindings map[string]Value, args ...any) (Value, *file.Error) {
+	return i.state.CallDynamic(name, bindings, args...)
+}
+
+func (i *Interpreter) GetGlobal(name string) (Value, bool) {
+	return i.state.GetGlobal(name)
+}
+
+func (i *Interpreter) SetGlobal(name string, value Value) *file.Error {
+	return i.state.SetGlobal(name, value)
+}
+
+func (i *Interpreter) Globals() map[string]Value {
+	return i.state.Globals()
+}
+
+func (i *Interpreter) Register(name string, fun func(...Value) Value) *Interpreter {
+	i.state.Register(name, fun)
+	return i
+}
+
+func (i *Interpreter) RegisterFunction(name string, fun Function) *Interpreter {
+	i.state.RegisterFunction(name, fun)
+	return i
+}
+
+func (i *Interpreter) SetFinalizer(value Value, fun func(Value)) *file.Error {
+	return i.state.SetFinalizer(value, fun)
+}
+
+func (i *Interpreter) Snapshot(w io.Writer) error {
+	return i.state.Snapshot(w)
+}
+
+// Restore replaces the state of the interpreter with a snapshot. The programs the
+// snapshot was taken from must have been loaded again, in the same order, beforehand.
+func (i *Interpreter) Restore(r io.Reader) error {
+	for _, expr := range i.pending {
+		i.state.load(expr)
+	}
+	i.pending = nil
+	return i.state.Restore(r)
+}
+
+func (i *Interpreter) Stats() Stats {
+	s := i.state
+	return Stats{
+		HeapSize:    len(s.heap),
+		StackDepth:  len(s.stack),
+		Collections: s.collections,
+		Collected:   s.collected,
+	}
+}
+
+// Reset discards every binding, value and queued program. Registered FFI functions are kept.
+func (i *Interpreter) Reset() {
+	ffi := i.state.ffi
+	i.state = newState(i.config)
+	for name, fun := range ffi {
+		i.state.RegisterFunction(name, fun)
+	}
+	i.pending = nil
+}
@@ -0,0 +1,167 @@
+package runtime_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gogim1/goscript/conf"
+	"github.com/gogim1/goscript/file"
+	"github.com/gogim1/goscript/runtime"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInterpreter(t *testing.T) {
+	t.Run("load and execute", func(t *testing.T) {
+		i := runtime.NewInterpreter()
+		require.Nil(t, i.Load(`(reg "inc" lambda (x) { (add x 1) })`))
+		require.Nil(t, i.Load(`(inc 41)`))
+		v, err := i.Execute()
+		require.Nil(t, err)
+		assert.Equal(t, `42`, v.String())
+		assert.Equal(t, `42`, i.Value().String())
+
+		v, err = i.Call("inc", 1)
+		require.Nil(t, err)
+		assert.Equal(t, `2`, v.String())
+
+		assert.NotNil(t, i.Load(`(inc`))
+		require.Nil(t, i.Load(`(inc "1")`))
+		_, err = i.Execute()
+		assert.NotNil(t, err)
+
+		require.Nil(t, i.Load(`(inc 1)`))
+		v, err = i.Execute()
+		require.Nil(t, err)
+		assert.Equal(t, `2`, v.String())
+	})
+
+	t.Run("load file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "lib.gs")
+		require.Nil(t, os.WriteFile(path, []byte(`(reg "double" lambda (x) { (mul x 2) })`), 0o644))
+
+		i := runtime.NewInterpreter()
+		require.Nil(t, i.LoadFile(path))
+		require.Nil(t, i.Load(`(double 21)`))
+		v, err := i.Execute()
+		require.Nil(t, err)
+		assert.Equal(t, `42`, v.String())
+
+		assert.NotNil(t, i.LoadFile(filepath.Join(t.TempDir(), "missing.gs")))
+	})
+
+	t.Run("globals", func(t *testing.T) {
+		i := runtime.NewInterpreter()
+		require.Nil(t, i.SetGlobal("x", runtime.NewNumber(1, 1)))
+		require.Nil(t, i.Load(`(add x 1)`))
+		v, err := i.Execute()
+		require.Nil(t, err)
+		assert.Equal(t, `2`, v.String())
+
+		require.Nil(t, i.SetGlobal("x", runtime.NewString("str")))
+		v, ok := i.GetGlobal("x")
+		assert.True(t, ok)
+		assert.Equal(t, `str`, v.String())
+
+		_, ok = i.GetGlobal("y")
+		assert.False(t, ok)
+
+		// programs loaded afterwards capture the binding, and observe overwrites.
+		require.Nil(t, i.Load(`[1 2 3 (reg "get" lambda () { x })]`))
+		_, err = i.Execute()
+		require.Nil(t, err)
+		require.Nil(t, i.SetGlobal("x", runtime.NewNumber(42, 1)))
+		v, err = i.Call("get")
+		require.Nil(t, err)
+		assert.Equal(t, `42`, v.String())
+		assert.Equal(t, `42`, i.Globals()["x"].String())
+	})
+
+	t.Run("register", func(t *testing.T) {
+		i := runtime.NewInterpreter().Register("one", func(args ...runtime.Value) runtime.Value {
+			return runtime.NewNumber(1, 1)
+		})
+		require.Nil(t, i.Load(`(go "one")`))
+		v, err := i.Execute()
+		require.Nil(t, err)
+		assert.Equal(t, `1`, v.String())
+	})
+
+	t.Run("stats and reset", func(t *testing.T) {
+		i := runtime.NewInterpreter(conf.SetGCTrigger(func() bool { return true }))
+		require.Nil(t, i.Load(`letrec (a = 1 b = 2) { (reg "f" lambda () { a }) }`))
+		_, err := i.Execute()
+		require.Nil(t, err)
+		stats := i.Stats()
+		assert.Equal(t, 1, stats.StackDepth)
+		assert.Equal(t, 3, stats.HeapSize)
+		assert.Less(t, 0, stats.Collections)
+
+		i.Reset()
+		assert.Equal(t, runtime.Stats{StackDepth: 1}, i.Stats())
+		_, err = i.Call("f")
+		assert.NotNil(t, err)
+	})
+
+	t.Run("run after evalin failed", func(t *testing.T) {
+		i := runtime.NewInterpreter(conf.SetEvalCapabilities(&conf.Capabilities{FFI: conf.Except("secret")})).
+			Register("secret", func(args ...runtime.Value) runtime.Value {
+				return runtime.NewString("SECRET")
+			})
+		require.Nil(t, i.Load(`(evalin "(div 1 0)" lambda () { 0 })`))
+		_, err := i.Execute()
+		require.NotNil(t, err)
+		assert.Equal(t, 1, i.Stats().StackDepth)
+
+		require.Nil(t, i.Load(`(go "secret")`))
+		v, err := i.Execute()
+		require.Nil(t, err)
+		assert.Equal(t, `SECRET`, v.String())
+	})
+
+	t.Run("suspend and resume", func(t *testing.T) {
+		i := runtime.NewInterpreter().RegisterFunction("fetch", func(ctx *runtime.Context, args ...runtime.Value) (runtime.Value, *file.Error) {
+			return ctx.Suspend()
+		})
+		require.Nil(t, i.Load(`letrec (x = (go "fetch")) { (reg "x" lambda () { x }) }`))
+		require.Nil(t, i.Load(`(add (x) (go "fetch"))`))
+		v, err := i.Execute()
+		require.Nil(t, err)
+		assert.Nil(t, v)
+		assert.True(t, i.Suspended())
+		_, err = i.Execute()
+		assert.NotNil(t, err)
+
+		v, err = i.Resume(runtime.NewNumber(40, 1))
+		require.Nil(t, err)
+		assert.Nil(t, v)
+		v, err = i.Resume(runtime.NewNumber(2, 1))
+		require.Nil(t, err)
+		assert.Equal(t, `42`, v.String())
+		assert.False(t, i.Suspended())
+		_, err = i.Resume(nil)
+		assert.NotNil(t, err)
+
+		require.Nil(t, i.Load(`(go "fetch")`))
+		require.Nil(t, i.Load(`(x)`))
+		_, err = i.Execute()
+		require.Nil(t, err)
+		assert.NotNil(t, i.Reject("connection refused"))
+		assert.False(t, i.Suspended())
+		assert.Equal(t, 1, i.Stats().StackDepth)
+		require.Nil(t, i.Load(`(x)`))
+		v, err = i.Execute()
+		require.Nil(t, err)
+		assert.Equal(t, `40`, v.String())
+	})
+}
+
+func TestRun(t *testing.T) {
+	v, err := runtime.Run(`letrec (f = lambda (n) { if (eq n 0) then 1 else (mul n (f (sub n 1))) }) { (f 5) }`)
+	require.Nil(t, err)
+	assert.Equal(t, `120`, v.String())
+
+	_, err = runtime.Run(`(add 1 "1")`, conf.EnableTCO(false))
+	assert.NotNil(t, err)
+}
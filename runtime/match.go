@@ -0,0 +1,236 @@
+package runtime
+
+import (
+	"fmt"
+
+	"github.com/gogim1/goscript/ast"
+	"github.com/gogim1/goscript/file"
+)
+
+type matched struct {
+	name  string
+	value Value
+}
+
+// match matches value against a pattern of `match`, appending the values bound by
+// the pattern to bindings in source order. Constructors of patterns are looked up
+// in env.
+func (s *state) match(pattern ast.ExprNode, value Value, env []envItem, bindings []matched) ([]matched, bool, *file.Error) {
+	switch p := pattern.(type) {
+	case *ast.NumberNode:
+		v, ok := value.(*Number)
+		return bindings, ok && v.Numerator*p.Denominator == p.Numerator*v.Denominator, nil
+	case *ast.FloatNode:
+		v, ok := value.(*Float)
+		return bindings, ok && v.Value == p.Value, nil
+	case *ast.BoolNode:
+		v, ok := value.(*Bool)
+		return bindings, ok && v.Value == p.Value, nil
+	case *ast.StringNode:
+		v, ok := value.(*String)
+		return bindings, ok && v.Value == p.Value, nil
+	case *ast.VariableNode:
+		if p.Name == "_" {
+			return bindings, true, nil
+		}
+		return append(bindings, matched{name: p.Name, value: value}), true, nil
+	case *ast.CallNode:
+		callee, ok := p.Callee.(*ast.VariableNode)
+		if !ok {
+			// `(void)`.
+			_, ok := value.(*Void)
+			return bindings, ok, nil
+		}
+		constructor, err := s.constructor(p, callee, env)
+		if err != nil {
+			return bindings, false, err
+		}
+		v, ok := value.(*Data)
+		if !ok || v.Constructor != constructor {
+			return bindings, false, nil
+		}
+		for i, arg := range p.ArgList {
+			var err *file.Error
+			if bindings, ok, err = s.match(arg, v.Values[i], env, bindings); !ok || err != nil {
+				return bindings, false, err
+			}
+		}
+		return bindings, true, nil
+	case *ast.SequenceNode:
+		v, ok := value.(*List)
+		if !ok {
+			return bindings, false, nil
+		}
+		for i, element := range p.ExprList {
+			if rest, ok := element.(*ast.RestNode); ok {
+				elems := make([]Value, len(v.Elems)-i)
+				copy(elems, v.Elems[i:])
+				return append(bindings, matched{name: rest.Name, value: NewList(elems)}), true, nil
+			}
+			if i >= len(v.Elems) {
+				return bindings, false, nil
+			}
+			var err *file.Error
+			if bindings, ok, err = s.match(element, v.Elems[i], env, bindings); !ok || err != nil {
+				return bindings, false, err
+			}
+		}
+		return bindings, len(p.ExprList) == len(v.Elems), nil
+	case *ast.RecordNode:
+		// closures are matched by the presence of variables in their environment, data
+		// and records by the presence of fields.
+		for _, f := range p.Fields {
+			field, ok := s.field(value, f.Name.Name)
+			if !ok {
+				return bindings, false, nil
+			}
+			var err *file.Error
+			if bindings, ok, err = s.match(f.Expr, field, env, bindings); !ok || err != nil {
+				return bindings, false, err
+			}
+		}
+		return bindings, true, nil
+	}
+	return bindings, false, nil
+}
+
+// constructor returns the constructor of a pattern, looked up in env.
+func (s *state) constructor(p *ast.CallNode, callee *ast.VariableNode, env []envItem) (*Constructor, *file.Error) {
+	var location int
+	if callee.Kind == ast.Lexical {
+		location = lookupEnv(callee.Name, env)
+	} else {
+		location = lookupStack(callee.Name, s.stack)
+	}
+	if location == -1 {
+		return nil, &file.Error{
+			Location: callee.GetLocation(),
+			Message:  "undefined variable",
+		}
+	}
+	constructor, ok := s.heap[location].(*Constructor)
+	if !ok || len(constructor.Fields) != len(p.ArgList) {
+		return nil, &file.Error{
+			Location: p.GetLocation(),
+			Message:  "pattern does not match the fields of a constructor",
+		}
+	}
+	return constructor, nil
+}
+
+// destructure matches value against a pattern destructuring a binding, appending
+// the values bound by the pattern to bindings like match. The pattern has to match:
+// a mismatch is an error located at the innermost pattern that does not match.
+func (s *state) destructure(pattern ast.ExprNode, value Value, env []envItem, bindings []matched) ([]matched, *file.Error) {
+	mismatch := func(sl file.SourceLocation, message string) ([]matched, *file.Error) {
+		return bindings, &file.Error{Location: sl, Message: message}
+	}
+	switch p := pattern.(type) {
+	case *ast.SequenceNode:
+		v, ok := value.(*List)
+		if !ok {
+			return mismatch(p.GetLocation(), "destructuring expects a list")
+		}
+		n, rest := len(p.ExprList), false
+		if n != 0 {
+			_, rest = p.ExprList[n-1].(*ast.RestNode)
+		}
+		if rest && len(v.Elems) < n-1 {
+			return mismatch(p.GetLocation(), fmt.Sprintf("destructuring expects a list of at least %d elements", n-1))
+		} else if !rest && len(v.Elems) != n {
+			return mismatch(p.GetLocation(), fmt.Sprintf("destructuring expects a list of %d elements", n))
+		}
+		for i, element := range p.ExprList {
+			if rest, ok := element.(*ast.RestNode); ok {
+				elems := make([]Value, len(v.Elems)-i)
+				copy(elems, v.Elems[i:])
+				return append(bindings, matched{name: rest.Name, value: NewList(elems)}), nil
+			}
+			var err *file.Error
+			if bindings, err = s.destructure(element, v.Elems[i], env, bindings); err != nil {
+				return bindings, err
+			}
+		}
+		return bindings, nil
+	case *ast.RecordNode:
+		for _, f := range p.Fields {
+			field, ok := s.field(value, f.Name.Name)
+			if !ok {
+				return mismatch(f.Name.GetLocation(), "destructuring expects a field "+f.Name.Name)
+			}
+			var err *file.Error
+			if bindings, err = s.destructure(f.Expr, field, env, bindings); err != nil {
+				return bindings, err
+			}
+		}
+		return bindings, nil
+	case *ast.CallNode:
+		if callee, ok := p.Callee.(*ast.VariableNode); ok {
+			constructor, err := s.constructor(p, callee, env)
+			if err != nil {
+				return bindings, err
+			}
+			v, ok := value.(*Data)
+			if !ok || v.Constructor != constructor {
+				return mismatch(p.GetLocation(), "destructuring expects data built by "+callee.Name)
+			}
+			for i, arg := range p.ArgList {
+				if bindings, err = s.destructure(arg, v.Values[i], env, bindings); err != nil {
+					return bindings, err
+				}
+			}
+			return bindings, nil
+		}
+	}
+	bindings, ok, err := s.match(pattern, value, env, bindings)
+	if err == nil && !ok {
+		return mismatch(pattern.GetLocation(), "destructuring does not match the value")
+	}
+	return bindings, err
+}
+
+// field returns a field of data or of a record, or a variable of the environment
+// of a closure.
+func (s *state) field(value Value, name string) (Value, bool) {
+	switch v := value.(type) {
+	case *Closure:
+		if location := lookupEnv(name, v.Env); location != -1 {
+			return s.heap[location], true
+		}
+	case *Data:
+		for i, f := range v.Constructor.Fields {
+			if f == name {
+				return v.Values[i], true
+			}
+		}
+	case *Record:
+		return v.get(name)
+	}
+	return nil, false
+}
+
+// bound returns the number of variables bound by a pattern of `match`.
+func bound(pattern ast.ExprNode) int {
+	n := 0
+	switch p := pattern.(type) {
+	case *ast.VariableNode:
+		if p.Name != "_" {
+			n++
+		}
+	case *ast.RestNode:
+		n++
+	case *ast.SequenceNode:
+		for _, element := range p.ExprList {
+			n += bound(element)
+		}
+	case *ast.RecordNode:
+		for _, f := range p.Fields {
+			n += bound(f.Expr)
+		}
+	case *ast.CallNode:
+		for _, arg := range p.ArgList {
+			n += bound(arg)
+		}
+	}
+	return n
+}
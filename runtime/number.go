@@ -0,0 +1,252 @@
+package runtime
+
+import (
+	"math"
+	"math/big"
+	"reflect"
+	"strings"
+
+	"github.com/gogim1/goscript/file"
+)
+
+// rational returns the number n/d in lowest terms with a positive denominator.
+func rational(n, d int) Value {
+	if d < 0 {
+		n, d = -n, -d
+	}
+	g := gcd(abs(n), d)
+	return retrieveNumberValue(n/g, d/g)
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// mulInt returns x*y, and false if it overflows.
+func mulInt(x, y int) (int, bool) {
+	if x == 0 || y == 0 {
+		return 0, true
+	}
+	z := x * y
+	if z/y != x || (x == -1 && y == math.MinInt) || (y == -1 && x == math.MinInt) {
+		return 0, false
+	}
+	return z, true
+}
+
+// powInt returns x raised to the power e by squaring, and false if it overflows.
+func powInt(x int, e uint) (int, bool) {
+	ret, ok := 1, true
+	for e > 0 {
+		if e&1 == 1 {
+			if ret, ok = mulInt(ret, x); !ok {
+				return 0, false
+			}
+		}
+		if e >>= 1; e > 0 {
+			if x, ok = mulInt(x, x); !ok {
+				return 0, false
+			}
+		}
+	}
+	return ret, true
+}
+
+// floorDiv returns the greatest integer less than or equal to n/d, d being positive.
+func floorDiv(n, d int) int {
+	q := n / d
+	if n%d != 0 && n < 0 {
+		q--
+	}
+	return q
+}
+
+// roundingModes are the ways a number is rounded to an integer:
+//   - "floor" rounds toward negative infinity
+//   - "ceil" rounds toward positive infinity
+//   - "trunc" rounds toward zero
+//   - "round" rounds to the nearest integer, half away from zero
+//   - "even" rounds to the nearest integer, half to even
+var roundingModes = []string{"floor", "ceil", "trunc", "round", "even"}
+
+func isRoundingMode(mode string) bool {
+	for _, m := range roundingModes {
+		if m == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// roundDiv rounds n/d to an integer, d being positive.
+func roundDiv(n, d int, mode string) int {
+	switch mode {
+	case "floor":
+		return floorDiv(n, d)
+	case "ceil":
+		q := n / d
+		if n%d != 0 && n > 0 {
+			q++
+		}
+		return q
+	case "trunc":
+		return n / d
+	}
+	// r is compared to d-r, as 2*r may overflow.
+	q, r := n/d, abs(n%d)
+	if r > d-r || (r == d-r && (mode == "round" || q%2 != 0)) {
+		if n < 0 {
+			q--
+		} else {
+			q++
+		}
+	}
+	return q
+}
+
+// maxPrecision bounds the decimal places printed by `todec`.
+const maxPrecision = 1000
+
+// formatDecimal prints n/d rounded to the given number of decimal places, d being
+// positive. It computes with big integers, as n/d scaled by the precision may not fit.
+func formatDecimal(n, d, precision int, mode string) string {
+	scaled := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(precision)), nil)
+	scaled.Mul(scaled, big.NewInt(int64(n)))
+	den := big.NewInt(int64(d))
+	// the euclidean division rounds toward negative infinity, as d is positive.
+	m, r := new(big.Int).DivMod(scaled, den, new(big.Int))
+	if r.Sign() != 0 {
+		half := new(big.Int).Lsh(r, 1).Cmp(den)
+		switch mode {
+		case "floor":
+		case "ceil":
+			m.Add(m, big.NewInt(1))
+		case "trunc":
+			if n < 0 {
+				m.Add(m, big.NewInt(1))
+			}
+		default:
+			if half > 0 || (half == 0 && ((mode == "round" && n > 0) || (mode == "even" && m.Bit(0) == 1))) {
+				m.Add(m, big.NewInt(1))
+			}
+		}
+	}
+
+	b := &strings.Builder{}
+	if m.Sign() < 0 {
+		b.WriteString("-")
+	}
+	digits := new(big.Int).Abs(m).String()
+	if len(digits) <= precision {
+		digits = strings.Repeat("0", precision-len(digits)+1) + digits
+	}
+	b.WriteString(digits[:len(digits)-precision])
+	if precision > 0 {
+		b.WriteString(".")
+		b.WriteString(digits[len(digits)-precision:])
+	}
+	return b.String()
+}
+
+// inexact returns n arguments as floats when they are numbers or floats and at least
+// one of them is a float, in which case the result of an arithmetic intrinsic is a float.
+func inexact(args []Value, n int) ([]float64, bool) {
+	if len(args) != n {
+		return nil, false
+	}
+	ret, float := []float64{}, false
+	for _, arg := range args {
+		switch v := arg.(type) {
+		case *Number:
+			ret = append(ret, float64(v.Numerator)/float64(v.Denominator))
+		case *Float:
+			ret = append(ret, v.Value)
+			float = true
+		default:
+			return nil, false
+		}
+	}
+	return ret, float
+}
+
+// compareFloat compares a number and a float by their exact values. NaN is ordered
+// before every number, as it is before every other float.
+func (v *Number) compareFloat(f float64) int {
+	switch {
+	case math.IsNaN(f), math.IsInf(f, -1):
+		return 1
+	case math.IsInf(f, 1):
+		return -1
+	}
+	return big.NewRat(int64(v.Numerator), int64(v.Denominator)).Cmp(new(big.Rat).SetFloat64(f))
+}
+
+// float returns the number as a float, and whether the float is its exact value.
+func (v *Number) float() (float64, bool) {
+	return big.NewRat(int64(v.Numerator), int64(v.Denominator)).Float64()
+}
+
+// toFloats converts n arguments, which are numbers or floats, to floats.
+func toFloats(sl file.SourceLocation, args []Value, n int) ([]float64, *file.Error) {
+	types := []reflect.Type{}
+	for i := 0; i < n; i++ {
+		types = append(types, NumberType)
+	}
+	if len(args) != n {
+		return nil, typeCheck(sl, args, types)
+	}
+	ret := []float64{}
+	for _, arg := range args {
+		switch v := arg.(type) {
+		case *Number:
+			ret = append(ret, float64(v.Numerator)/float64(v.Denominator))
+		case *Float:
+			ret = append(ret, v.Value)
+		default:
+			return nil, typeCheck(sl, args, types)
+		}
+	}
+	return ret, nil
+}
+
+// floorMod returns the remainder of the division of x by y rounded toward negative
+// infinity, which has the sign of y.
+func floorMod(x, y float64) float64 {
+	m := math.Mod(x, y)
+	if m != 0 && (m < 0) != (y < 0) {
+		m += y
+	}
+	return m
+}
+
+// exact returns the number exactly equal to a finite float, if representable.
+func exact(f float64) (Value, bool) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil, false
+	}
+	r := new(big.Rat).SetFloat64(f)
+	if !r.Num().IsInt64() || !r.Denom().IsInt64() {
+		return nil, false
+	}
+	return rational(int(r.Num().Int64()), int(r.Denom().Int64())), true
+}
+
+// mathFunctions are the intrinsics computing a float from numbers or floats.
+var mathFunctions = map[string]struct {
+	arity int
+	fn    func([]float64) float64
+}{
+	"sqrt":  {1, func(f []float64) float64 { return math.Sqrt(f[0]) }},
+	"exp":   {1, func(f []float64) float64 { return math.Exp(f[0]) }},
+	"log":   {1, func(f []float64) float64 { return math.Log(f[0]) }},
+	"sin":   {1, func(f []float64) float64 { return math.Sin(f[0]) }},
+	"cos":   {1, func(f []float64) float64 { return math.Cos(f[0]) }},
+	"tan":   {1, func(f []float64) float64 { return math.Tan(f[0]) }},
+	"asin":  {1, func(f []float64) float64 { return math.Asin(f[0]) }},
+	"acos":  {1, func(f []float64) float64 { return math.Acos(f[0]) }},
+	"atan":  {1, func(f []float64) float64 { return math.Atan(f[0]) }},
+	"atan2": {2, func(f []float64) float64 { return math.Atan2(f[0], f[1]) }},
+}
@@ -29,25 +29,52 @@ type layer struct {
 
 type state struct {
 	collector
-	config *conf.Config
-	value  Value
-	stack  []*layer
-	heap   []Value
-	ffi    map[string]func(...Value) Value
+	config   *conf.Config
+	value    Value
+	stack    []*layer
+	heap     []Value
+	ffi      map[string]Function
+	contexts []*Context
+	programs []ast.ExprNode
+	// sources keeps the source of the programs loaded by `evalin`, which are dropped
+	// by the collector once nothing refers to their nodes.
+	sources map[ast.ExprNode]string
+	// owners maps the nodes of the programs loaded by `evalin` to their program.
+	owners map[ast.ExprNode]ast.ExprNode
+	// suspended is set while an asynchronous FFI call is waiting for Resume.
+	suspended bool
+	// capabilities are only enforced once the trusted stdlib is loaded.
+	capabilities *conf.Capabilities
+	// blocking counts the synchronous calls into goscript that are in progress,
+	// during which execution cannot be suspended.
+	blocking int
+	// barriers are the layers stopping the synchronous calls of closures that are in
+	// progress, the innermost last.
+	barriers []*layer
 }
 
 func NewState(expr ast.ExprNode, config *conf.Config) *state {
+	s := newState(config)
+	s.load(expr)
+	return s
+}
+
+func newState(config *conf.Config) *state {
 	s := &state{
 		config: config,
 		stack: []*layer{
 			{env: new([]envItem), expr: nil, frame: true},
 		},
-		ffi: make(map[string]func(...Value) Value),
+		ffi:     make(map[string]Function),
+		sources: make(map[ast.ExprNode]string),
+		owners:  make(map[ast.ExprNode]ast.ExprNode),
 	}
 	s.collector.state = s
 	s.collector.values = make(map[int64]struct{})
 	s.collector.locations = make(map[int]struct{})
 	s.collector.relocation = make(map[int]int)
+	s.collector.retained = make(map[ast.ExprNode]struct{})
+	s.collector.finalizers = make(map[int64]*finalizer)
 
 	if config.UseStd {
 		for _, filepath := range stdlib.Paths {
@@ -67,11 +94,12 @@ func NewState(expr ast.ExprNode, config *conf.Config) *state {
 		}
 		s.gc()
 	}
-	s.load(expr)
+	s.capabilities = config.Capabilities
 	return s
 }
 
 func (s *state) load(expr ast.ExprNode) {
+	s.programs = append(s.programs, expr)
 	env := make([]envItem, len(*(s.stack[0].env)))
 	copy(env, *(s.stack[0].env))
 	s.stack = append(s.stack, &layer{env: &env, expr: expr, frame: true})
@@ -82,32 +110,225 @@ func (s *state) Value() Value {
 }
 
 func (s *state) Execute() *file.Error {
-	for {
-		l := s.stack[len(s.stack)-1]
-		if l.expr == nil {
-			break
+	if err := s.execute(); err != nil {
+		// the layers of the failed script are discarded as on Reject, unless a
+		// synchronous call unwinds them itself.
+		if s.blocking == 0 {
+			s.stack = s.stack[:1]
+			s.capabilities = s.sandbox()
 		}
+		return err
+	}
+	if s.config.EnableDebug {
+		printMemUsage()
+	}
+	return nil
+}
 
-		err := l.expr.Accept(s)
+// execute runs until the top layer has nothing left to evaluate.
+func (s *state) execute() *file.Error {
+	for {
+		done, err := s.Step()
 		if err != nil {
 			return err
 		}
-		if s.config.GCTrigger() {
-			n := s.gc()
-			if s.config.EnableDebug {
-				fmt.Printf("[DEBUG] GC collect %d cells\n", n)
+		if done {
+			return nil
+		}
+	}
+}
+
+// Step evaluates a single step and reports whether execution has finished or has
+// been suspended by an asynchronous FFI call.
+func (s *state) Step() (bool, *file.Error) {
+	if s.suspended {
+		return false, suspendedError()
+	}
+	l := s.stack[len(s.stack)-1]
+	if l.expr == nil {
+		return true, nil
+	}
+
+	if err := l.expr.Accept(s); err != nil && !s.catch(err) {
+		return false, err
+	}
+	if s.config.GCTrigger() {
+		n := s.gc()
+		if s.config.EnableDebug {
+			fmt.Printf("[DEBUG] GC collect %d cells\n", n)
+		}
+		// finalizers cannot run while execution waits for Resume, they are left
+		// pending until the next collection.
+		if !s.suspended {
+			if err := s.finalize(); err != nil {
+				return false, err
 			}
 		}
 	}
-	if s.config.EnableDebug {
-		printMemUsage()
+	return s.suspended || s.stack[len(s.stack)-1].expr == nil, nil
+}
+
+// catch unwinds the stack to the innermost `evalin` that receives errors as values.
+// It never unwinds past the barrier of a synchronous call. The failing layer itself
+// is skipped, so that errors in the arguments of `evalin` are not caught.
+func (s *state) catch(err *file.Error) bool {
+	for i := len(s.stack) - 2; i > 0; i-- {
+		l := s.stack[i]
+		if l.expr == nil {
+			return false
+		}
+		if s.isCatching(l) {
+			s.stack = s.stack[:i+1]
+			s.value = NewError(err)
+			return true
+		}
+	}
+	return false
+}
+
+func (s *state) Suspended() bool {
+	return s.suspended
+}
+
+// Resume continues a suspended execution, using value as the result of the
+// suspended FFI call.
+func (s *state) Resume(value Value) *file.Error {
+	if err := s.resume(value); err != nil {
+		return err
+	}
+	return s.Execute()
+}
+
+func (s *state) resume(value Value) *file.Error {
+	if !s.suspended {
+		return notSuspendedError()
+	}
+	if value == nil {
+		value = voidValue
+	}
+	s.suspended = false
+	s.value = value
+	s.stack = s.stack[:len(s.stack)-1]
+	return nil
+}
+
+// Reject fails a suspended FFI call, aborting the script at the location of the call.
+// The layers of the aborted script are discarded, so the interpreter can run other
+// programs and calls afterwards.
+func (s *state) Reject(message string) *file.Error {
+	if !s.suspended {
+		return notSuspendedError()
+	}
+	err := &file.Error{
+		Location: s.stack[len(s.stack)-1].expr.GetLocation(),
+		Message:  message,
+	}
+	s.suspended = false
+	s.value = voidValue
+	// execution cannot be suspended inside a synchronous call, so only the root
+	// layer remains below the script.
+	s.stack = s.stack[:1]
+	s.capabilities = s.sandbox()
+	return err
+}
+
+func suspendedError() *file.Error {
+	return &file.Error{
+		Location: file.SourceLocation{Line: -1, Col: -1},
+		Message:  "execution is suspended",
+	}
+}
+
+func notSuspendedError() *file.Error {
+	return &file.Error{
+		Location: file.SourceLocation{Line: -1, Col: -1},
+		Message:  "execution is not suspended",
+	}
+}
+
+// SetGlobal defines a binding in the global environment. An existing binding is
+// overwritten in place, so closures and programs that captured it observe the new
+// value. New lexical bindings are only visible to programs loaded afterwards, while
+// dynamic (capitalized) bindings are visible to every program.
+func (s *state) SetGlobal(name string, value Value) *file.Error {
+	if !isIdentifier(name) {
+		return &file.Error{
+			Location: file.SourceLocation{Line: -1, Col: -1},
+			Message:  "incorrect variable name",
+		}
+	}
+	env := s.stack[0].env
+	if location := lookupEnv(name, *env); location != -1 {
+		s.heap[location] = value
+	} else {
+		*env = append(*env, envItem{
+			name:     name,
+			location: s.new(value),
+		})
 	}
 	return nil
 }
 
+func (s *state) GetGlobal(name string) (Value, bool) {
+	location := lookupEnv(name, *s.stack[0].env)
+	if location == -1 {
+		return nil, false
+	}
+	return s.heap[location], true
+}
+
+// Globals returns every binding of the global environment.
+func (s *state) Globals() map[string]Value {
+	globals := make(map[string]Value)
+	for _, item := range *s.stack[0].env {
+		globals[item.name] = s.heap[item.location]
+	}
+	return globals
+}
+
+// invoke synchronously applies a closure on top of the current stack.
+func (s *state) invoke(closure *Closure, args []Value) (Value, *file.Error) {
+	sl := file.SourceLocation{Line: -1, Col: -1}
+	if err := checkArity(sl, closure.Fun, len(args)); err != nil {
+		return nil, err
+	}
+
+	// the layer without expression stops `execute` once the callee returns.
+	s.blocking++
+	depth := len(s.stack)
+	barrier := &layer{env: new([]envItem)}
+	s.barriers = append(s.barriers, barrier)
+	defer func() {
+		s.blocking--
+		s.barriers = s.barriers[:len(s.barriers)-1]
+	}()
+	s.stack = append(s.stack, barrier)
+	s.call(barrier, closure, args)
+	if err := s.execute(); err != nil {
+		s.stack = s.stack[:depth]
+		s.capabilities = s.sandbox()
+		return nil, err
+	}
+	s.stack = s.stack[:depth]
+	return s.value, nil
+}
+
 func (s *state) Call(name string, args ...any) (Value, *file.Error) {
+	return s.CallDynamic(name, nil, args...)
+}
+
+// CallDynamic calls a goscript function with extra dynamic (capitalized) bindings,
+// which are visible to the callee and everything it calls until it returns.
+func (s *state) CallDynamic(name string, bindings map[string]Value, args ...any) (Value, *file.Error) {
+	if s.suspended {
+		return nil, suspendedError()
+	}
 	sl := file.SourceLocation{Line: -1, Col: -1}
-	callee := ast.NewVariableNode(sl, name, ast.Unknown) // TODO: scope
+	kind := ast.Lexical
+	if !isLexical(name) {
+		kind = ast.Dynamic
+	}
+	callee := ast.NewVariableNode(sl, name, kind)
 	argList := []ast.ExprNode{}
 	for _, arg := range args {
 		switch v := arg.(type) {
@@ -115,16 +336,37 @@ func (s *state) Call(name string, args ...any) (Value, *file.Error) {
 			argList = append(argList, ast.NewStringNode(sl, v))
 		case int:
 			argList = append(argList, ast.NewNumberNode(sl, v, 1))
+		case float64:
+			argList = append(argList, ast.NewFloatNode(sl, v))
+		case bool:
+			argList = append(argList, ast.NewBoolNode(sl, v))
 		default:
 			return nil, &file.Error{
 				Location: sl,
-				Message:  "golang can only use string/int as arguments when calling goscript functions",
+				Message:  "golang can only use string/int/float64/bool as arguments when calling goscript functions",
 			}
 		}
 	}
 	env := make([]envItem, len(*(s.stack[0].env)))
 	copy(env, *(s.stack[0].env))
+	for name, value := range bindings {
+		if !isIdentifier(name) || isLexical(name) {
+			return nil, &file.Error{
+				Location: sl,
+				Message:  "only dynamic variables can be bound when calling goscript functions",
+			}
+		}
+		env = append(env, envItem{
+			name:     name,
+			location: s.new(value),
+		})
+	}
 
+	s.blocking++
+	defer func() {
+		s.blocking--
+	}()
+	depth := len(s.stack)
 	s.stack = append(s.stack, &layer{
 		env:   &env,
 		frame: true,
@@ -132,12 +374,9 @@ func (s *state) Call(name string, args ...any) (Value, *file.Error) {
 	})
 
 	if err := s.Execute(); err != nil {
+		s.stack = s.stack[:depth]
+		s.capabilities = s.sandbox()
 		return nil, err
 	}
 	return s.value, nil
 }
-
-func (s *state) Register(name string, fun func(...Value) Value) *state {
-	s.ffi[name] = fun
-	return s
-}
@@ -1,12 +1,15 @@
 package runtime_test
 
 import (
+	"os"
+	"strings"
 	"testing"
 
 	"github.com/gogim1/goscript/ast"
 	"github.com/gogim1/goscript/conf"
 	"github.com/gogim1/goscript/file"
 	"github.com/gogim1/goscript/lexer"
+	"github.com/gogim1/goscript/macro"
 	"github.com/gogim1/goscript/parser"
 	"github.com/gogim1/goscript/runtime"
 	. "github.com/gogim1/goscript/runtime"
@@ -21,6 +24,9 @@ func lexAndParse(t *testing.T, src string) ast.ExprNode {
 	node, err := parser.Parse(tokens)
 	require.Nil(t, err)
 
+	node, err = macro.Expand(node)
+	require.Nil(t, err)
+
 	return node
 }
 
@@ -31,8 +37,8 @@ func TestRuntime(t *testing.T) {
 		{`10/5`, `2`},
 		{`"hello world"`, `hello world`},
 		{`[1/1 "2" 3]`, `3`},
-		{`if 1 then 2 else 3`, `2`},
-		{`if 0 then 2 else 3`, `3`},
+		{`if true then 2 else 3`, `2`},
+		{`if false then 2 else 3`, `3`},
 		{`letrec () {1.1}`, `11/10`},
 		{`letrec (a=1 b=2) {"hello world"}`, `hello world`},
 		{`letrec (a=1 b=2) {a}`, `1`},
@@ -54,6 +60,167 @@ func TestRuntime(t *testing.T) {
 		{`(callcc lambda (k) { [(k 1) 2] })`, `1`},
 		{`&v letrec (v=1) {lambda () { 1 }}`, `1`},
 		{`&v (lambda (v) { lambda () { 0 } } 1)`, `1`},
+		{`macro (first (a b) { letrec (tmp = b) { a } }) { letrec (tmp = 1) { (first tmp 2) } }`, `1`},
+		{`macro (unless (c @body) { if c then (void) else [@body] }) { (unless false 1 2) }`, `2`},
+		{`letrec (x = 5) { macro (m () { x }) { let (x = 1) { (m) } } }`, `5`},
+		{`letrec (recur = lambda (v) { v }) { macro (m (v) { (recur v) }) { loop (i = 0) { if (lt i 3) then (recur (add i 1)) else (m i) } } }`, `3`},
+		{`(eval "macro (twice (x) { (add x x) }) { (twice 21) }")`, `42`},
+		{"(eval `macro (twice (x) { (add x x) }) { (twice 21) })", `42`},
+		{`match 2/4 { 1 then "one" 0.5 then "half" _ then "other" }`, `half`},
+		{`match "b" { "a" then 1 s then s }`, `b`},
+		{`match (void) { 0 then 1 (void) then 2 }`, `2`},
+		{`match (mklist 1 2 3) { [] then 0 [a] then a [a b @rest] then (add (add a b) (listlen rest)) }`, `4`},
+		{`match (mklist) { [@rest] then rest }`, `[]`},
+		{`match (mklist 1 (mklist 2 "c")) { [1 [x s]] then s }`, `c`},
+		{`match letrec (x = 1 y = 2) { lambda () { x } } { {z} then z {x y = 3} then 3 {x = n y} then (add n y) }`, `3`},
+		{`match (inexact 1/2) { 1/2 then "exact" 0.5e0 then "float" _ then "other" }`, `float`},
+		{`match 1/2 { 5e-1 then "float" _ then "other" }`, `other`},
+		{"(eval `(add ,(sqrt 4) 1))", `3e0`},
+		{"(codekind `1e3)", `float`},
+		{"(codekind `true)", `bool`},
+		{"(codeval `false)", `false`},
+		{"(eval `(not ,(mkcode \"bool\" true)))", `false`},
+		{`match (eq 1 1) { false then 0 true then 1 }`, `1`},
+		{`macro (m (true) { "yes" } m (x) { "no" }) { [(m false) (m true)] }`, `yes`},
+		{"(codeval (mkcode \"float\" 1.5e3))", `1500e0`},
+		{`match 5 { n if (lt n 0) then "negative" n if (eq n 0) then "zero" n then n }`, `5`},
+		{`letrec (n = 1) { [match 2 { n if (lt n 0) then n m then m } n] }`, `1`},
+		{`letrec (f = lambda () { Dyn }) { match 7 { Dyn then (f) } }`, `7`},
+		{`data maybe (just (value) nothing ()) { (just 5) }`, `(just 5)`},
+		{`data maybe (just (value) nothing ()) { (nothing) }`, `(nothing)`},
+		{`data maybe (just (value) nothing ()) { just }`, `<constructor just>`},
+		{`data maybe (just (value) nothing ()) { ismaybe }`, `<predicate ismaybe>`},
+		{`data maybe (just (value) nothing ()) { [(isjust (just 1)) (isnothing (just 1)) (ismaybe (nothing)) (ismaybe 1)] }`, `false`},
+		{`data maybe (just (value) nothing ()) { (and (isjust (just 1)) (ismaybe (nothing))) }`, `true`},
+		{`data pair (cons (first second)) { &second (cons 1 "b") }`, `b`},
+		{`data tree (node (left right) leaf ()) { (eq (node (leaf) (node (leaf) (leaf))) (node (leaf) (node (leaf) (leaf)))) }`, `true`},
+		{`data tree (node (left right) leaf ()) { (ne (node (leaf) (leaf)) (node (leaf) (node (leaf) (leaf)))) }`, `true`},
+		{`[data t (a ()) { letrec (x = (a)) { data t (a ()) { (eq x (a)) } } }]`, `false`},
+		{`data shape (circle (r) rect (w h)) { match (rect 2 3) { (circle r) then r (rect w h) then (mul w h) } }`, `6`},
+		{`data maybe (just (value) nothing ()) { match (mklist (just 1) (nothing)) { [(just x) (nothing)] then x _ then 0 } }`, `1`},
+		{`data maybe (just (value) nothing ()) { match (just (just 2)) { (just (just x)) if (gt x 1) then x _ then 0 } }`, `2`},
+		{`data pair (cons (first second)) { match (cons 1 2) { {second} then second } }`, `2`},
+		{`data maybe (just (value) nothing ()) { match (nothing) { (just _) then 1 (void) then 2 _ then 3 } }`, `3`},
+		{`{a = 1 b = "s"}`, `{a = 1 b = s}`},
+		{`{}`, `{}`},
+		{`letrec (a = 1) { {a b = (add a 1)} }`, `{a = 1 b = 2}`},
+		{`&b {a = 1 b = 2}`, `2`},
+		{`letrec (r = {a = 1 b = 2}) { [{r | b = 3} r] }`, `{a = 1 b = 2}`},
+		{`letrec (r = {a = 1 b = 2}) { {r | b = 3 a = 4} }`, `{a = 4 b = 3}`},
+		{`{{a = 1} |}`, `{a = 1}`},
+		{`(recfields {a = 1 b = 2})`, `[a b]`},
+		{`(recget {a = 1 b = 2} "b")`, `2`},
+		{`[(isrec {}) (isrec lambda () { 1 })]`, `false`},
+		{`(isrec {a = 1})`, `true`},
+		{`cond { false then 1 (eq 1 1) then 2 else 3 }`, `2`},
+		{`cond { false then 1 else 3 }`, `3`},
+		{`cond { else "s" }`, `s`},
+		{`letrec (l = (mklist)) { cond { (and (gt (listlen l) 0) (eq (listget l 0) 1)) then 1 else 0 } }`, `0`},
+		{`letrec (n = 1) { cond { (eq n 1) then cond { (eq n 2) then 2 else 1 } } }`, `1`},
+		{`macro (m () { true }) { cond { (m) then (m) } }`, `true`},
+		{`(eq {a = 1 b = {c = "s"}} {b = {c = "s"} a = 1})`, `true`},
+		{`(eq {a = 1} {a = 1 b = 2})`, `false`},
+		{`(ne {a = 1} {a = 2})`, `true`},
+		{`[(eq 1 "1") (eq "1" (void)) (eq {a = 1} 1) (ne 1 "2")]`, `true`},
+		{`data maybe (just (value)) { (eq (just 1) 1) }`, `false`},
+		{`(eq (void) (void))`, `true`},
+		{`(eq 2/4 1/2)`, `true`},
+		{`(eq (mklist 1 (mklist "a")) (mklist 1 (mklist "a")))`, `true`},
+		{`(eq (mklist 1 2) (mklist 1))`, `false`},
+		{`letrec (f = lambda () { 1 }) { (eq f f) }`, `true`},
+		{`(eq lambda () { 1 } lambda () { 1 })`, `false`},
+		{`(callcc lambda (k) { (eq k k) })`, `true`},
+		{"(eq `(add 1 ,(add 1 1)) `(add 1 2))", `true`},
+		{"(eq `(add 1 2) `(add 1 3))", `false`},
+		{`(eq (evalin "(div 1 0)" lambda () { 0 } true) (evalin "(div 1 0)" lambda () { 0 } true))`, `true`},
+		{`match {a = 1 b = 2} { {c} then c {a b = 2} then a }`, `1`},
+		{`macro (get (r f) { &f r } mk (f v) { {f = v} }) { (get (mk x 5) x) }`, `5`},
+		{`macro (m (v) { data t (box (x)) { (isbox (box v)) } }) { data t (box (x)) { letrec (box = 1) { (m box) } } }`, `true`},
+		{`letrec (
+			counter = letrec (n = (ref 0)) { lambda () { [(setref n (add (deref n) 1)) (deref n)] } }
+		) {
+			[(counter) (counter) (counter)]
+		}`, `3`},
+		{`letrec (
+			r = (ref 0)
+			k = (callcc lambda (k) { k })
+		) {[
+			(setref r (add (deref r) 1))
+			if (iscont k) then (k 1) else (deref r)
+		]}`, `2`},
+		{`letrec (
+			k = (callcc lambda (k) { k })
+			r = (ref 0)
+		) {[
+			(setref r (add (deref r) 1))
+			if (iscont k) then (k 1) else (deref r)
+		]}`, `1`},
+		{`letrec (list = lambda (@l) { l }) { (list 1 "a" (list)) }`, `[1 a []]`},
+		{`letrec (f = lambda (a b = (add a 1)) { (mul a b) }) { (mklist (f 2) (f 2 5)) }`, `[6 10]`},
+		{`letrec (f = lambda (a b = 2 @r) { (mklist a b r) }) { (mklist (f 1) (f 1 3) (f 1 3 4 5)) }`, `[[1 2 []] [1 3 []] [1 3 [4 5]]]`},
+		{`letrec (
+			n = (ref 0)
+			f = lambda (x = [(setref n (add (deref n) 1)) (deref n)]) { x }
+		) {
+			(mklist (f) (f 5) (f))
+		}`, `[1 5 2]`},
+		{`letrec (f = lambda (X = 1) { (g) } g = lambda () { X }) { (mklist (f) (f 2)) }`, `[1 2]`},
+		{`letrec (f = lambda (a b = lambda () { a }) { (b) }) { (f 42) }`, `42`},
+		{`letrec (
+			largest = lambda (x @r) {
+				match r { [] then x [y @t] then (apply largest if (gt x y) then x else y t) }
+			}
+		) {
+			(largest 3 9 2)
+		}`, `9`},
+		{`(apply lambda (a b) { (sub a b) } (mklist 5 3))`, `2`},
+		{`(apply lambda (a b) { (sub a b) } 5 (mklist 3))`, `2`},
+		{`(apply lambda (@r) { r } (mklist))`, `[]`},
+		{`(apply lambda (a b = 2) { (add a b) } (mklist 1))`, `3`},
+		{`data pair (mkpair (a b)) { &b (apply mkpair (mklist 1 2)) }`, `2`},
+		{`data pair (mkpair (a b)) { (apply ispair (mklist (mkpair 1 2))) }`, `true`},
+		{`(add 1 (callcc lambda (k) { (apply k (mklist 2)) }))`, `3`},
+		{`(callcc lambda (k @r) { (listlen r) })`, `0`},
+		{"((eval `lambda (a = ,(add 1 1)) { a }))", `2`},
+		{`letrec ([a b] = (mklist 1 2)) { (add a b) }`, `3`},
+		{`letrec ([x @r] = (mklist 1 2 3) n = (listlen r)) { (mklist x r n) }`, `[1 [2 3] 2]`},
+		{`letrec ({x y = [a _]} = {x = 1 y = (mklist 2 3)}) { (add x a) }`, `3`},
+		{`data pair (mkpair (a b)) { letrec ((mkpair x y) = (mkpair 1 2)) { (sub x y) } }`, `-1`},
+		{`letrec ({n} = letrec (n = 5) { lambda () { n } }) { n }`, `5`},
+		{`letrec (_ = 1 x = 2) { x }`, `2`},
+		{`letrec (
+			[even odd] = (mklist
+				lambda (n) { if (eq n 0) then true else (odd (sub n 1)) }
+				lambda (n) { if (eq n 0) then false else (even (sub n 1)) })
+		) {
+			(even 10)
+		}`, `true`},
+		{`(lambda ([a b] {c}) { (mklist a b c) } (mklist 1 2) {c = 3})`, `[1 2 3]`},
+		{`letrec (f = lambda (x [a b] = (mklist x 2)) { (add a b) }) { (mklist (f 1) (f 1 (mklist 5 5))) }`, `[3 10]`},
+		{`data pair (mkpair (a b)) { letrec (swap = lambda ((mkpair x y)) { (mkpair y x) }) { &a (swap (mkpair 1 2)) } }`, `2`},
+		{`(apply lambda ([a b] @r) { (mklist b a r) } (mklist (mklist 1 2) 3))`, `[2 1 [3]]`},
+		{`let (x = 1 y = 2) { (add x y) }`, `3`},
+		{`let (x = 1) { let (x = (add x 1) y = x) { (mklist x y) } }`, `[2 1]`},
+		{`let (x = 1) { let* (x = (add x 1) y = x) { (mklist x y) } }`, `[2 2]`},
+		{`let* (x = 1 x = (add x 1)) { x }`, `2`},
+		{`let (f = lambda () { 1 }) { let (f = lambda () { (add (f) 1) }) { (f) } }`, `2`},
+		{`let () { 1 }`, `1`},
+		{`let* ([a @r] = (mklist 1 2 3) {b} = {b = (listlen r)}) { (add a b) }`, `3`},
+		{`let (X = 1) { letrec (f = lambda () { X }) { let (X = 2) { (f) } } }`, `2`},
+		{`[let (x = 1) { x } letrec (x = 2) { x }]`, `2`},
+		{`let (x = 1) { [let (x = 2) { x } x] }`, `1`},
+		{`loop (i = 0 acc = 0) { if (gt i 10) then acc else (recur (add i 1) (add acc i)) }`, `55`},
+		{`loop () { 1 }`, `1`},
+		{`let (n = 5) { loop (n = (add n 1) acc = 1) { if (eq n 0) then acc else (recur (sub n 1) (mul acc n)) } }`, `720`},
+		{`loop outer (i = 0 acc = 0) {
+			if (eq i 2) then acc else loop inner (j = 0 acc = acc) {
+				if (eq j 2) then (outer (add i 1) acc) else (inner (add j 1) (add acc (add (mul i 10) j)))
+			}
+		}`, `22`},
+		{`loop ([a b] = (mklist 1 2) {c} = {c = 3}) { (mklist a b c) }`, `[1 2 3]`},
+		{`loop (x = lambda () { 1 }) { if (isclo x) then (recur (x)) else x }`, `1`},
+		{`let (recur = 1) { loop (x = recur) { x } }`, `1`},
+		{`loop (n = 3) { if (eq n 0) then 0 else (add 1 (recur (sub n 1))) }`, `3`},
 	}
 	for _, test := range tests {
 		t.Run(test.input, func(t *testing.T) {
@@ -64,6 +231,40 @@ func TestRuntime(t *testing.T) {
 	}
 }
 
+func TestRuntime_numericConditions(t *testing.T) {
+	tests := []struct {
+		input, value string
+	}{
+		{`if 1 then 2 else 3`, `2`},
+		{`if 0 then 2 else 3`, `3`},
+		{`if true then 2 else 3`, `2`},
+		{`cond { 0 then 1 -1/2 then 2 }`, `2`},
+		{`match 1 { n if 0 then 0 n if n then n }`, `1`},
+		{`(and 2 3)`, `3`},
+		{`(and 1 false 3)`, `false`},
+		{`(or 0 2)`, `2`},
+		{`(not 2)`, `false`},
+		{`(not 0)`, `true`},
+		{`(lt 1 2)`, `true`},
+		{`(iserr (evalin "(div 1 0)" lambda () { 0 } 1))`, `true`},
+		{`(eval "if 1 then 2 else 3")`, `2`},
+	}
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			state := NewState(lexAndParse(t, test.input), conf.New(conf.EnableNumericConditions(true)))
+			assert.Nil(t, state.Execute())
+			assert.Equal(t, test.value, state.Value().String())
+		})
+	}
+
+	for _, test := range []string{`if "1" then 2 else 3`, `(and (void) 1)`, `(not 1e0)`} {
+		t.Run(test, func(t *testing.T) {
+			state := NewState(lexAndParse(t, test), conf.New(conf.EnableNumericConditions(true)))
+			assert.NotNil(t, state.Execute())
+		})
+	}
+}
+
 func TestRuntime_error(t *testing.T) {
 	tests := []string{
 		`if "true" then 2 else 3`,
@@ -82,14 +283,12 @@ func TestRuntime_error(t *testing.T) {
 		`(mul 1 "2")`,
 		`(div 1 "2")`,
 		`(div 1 0)`,
-		`(lt "1" "2")`,
-		`(gt "1" "2")`,
-		`(le "1" "2")`,
-		`(ge "1" "2")`,
-		`(eq 1 "2")`,
-		`(eq "1" (void))`,
-		`(ne 1 "2")`,
-		`(ne "1" (void))`,
+		`(lt "1" 2)`,
+		`(gt 1 "2")`,
+		`(le "1" (mklist))`,
+		`(ge (mklist) "2")`,
+		`(eq 1)`,
+		`(ne 1 2 3)`,
 		`(and "1" "1")`,
 		`(or "1" "1")`,
 		`(not "1")`,
@@ -97,14 +296,158 @@ func TestRuntime_error(t *testing.T) {
 		`(getline "1")`,
 		`(put)`,
 		`(eval 1)`,
+		`(evalin 1 lambda () { 0 })`,
+		`(evalin "1" 1)`,
+		`(evalin "1" lambda () { 0 } "1")`,
+		`(evalin "(div 1 0)" lambda () { 0 } 1)`,
+		`(evalin "1" lambda () { 0 } true true)`,
+		`(evalin "(add 1" lambda () { 0 })`,
+		`(evalin "(div 1 0)" lambda () { 0 } false)`,
+		`(iserr)`,
+		`(errmsg 1)`,
+		"`(a ,lambda () { 1 })",
+		"`[,@1]",
+		"`[,@(void)]",
+		"`(a ,(mkcode \"intrinsic\" \"add\"))",
+		"(evalin `,(void) lambda () { 0 })",
+		`(mkcode 1)`,
+		`(mkcode "unquote")`,
+		`(mkcode "number" "1")`,
+		`(mkcode "variable" "lambda")`,
+		`(mkcode "variable" "add")`,
+		`(mkcode "variable" "a b")`,
+		`(mkcode "intrinsic" "a")`,
+		"(mkcode \"if\" `1 `2)",
+		"(mkcode \"sequence\")",
+		"(mkcode \"access\" (mkcode \"variable\" \"X\") `1)",
+		"(codeget `(a) 1)",
+		"(codeget `(a) 1/2)",
+		"(codeval `(a))",
+		`(codekind 1)`,
+		"(eval (codeget `(a ,(mkcode \"variable\" \"b\")) 1))",
+		"macro (twice (x) { (add x x) }) { (eval `(twice 21)) }",
+		`match 1 { 2 then 2 "1" then 1 }`,
+		`match 1 { n if "yes" then n }`,
+		`match (mklist 1) { [a b] then a [] then 0 }`,
+		`(listget (mklist 1) 1)`,
+		`(listlen 1)`,
+		`(eval "macro (m (a) { a }) { (m) }")`,
+		"(evalin `macro (m (a) { a }) { (m) } lambda () { 0 })",
 		`(callcc 1)`,
 		`(reg "func" 1)`,
 		`(go 1)`,
 		`(concat 1 2)`,
+		`(concat "a" 2)`,
+		`(isfloat)`,
+		`(add 1e0 "1")`,
+		`(lt 1e0 "1")`,
+		`(exact (div 1e0 0))`,
+		`(exact (sqrt -1))`,
+		`(exact 1e300)`,
+		`(exact "1")`,
+		`(inexact "1")`,
+		`(sqrt "4")`,
+		`(atan2 1)`,
+		`(log 1 2)`,
+		`(numerator 1e0)`,
+		`(todec 1e0 2)`,
+		"`(add ,(sqrt -1) 1)",
+		`(mkcode "float" 1)`,
+		`(floor "1")`,
+		`(round)`,
+		`(quot 1 0)`,
+		`(mod 1 "2")`,
+		`(pow 2 1/2)`,
+		`(pow 0 -1)`,
+		`(pow 2 100)`,
+		`(pow 10 19)`,
+		`(pow -2 -63)`,
+		`(pow 3 10000000000)`,
+		`(abs 1 2)`,
+		`(min 1)`,
+		`(max 1 "2")`,
+		`(numerator "1")`,
+		`(todec 1/3)`,
+		`(todec 1/3 -1)`,
+		`(todec 1/3 1/2)`,
+		`(todec 1/3 1001)`,
+		`(todec 1/3 2 "up")`,
+		`(todec "1" 2)`,
+		`(strlen 1)`,
+		`(substr "abc" 2 1)`,
+		`(substr "abc" -1 1)`,
+		`(substr "abc" 0 4)`,
+		`(substr "abc" 1/2 1)`,
+		`(substr "abc" 1)`,
+		`(index "abc")`,
+		`(split "abc" 1)`,
+		`(join (mklist "a" 1) ",")`,
+		`(join "abc" ",")`,
+		`(upper 1)`,
+		`(lower)`,
+		`(trim 1)`,
+		`(replace "a" "b")`,
+		`(tonum 1)`,
+		`(tostr)`,
+		`(lt "a" 1)`,
+		`(ge 1 "a")`,
 		`&v lambda () { 1 }`,
 		`&v "string"`,
 		`[(reg "c" lambda () {1}) (c)]`,
+		`data maybe (just (value) nothing ()) { (just) }`,
+		`data maybe (just (value) nothing ()) { (nothing 1) }`,
+		`data maybe (just (value) nothing ()) { (ismaybe) }`,
+		`data maybe (just (value) nothing ()) { &other (just 1) }`,
+		`data maybe (just (value) nothing ()) { match (just 1) { (just a b) then a } }`,
+		`letrec (f = lambda (x) { x }) { match 1 { (f x) then x } }`,
+		`match 1 { (g x) then x }`,
+		`&c {a = 1}`,
+		`{1 | a = 1}`,
+		`{{a = 1} | b = 2}`,
+		`(recget {a = 1} "b")`,
+		`(recget {a = 1} 1)`,
+		`(recfields 1)`,
+		`(and "1" 1)`,
+		`(or false lambda () { 1 } true)`,
+		`cond { false then 1 }`,
+		`cond { "1" then 1 else 2 }`,
+		`cond { false then 1 (void) then 2 else 3 }`,
+		`if 1 then 2 else 3`,
+		`cond { 1 then 2 }`,
+		`(and 1 true)`,
+		`(or 0 true)`,
+		`(not 0)`,
 		`(lambda () {[(reg "c" lambda () {1}) (c)]})`,
+		`(ref)`,
+		`(deref 1)`,
+		`(setref 1 2)`,
+		`(setref (ref 1))`,
+		`letrec (f = lambda (a b = 1) { a }) { (f) }`,
+		`letrec (f = lambda (a b = 1) { a }) { (f 1 2 3) }`,
+		`letrec (f = lambda (a = (div 1 0)) { a }) { (f) }`,
+		`(apply 1 (mklist))`,
+		`(apply lambda (a) { a } 1)`,
+		`(apply lambda (a) { a })`,
+		`(apply lambda (a) { a } (mklist 1 2))`,
+		`(callcc lambda () { 1 })`,
+		`letrec ([a b] = (mklist 1)) { a }`,
+		`letrec ([a @b] = (mklist)) { a }`,
+		`letrec ([a] = 1) { a }`,
+		`letrec ([1 a] = (mklist 2 3)) { a }`,
+		`letrec ({a} = {b = 1}) { a }`,
+		`letrec ((mkpair x y) = 1) { x }`,
+		`data pair (mkpair (a b)) { letrec ((mkpair x y) = 1) { x } }`,
+		`(lambda ([a]) { a } 1)`,
+		`letrec (f = lambda ([a] = 1) { a }) { (f) }`,
+		`let (x = 1 y = x) { y }`,
+		`let (f = lambda () { (f) }) { (f) }`,
+		`let (x = 1) { [let (y = 2) { x } y] }`,
+		`let ([a] = 1) { a }`,
+		`let* (a = b b = 1) { a }`,
+		`loop (i = recur) { i }`,
+		`loop (i = 0) { (recur) }`,
+		`loop (i = 0) { (recur 1 2) }`,
+		`loop ([a] = 1) { a }`,
 	}
 	for _, test := range tests {
 		t.Run(test, func(t *testing.T) {
@@ -117,6 +460,31 @@ func TestRuntime_error(t *testing.T) {
 	}
 }
 
+func TestRuntime_destructuringError(t *testing.T) {
+	tests := []struct {
+		input, message string
+		col            int
+	}{
+		{`letrec ([a [b c]] = (mklist 1 (mklist 2))) { a }`, `destructuring expects a list of 2 elements`, 12},
+		{`letrec ([a @b] = 1) { a }`, `destructuring expects a list`, 9},
+		{`(lambda ([a b @r]) { a } (mklist 1))`, `destructuring expects a list of at least 2 elements`, 10},
+		{`letrec ({a b} = {a = 1}) { a }`, `destructuring expects a field b`, 12},
+		{`data pair (mkpair (a b)) { (lambda ((mkpair x y)) { x } 1) }`, `destructuring expects data built by mkpair`, 37},
+		{`letrec ([x "a"] = (mklist 1 "b")) { x }`, `destructuring does not match the value`, 12},
+		{`let* (x = 1 [y] = x) { y }`, `destructuring expects a list`, 13},
+		{`loop (i = 0 [a b] = (mklist 1)) { a }`, `destructuring expects a list of 2 elements`, 13},
+	}
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			state := NewState(lexAndParse(t, test.input), conf.New())
+			err := state.Execute()
+			require.NotNil(t, err)
+			assert.Equal(t, test.message, err.Message)
+			assert.Equal(t, file.SourceLocation{Line: 1, Col: test.col}, err.Location)
+		})
+	}
+}
+
 func TestRuntimeInteraction(t *testing.T) {
 	t.Run("call script function", func(t *testing.T) {
 		src := `
@@ -144,6 +512,10 @@ func TestRuntimeInteraction(t *testing.T) {
 		assert.Nil(t, err)
 		assert.True(t, v != nil && v.String() == `42`)
 
+		v, err = state.Call("test2", 0.5)
+		assert.Nil(t, err)
+		assert.True(t, v != nil && v.String() == `1.5e0`)
+
 		v, err = state.Call("test0", 1)
 		assert.NotNil(t, err)
 		assert.Nil(t, v)
@@ -157,6 +529,107 @@ func TestRuntimeInteraction(t *testing.T) {
 		assert.Nil(t, v)
 	})
 
+	t.Run("call variadic script function", func(t *testing.T) {
+		src := `(reg "f" lambda (a b = (add a 1) @r) { (mklist a b (listlen r)) })`
+		state := runtime.NewState(lexAndParse(t, src), conf.New())
+		require.Nil(t, state.Execute())
+
+		v, err := state.Call("f", 1)
+		assert.Nil(t, err)
+		assert.Equal(t, `[1 2 0]`, v.String())
+
+		v, err = state.Call("f", 1, 3, 4, 5)
+		assert.Nil(t, err)
+		assert.Equal(t, `[1 3 2]`, v.String())
+
+		_, err = state.Call("f")
+		assert.NotNil(t, err)
+	})
+
+	t.Run("access global bindings", func(t *testing.T) {
+		src := `[
+			(reg "dyn" lambda () { Y })
+			(reg "inc" lambda (v) { (add v 1) })
+			(reg "dec" lambda (v) { (sub v 1) })
+		]`
+		state := runtime.NewState(lexAndParse(t, src), conf.New())
+		require.Nil(t, state.Execute())
+
+		// dynamic bindings are resolved through the global environment at runtime.
+		_, err := state.Call("dyn")
+		assert.NotNil(t, err)
+		require.Nil(t, state.SetGlobal("Y", runtime.NewNumber(2, 1)))
+		v, err := state.Call("dyn")
+		require.Nil(t, err)
+		assert.Equal(t, `2`, v.String())
+
+		dec, ok := state.GetGlobal("dec")
+		assert.True(t, ok)
+		assert.IsType(t, &runtime.Closure{}, dec)
+		_, ok = state.GetGlobal("z")
+		assert.False(t, ok)
+
+		require.Nil(t, state.SetGlobal("inc", dec))
+		v, err = state.Call("inc", 1)
+		require.Nil(t, err)
+		assert.Equal(t, `0`, v.String())
+
+		require.Nil(t, state.SetGlobal("x", runtime.NewString("str")))
+		globals := state.Globals()
+		assert.Len(t, globals, 5)
+		assert.Equal(t, `str`, globals["x"].String())
+		assert.Equal(t, `2`, globals["Y"].String())
+		assert.Equal(t, dec, globals["inc"])
+
+		assert.NotNil(t, state.SetGlobal("", runtime.NewNumber(1, 1)))
+		assert.NotNil(t, state.SetGlobal("1a", runtime.NewNumber(1, 1)))
+		assert.NotNil(t, state.SetGlobal("a-b", runtime.NewNumber(1, 1)))
+	})
+
+	t.Run("call script function with dynamic bindings", func(t *testing.T) {
+		src := `
+		letrec (
+			greet = lambda (name) { (concat Greeting name) }
+			show = lambda () { (greet Name) }
+		) {
+			[
+				(reg "show" show)
+				(reg "Show" show)
+			]
+		}
+		`
+		for _, tco := range []bool{true, false} {
+			state := runtime.NewState(lexAndParse(t, src), conf.New(conf.EnableTCO(tco)))
+			require.Nil(t, state.Execute())
+
+			bindings := map[string]runtime.Value{
+				"Greeting": runtime.NewString("hello "),
+				"Name":     runtime.NewString("world"),
+			}
+			v, err := state.CallDynamic("show", bindings)
+			require.Nil(t, err)
+			assert.Equal(t, `hello world`, v.String())
+
+			v, err = state.CallDynamic("Show", bindings)
+			require.Nil(t, err)
+			assert.Equal(t, `hello world`, v.String())
+
+			// bindings do not outlive the call.
+			v, err = state.Call("show")
+			assert.NotNil(t, err)
+			assert.Nil(t, v)
+
+			require.Nil(t, state.SetGlobal("Name", runtime.NewString("global")))
+			v, err = state.CallDynamic("show", map[string]runtime.Value{"Greeting": runtime.NewString("hi ")})
+			require.Nil(t, err)
+			assert.Equal(t, `hi global`, v.String())
+
+			v, err = state.CallDynamic("show", map[string]runtime.Value{"name": runtime.NewString("lexical")})
+			assert.NotNil(t, err)
+			assert.Nil(t, v)
+		}
+	})
+
 	t.Run("call golang function", func(t *testing.T) {
 		conf := conf.New()
 		plus1 := func(args ...runtime.Value) runtime.Value {
@@ -197,6 +670,17 @@ func TestRuntimeInteraction(t *testing.T) {
 		assert.NotNil(t, state.Execute())
 		assert.Equal(t, "<void>", state.Value().String())
 
+		fail := func(ctx *runtime.Context, args ...runtime.Value) (runtime.Value, *file.Error) {
+			return nil, ctx.Error("failed")
+		}
+		src = `[1 (go "fail")]`
+		state = runtime.NewState(lexAndParse(t, src), conf).RegisterFunction("fail", fail)
+		err := state.Execute()
+		require.NotNil(t, err)
+		assert.Equal(t, "failed", err.Message)
+		assert.Equal(t, file.SourceLocation{Line: 1, Col: 4}, err.Location)
+		assert.Equal(t, "<void>", state.Value().String())
+
 		// TODO: should we handle panics?
 		// raise := func(args ...runtime.Value) runtime.Value {
 		// 	panic("error")
@@ -207,56 +691,617 @@ func TestRuntimeInteraction(t *testing.T) {
 	})
 }
 
+func TestRuntimeCallback(t *testing.T) {
+	twice := func(ctx *runtime.Context, args ...runtime.Value) (runtime.Value, *file.Error) {
+		v, err := ctx.Call(args[0], args[1])
+		if err != nil {
+			return nil, err
+		}
+		return ctx.Call(args[0], v)
+	}
+	compose := func(ctx *runtime.Context, args ...runtime.Value) (runtime.Value, *file.Error) {
+		// the closure returned by the first callback must survive GC during the second one.
+		g, err := ctx.Call(args[0], args[1])
+		if err != nil {
+			return nil, err
+		}
+		if _, err := ctx.Call(args[0], args[1]); err != nil {
+			return nil, err
+		}
+		return ctx.Call(g)
+	}
+
+	tests := []struct {
+		input, value string
+	}{
+		{`(go "twice" lambda (x) { (add x 1) } 1)`, `3`},
+		{`(go "twice" lambda (x) { (go "twice" lambda (y) { (mul y 2) } x) } 1)`, `16`},
+		{`letrec (A = 10) { (go "twice" lambda (x) { (add x A) } 1) }`, `21`},
+		{`(go "compose" lambda (x) { letrec (y = (add x 1)) { lambda () { y } } } 1)`, `2`},
+		{`(add 1 (go "twice" lambda (x) { (callcc lambda (k) { (k x) }) } 1))`, `2`},
+	}
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			state := NewState(lexAndParse(t, test.input), conf.New()).
+				RegisterFunction("twice", twice).
+				RegisterFunction("compose", compose)
+			assert.Nil(t, state.Execute())
+			assert.Equal(t, test.value, state.Value().String())
+		})
+	}
+
+	errors := []string{
+		`(go "twice" 1 1)`,
+		`(go "twice" lambda () { 1 } 1)`,
+		`(go "twice" lambda (x) { (add x "1") } 1)`,
+	}
+	for _, test := range errors {
+		t.Run(test, func(t *testing.T) {
+			state := NewState(lexAndParse(t, test), conf.New()).RegisterFunction("twice", twice)
+			err := state.Execute()
+			assert.NotNil(t, err)
+			assert.Equal(t, "<void>", state.Value().String())
+			t.Log(err)
+		})
+	}
+
+	t.Run("continuation escaped from callback", func(t *testing.T) {
+		marks := 0
+		src := `[(reg "f" lambda () { 7 }) (callcc lambda (k) { (go "twice" lambda (x) { (k x) } 1) }) (go "mark")]`
+		state := NewState(lexAndParse(t, src), conf.New()).
+			RegisterFunction("twice", twice).
+			Register("mark", func(args ...runtime.Value) runtime.Value {
+				marks++
+				return nil
+			})
+		err := state.Execute()
+		require.NotNil(t, err)
+		assert.Equal(t, "continuation escaped from callback", err.Message)
+		// the rest of the program never runs inside the callback.
+		assert.Equal(t, 0, marks)
+
+		v, err := state.Call("f")
+		require.Nil(t, err)
+		assert.Equal(t, `7`, v.String())
+	})
+}
+
+func TestRuntimeHostObject(t *testing.T) {
+	type counter struct{ n int }
+	open := func(ctx *runtime.Context, args ...runtime.Value) (runtime.Value, *file.Error) {
+		object := runtime.NewHostObject("counter", &counter{})
+		object.Method("inc", func(ctx *runtime.Context, args ...runtime.Value) (runtime.Value, *file.Error) {
+			c := args[0].(*runtime.HostObject).Value.(*counter)
+			c.n++
+			return args[0], nil
+		}).Method("get", func(ctx *runtime.Context, args ...runtime.Value) (runtime.Value, *file.Error) {
+			return runtime.NewNumber(args[0].(*runtime.HostObject).Value.(*counter).n, 1), nil
+		})
+		return object, nil
+	}
+
+	tests := []struct {
+		input, value string
+	}{
+		{`(go "open")`, `<host object counter>`},
+		{`(ishost (go "open"))`, `true`},
+		{`(ishost 1)`, `false`},
+		{`letrec (c = (go "open")) { (eq c c) }`, `true`},
+		{`(eq (go "open") (go "open"))`, `false`},
+		{`(eq (go "open") 1)`, `false`},
+		{`letrec (c = (go "open")) { (ne c (go "open")) }`, `true`},
+		{`letrec (c = (go "open")) { [(go c "inc") (go (go c "inc") "inc") (go c "get")] }`, `3`},
+		{`letrec (c = (go "open")) { (evalin "[(go c \"inc\") (go c \"get\")]" lambda () { c }) }`, `1`},
+	}
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			state := NewState(lexAndParse(t, test.input), conf.New()).RegisterFunction("open", open)
+			assert.Nil(t, state.Execute())
+			assert.Equal(t, test.value, state.Value().String())
+		})
+	}
+
+	errors := []string{
+		`(go (go "open") "close")`,
+		`(go (go "open"))`,
+		`(ishost)`,
+	}
+	for _, test := range errors {
+		t.Run(test, func(t *testing.T) {
+			state := NewState(lexAndParse(t, test), conf.New()).RegisterFunction("open", open)
+			err := state.Execute()
+			assert.NotNil(t, err)
+			assert.Equal(t, "<void>", state.Value().String())
+			t.Log(err)
+		})
+	}
+}
+
+func TestRuntimeStrings(t *testing.T) {
+	// the lexer only accepts ASCII, so multi-byte strings are given by golang.
+	strs := []string{"héllo, 世界", "世", "界", "é"}
+	str := func(args ...runtime.Value) runtime.Value {
+		return runtime.NewString(strs[args[0].(*runtime.Number).Numerator])
+	}
+
+	tests := []struct {
+		input, value string
+	}{
+		{`(strlen (go "str" 0))`, `9`},
+		{`(strlen (go "str" 1))`, `1`},
+		{`(substr (go "str" 0) 1 4)`, `éll`},
+		{`(substr (go "str" 0) 7 9)`, `世界`},
+		{`(index (go "str" 0) (go "str" 1))`, `7`},
+		{`(index (go "str" 0) (go "str" 2))`, `8`},
+		{`(index (go "str" 0) (go "str" 3))`, `1`},
+		{`(index (go "str" 0) "x")`, `-1`},
+	}
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			state := NewState(lexAndParse(t, test.input), conf.New()).Register("str", str)
+			assert.Nil(t, state.Execute())
+			assert.Equal(t, test.value, state.Value().String())
+		})
+	}
+
+	state := NewState(lexAndParse(t, `(substr (go "str" 0) 8 10)`), conf.New()).Register("str", str)
+	err := state.Execute()
+	assert.NotNil(t, err)
+	t.Log(err)
+}
+
+func TestRuntimeAsync(t *testing.T) {
+	wait := func(ctx *runtime.Context, args ...runtime.Value) (runtime.Value, *file.Error) {
+		return ctx.Suspend()
+	}
+	callback := func(ctx *runtime.Context, args ...runtime.Value) (runtime.Value, *file.Error) {
+		return ctx.Call(args[0])
+	}
+
+	t.Run("resume", func(t *testing.T) {
+		state := NewState(lexAndParse(t, `(add (go "wait") (go "wait"))`), conf.New()).RegisterFunction("wait", wait)
+		require.Nil(t, state.Execute())
+		assert.True(t, state.Suspended())
+		_, err := state.Step()
+		assert.NotNil(t, err)
+		_, err = state.Call("wait")
+		assert.NotNil(t, err)
+
+		require.Nil(t, state.Resume(runtime.NewNumber(1, 1)))
+		assert.True(t, state.Suspended())
+		require.Nil(t, state.Resume(runtime.NewNumber(2, 1)))
+		assert.False(t, state.Suspended())
+		assert.Equal(t, `3`, state.Value().String())
+		assert.NotNil(t, state.Resume(nil))
+	})
+
+	t.Run("reject", func(t *testing.T) {
+		state := NewState(lexAndParse(t, `(add 1 (go "wait"))`), conf.New()).RegisterFunction("wait", wait)
+		require.Nil(t, state.Execute())
+		err := state.Reject("timeout")
+		require.NotNil(t, err)
+		assert.Equal(t, "timeout", err.Message)
+		assert.Equal(t, 1, err.Location.Line)
+		assert.Equal(t, 8, err.Location.Col)
+		assert.False(t, state.Suspended())
+		assert.NotNil(t, state.Reject("timeout"))
+	})
+
+	t.Run("run after reject", func(t *testing.T) {
+		state := NewState(lexAndParse(t, `[(reg "f" lambda () { 7 }) (add 1 (go "wait"))]`), conf.New()).RegisterFunction("wait", wait)
+		require.Nil(t, state.Execute())
+		require.NotNil(t, state.Reject("timeout"))
+		require.Nil(t, state.Execute())
+		assert.False(t, state.Suspended())
+		v, err := state.Call("f")
+		require.Nil(t, err)
+		assert.Equal(t, `7`, v.String())
+		assert.False(t, state.Suspended())
+	})
+
+	t.Run("synchronous call", func(t *testing.T) {
+		state := NewState(lexAndParse(t, `[(reg "f" lambda () { (go "wait") }) (go "callback" f)]`), conf.New()).
+			RegisterFunction("wait", wait).RegisterFunction("callback", callback)
+		assert.NotNil(t, state.Execute())
+		assert.False(t, state.Suspended())
+
+		state = NewState(lexAndParse(t, `(reg "f" lambda () { (go "wait") })`), conf.New()).RegisterFunction("wait", wait)
+		require.Nil(t, state.Execute())
+		_, err := state.Call("f")
+		assert.NotNil(t, err)
+		assert.False(t, state.Suspended())
+	})
+}
+
+func TestRuntimeCapabilities(t *testing.T) {
+	open := func(ctx *runtime.Context, args ...runtime.Value) (runtime.Value, *file.Error) {
+		object := runtime.NewHostObject("file", nil)
+		object.Method("read", func(ctx *runtime.Context, args ...runtime.Value) (runtime.Value, *file.Error) {
+			return runtime.NewString("data"), nil
+		}).Method("write", func(ctx *runtime.Context, args ...runtime.Value) (runtime.Value, *file.Error) {
+			return nil, nil
+		})
+		return object, nil
+	}
+	sandbox := &conf.Capabilities{
+		Intrinsic: conf.Except("getline", "put"),
+		FFI:       conf.Only("open", "file.read"),
+	}
+
+	tests := []struct {
+		input, value string
+		opts         []conf.Option
+	}{
+		{`(put "")`, `<void>`, nil},
+		{`(add 1 2)`, `3`, []conf.Option{conf.SetCapabilities(sandbox)}},
+		{`(go (go "open") "read")`, `data`, []conf.Option{conf.SetCapabilities(sandbox)}},
+		{`(eval "(add 1 2)")`, `3`, []conf.Option{conf.SetCapabilities(sandbox)}},
+		{`[(put "") (eval "1")]`, `1`, []conf.Option{conf.SetEvalCapabilities(sandbox)}},
+		{`[(evalin "1" lambda () { 0 }) (put "")]`, `<void>`, []conf.Option{conf.SetEvalCapabilities(sandbox)}},
+		{`[(iserr (evalin "(put \"\")" lambda () { 0 } true)) (put "")]`, `<void>`, []conf.Option{conf.SetEvalCapabilities(sandbox)}},
+		{`(iserr (evalin "(put \"\")" lambda () { 0 } true))`, `true`, []conf.Option{conf.SetEvalCapabilities(sandbox)}},
+		{`[(callcc lambda (out) { (evalin "(out 1)" lambda () { 0 }) }) (put "")]`, `<void>`, []conf.Option{conf.SetEvalCapabilities(sandbox)}},
+	}
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			state := NewState(lexAndParse(t, test.input), conf.New(test.opts...)).RegisterFunction("open", open)
+			assert.Nil(t, state.Execute())
+			assert.Equal(t, test.value, state.Value().String())
+		})
+	}
+
+	errors := []struct {
+		input string
+		opts  []conf.Option
+	}{
+		{`(put "")`, []conf.Option{conf.SetCapabilities(sandbox)}},
+		{`(go "close")`, []conf.Option{conf.SetCapabilities(sandbox)}},
+		{`(go (go "open") "write")`, []conf.Option{conf.SetCapabilities(sandbox)}},
+		{`(eval "(put \"\")")`, []conf.Option{conf.SetCapabilities(sandbox)}},
+		{`(eval "(reg \"f\" lambda () { 1 })")`, []conf.Option{
+			conf.SetCapabilities(sandbox),
+			conf.SetEvalCapabilities(&conf.Capabilities{Intrinsic: conf.Except("reg")}),
+		}},
+		{`(eval "(go \"open\")")`, []conf.Option{conf.SetEvalCapabilities(&conf.Capabilities{FFI: conf.Only()})}},
+		{`(evalin "(put 1)" lambda () { 0 })`, []conf.Option{conf.SetEvalCapabilities(sandbox)}},
+		{`(evalin "(evalin \"(put 1)\" lambda () { 0 })" lambda () { 0 })`, []conf.Option{conf.SetEvalCapabilities(sandbox)}},
+		{"(evalin `(go \"close\") lambda () { 0 })", []conf.Option{conf.SetEvalCapabilities(sandbox)}},
+		{`letrec (f = lambda () { (put 1) }) { (evalin "(f)" lambda () { 0 }) }`, []conf.Option{conf.SetEvalCapabilities(sandbox)}},
+		{`((evalin "lambda () { (put 1) }" lambda () { 0 }))`, []conf.Option{conf.SetEvalCapabilities(sandbox)}},
+		{`((evalin "lambda () { (go \"close\") }" lambda () { 0 }))`, []conf.Option{conf.SetEvalCapabilities(sandbox)}},
+		{`[(evalin "letrec (f = lambda (v) { (put 1) }) { (lambda (v) { [(finalize v f) v] } lambda () { 0 }) }" lambda () { 0 }) 0 0]`, []conf.Option{
+			conf.SetEvalCapabilities(sandbox),
+			conf.SetGCTrigger(func() bool { return true }),
+		}},
+	}
+	for _, test := range errors {
+		t.Run(test.input, func(t *testing.T) {
+			state := NewState(lexAndParse(t, test.input), conf.New(test.opts...)).RegisterFunction("open", open)
+			err := state.Execute()
+			require.NotNil(t, err)
+			assert.Equal(t, file.PermissionError, err.Kind)
+			t.Log(err)
+		})
+	}
+
+	t.Run("registered from evalin", func(t *testing.T) {
+		state := NewState(lexAndParse(t, `(evalin "(reg \"s\" lambda () { (put 1) })" lambda () { 0 })`), conf.New(conf.SetEvalCapabilities(sandbox)))
+		require.Nil(t, state.Execute())
+		_, err := state.Call("s")
+		require.NotNil(t, err)
+		assert.Equal(t, file.PermissionError, err.Kind)
+	})
+
+	t.Run("call after evalin failed", func(t *testing.T) {
+		src := `[(reg "f" lambda () { (put "") }) (evalin "(div 1 0)" lambda () { 0 })]`
+		state := NewState(lexAndParse(t, src), conf.New(conf.SetEvalCapabilities(sandbox)))
+		require.NotNil(t, state.Execute())
+		_, err := state.Call("f")
+		assert.Nil(t, err)
+	})
+
+	t.Run("stdlib", func(t *testing.T) {
+		wd, e := os.Getwd()
+		require.Nil(t, e)
+		require.Nil(t, os.Chdir(".."))
+		defer os.Chdir(wd)
+
+		config := conf.New(conf.UseStd(true), conf.SetCapabilities(&conf.Capabilities{Intrinsic: conf.Except("reg")}))
+		state := NewState(lexAndParse(t, `(reg "f" lambda () { 1 })`), config)
+		err := state.Execute()
+		require.NotNil(t, err)
+		assert.Equal(t, file.PermissionError, err.Kind)
+	})
+}
+
 func TestIntrinsics(t *testing.T) {
 	tests := []struct {
 		input, value string
 	}{
 		{`(void)`, `<void>`},
-		{`(isvoid 1)`, `0`},
-		{`(isvoid (void))`, `1`},
-		{`(isnum 0)`, `1`},
-		{`(isnum "1")`, `0`},
-		{`(isstr 1)`, `0`},
-		{`(isstr "1")`, `1`},
-		{`(isclo 1)`, `0`},
-		{`(isclo lambda () { 0 })`, `1`},
-		{`(iscont (callcc lambda(k) { (k k) }))`, `1`},
-		{`(iscont (callcc lambda(k) { 1 }))`, `0`},
+		{`(isvoid 1)`, `false`},
+		{`(isvoid (void))`, `true`},
+		{`(isnum 0)`, `true`},
+		{`(isnum "1")`, `false`},
+		{`(isstr 1)`, `false`},
+		{`(isstr "1")`, `true`},
+		{`(isclo 1)`, `false`},
+		{`(isclo lambda () { 0 })`, `true`},
+		{`(iscont (callcc lambda(k) { (k k) }))`, `true`},
+		{`(iscont (callcc lambda(k) { 1 }))`, `false`},
 		{`(add 1 2)`, `3`},
 		{`(add 0.3 2/3)`, `29/30`},
 		{`(sub 1 2)`, `-1`},
 		{`(mul 1 2)`, `2`},
 		{`(div 9 -3)`, `-3`},
-		{`(lt 1 2)`, `1`},
-		{`(lt 2 1)`, `0`},
-		{`(gt 1 2)`, `0`},
-		{`(gt 2 1)`, `1`},
-		{`(le 2 2)`, `1`},
-		{`(le 2 1)`, `0`},
-		{`(ge 1 2)`, `0`},
-		{`(ge 1 1)`, `1`},
-		{`(eq 1 1)`, `1`},
-		{`(eq 1 2)`, `0`},
-		{`(eq "1" "1")`, `1`},
-		{`(eq "1" "2")`, `0`},
-		{`(ne 1 1)`, `0`},
-		{`(ne 1 2)`, `1`},
-		{`(ne "1" "1")`, `0`},
-		{`(ne "1" "2")`, `1`},
-		{`(and 1 1)`, `1`},
-		{`(and 1 0)`, `0`},
-		{`(and 2 3)`, `1`},
-		{`(or 0 1)`, `1`},
-		{`(or 0 0)`, `0`},
-		{`(or 2 3)`, `1`},
-		{`(not 1)`, `0`},
-		{`(not 2)`, `0`},
-		{`(not 0)`, `1`},
+		{`(lt 1 2)`, `true`},
+		{`(lt 2 1)`, `false`},
+		{`(gt 1 2)`, `false`},
+		{`(gt 2 1)`, `true`},
+		{`(le 2 2)`, `true`},
+		{`(le 2 1)`, `false`},
+		{`(ge 1 2)`, `false`},
+		{`(ge 1 1)`, `true`},
+		{`(lt "1" "2")`, `true`},
+		{`(gt "1" "2")`, `false`},
+		{`(le "1" "2")`, `true`},
+		{`(ge "1" "2")`, `false`},
+		{`(eq 1 1)`, `true`},
+		{`(eq 1 2)`, `false`},
+		{`(eq "1" "1")`, `true`},
+		{`(eq "1" "2")`, `false`},
+		{`(ne 1 1)`, `false`},
+		{`(ne 1 2)`, `true`},
+		{`(ne "1" "1")`, `false`},
+		{`(ne "1" "2")`, `true`},
+		{`(and true true)`, `true`},
+		{`(and true false)`, `false`},
+		{`(and true 3)`, `3`},
+		{`(or false true)`, `true`},
+		{`(or false false)`, `false`},
+		{`(or true 3)`, `true`},
+		{`(and)`, `true`},
+		{`(or)`, `false`},
+		{`(and true true "s")`, `s`},
+		{`(or false false false)`, `false`},
+		{`(and false (div 1 0))`, `false`},
+		{`(or true (div 1 0))`, `true`},
+		{`(and true false (put "unreachable"))`, `false`},
+		{`(not true)`, `false`},
+		{`(not false)`, `true`},
+		{`[(isbool true) (isbool false)]`, `true`},
+		{`(isbool (eq 1 1))`, `true`},
+		{`(isbool 1)`, `false`},
+		{`(isbool "true")`, `false`},
+		{`(eq true (not false))`, `true`},
+		{`(eq true 1)`, `false`},
+		{`(tostr false)`, `false`},
 		{`(concat "hello" "world")`, `helloworld`},
 		{`(id (void))`, `3`},
-		{`(eq (id (void)) (id (void)))`, `1`},
-		{`(eq (id 1) (id 2))`, `0`},
-		{`(eq (id "str") (id "str"))`, `0`},
+		{`(eq (id (void)) (id (void)))`, `true`},
+		{`(eq (id 1) (id 2))`, `false`},
+		{`(eq (id "str") (id "str"))`, `false`},
+		{`letrec (x = 1) { (evalin "(add x 1)" lambda () { x }) }`, `2`},
+		{`letrec (X = 5) { (evalin "X" lambda () { 0 }) }`, `5`},
+		{`(evalin "(add 1 \"2\")" lambda () { 0 } true)`, `<error raised at (SourceLocation 1 1): wrong type of arguments given to callee>`},
+		{`(errmsg (evalin "(add 1" lambda () { 0 } true))`, `incomplete token stream`},
+		{`letrec (f = lambda () { (div 1 0) }) { (iserr (evalin "(f)" lambda () { f } true)) }`, `true`},
+		{`[(evalin "(div 1 0)" lambda () { 0 } true) 7]`, `7`},
+		{`(iserr 1)`, `false`},
+		{"`(add 1 2)", "<code (add 1 2)>"},
+		{"letrec (x = 1) { `(add ,x ,(add x 1)) }", "<code (add 1 2)>"},
+		{"`,\"s\"", `<code "s">`},
+		{"letrec (x = `[1 2]) { `[0 ,@x 3] }", "<code [0 1 2 3]>"},
+		{"`(f ,@(void))", "<code (f)>"},
+		{"letrec (x = 1) { `(a `(b ,(c ,x))) }", "<code (a `(b ,(c 1)))>"},
+		{"(eval `(add 1 2))", `3`},
+		{"letrec (x = 20) { (evalin `(add x ,(add 1 1)) lambda () { x }) }", `22`},
+		{"(iscode `1)", `true`},
+		{`(iscode 1)`, `false`},
+		{"(codekind `lambda (a) { a })", `lambda`},
+		{"(codelen `lambda (a) { a })", `2`},
+		{"(codekind (codeget `(add 1 2) 0))", `intrinsic`},
+		{"(codeval (codeget `(add 1 \"s\") 2))", `s`},
+		{"(codeval (codeget `letrec (a = 1) { a } 0))", `a`},
+		{`(eval (mkcode "call" (mkcode "intrinsic" "add") (mkcode "number" 1) (mkcode "number" 2)))`, `3`},
+		{"(mkcode \"lambda\" (mkcode \"variable\" \"x\") `(mul x x))", "<code lambda (x) { (mul x x) }>"},
+		{"(mkcode \"letrec\" (mkcode \"variable\" \"X\") `1 `X)", "<code letrec (X = 1) { X }>"},
+		{"(mkcode \"access\" (mkcode \"variable\" \"x\") `f)", "<code &x f>"},
+		{"(mkcode \"quote\" `a)", "<code `a>"},
+		{"(eval `match 1 { 1 then ,(add 1 2) })", `3`},
+		{"letrec (x = 2) { `match y { [n] if (gt n ,x) then ,(add x 1) _ then 0 } }", "<code match y { [n] if (gt n 2) then 3 _ then 0 }>"},
+		{"(codekind `match 1 { n then n })", `match`},
+		{"(codelen `match 1 { n if (gt n 0) then n _ then 0 })", `6`},
+		{"(eval `data maybe (just (value) nothing ()) { match (just ,(add 1 2)) { (just x) then x _ then 0 } })", `3`},
+		{"letrec (x = 1) { `data t (c (a b)) { (c ,x 2) } }", "<code data t (c (a b)) { (c 1 2) }>"},
+		{"(codekind `data t (c ()) { 1 })", `data`},
+		{"(codelen `data t (c (a b) d ()) { 1 })", `6`},
+		{"(codeval (codeget `data t (c (a b)) { 1 } 3))", `b`},
+		{"(eval `{a = ,(add 1 2)})", `{a = 3}`},
+		{"letrec (r = {a = 1 b = 2}) { (evalin `{r | b = ,(add 1 2)} lambda () { r }) }", `{a = 1 b = 3}`},
+		{"letrec (x = 1) { `{a = ,x b = {c = [,x]}} }", "<code {a = 1 b = {c = [1]}}>"},
+		{"(codekind `{a = 1})", `record`},
+		{"(codekind `{{a = 1} | b = 2})", `record`},
+		{"(codelen `{r | a = 1 b = 2})", `5`},
+		{"(eval `cond { true then ,(add 1 2) })", `3`},
+		{"letrec (x = 1) { `cond { (eq y ,x) then ,(add x 1) else ,x } }", "<code cond { (eq y 1) then 2 else 1 }>"},
+		{"(codekind `cond { a then 1 })", `cond`},
+		{"(codelen `cond { a then 1 b then 2 else 3 })", `5`},
+		{"(eval `let (x = ,(add 1 2)) { x })", `3`},
+		{"letrec (y = 2) { `let* (x = ,y [a] = x) { (add a ,(add y 1)) } }", "<code let* (x = 2 [a] = x) { (add a 3) }>"},
+		{"(eval `loop (i = 0 n = ,(add 1 2)) { if (lt i n) then (recur (add i 1) n) else i })", `3`},
+		{"letrec (y = 2) { `loop l ([a] = ,y) { (l ,(add y 1)) } }", "<code loop l ([a] = 2) { (l 3) }>"},
+		{"(codekind `let (x = 1) { x })", `let`},
+		{"(codekind `let* (x = 1) { x })", `let*`},
+		{"(codekind `loop (x = 1) { x })", `loop`},
+		{"(codelen `loop (x = 1 y = 2) { x })", `6`},
+		{"(codeval (codeget `loop (x = 1) { x } 0))", `recur`},
+		{`(mklist 1 "a" (mklist))`, `[1 a []]`},
+		{`(islist (mklist))`, `true`},
+		{`(islist 1)`, `false`},
+		{`(listlen (mklist 1 2))`, `2`},
+		{`(listget (mklist 1 2) 1)`, `2`},
+		{`(floor 7/2)`, `3`},
+		{`(floor -7/2)`, `-4`},
+		{`(ceil 7/2)`, `4`},
+		{`(ceil -7/2)`, `-3`},
+		{`(round 5/2)`, `3`},
+		{`(round -5/2)`, `-3`},
+		{`(round 7/3)`, `2`},
+		{`(round 9223372036854775806/9223372036854775807)`, `1`},
+		{`(round -9223372036854775806/9223372036854775807)`, `-1`},
+		{`(ceil (div (sub -9223372036854775807 1) 3))`, `-3074457345618258602`},
+		{`(floor 2)`, `2`},
+		{`(quot 7 2)`, `3`},
+		{`(quot -7 2)`, `-4`},
+		{`(mod 7 2)`, `1`},
+		{`(mod -7 2)`, `1`},
+		{`(mod 7 -2)`, `-1`},
+		{`(mod 7/2 1)`, `1/2`},
+		{`(mod 1 1/3)`, `0`},
+		{`(pow 2 10)`, `1024`},
+		{`(pow 2/3 2)`, `4/9`},
+		{`(pow -2 -3)`, `-1/8`},
+		{`(pow 0 0)`, `1`},
+		{`(pow 1 10000000000)`, `1`},
+		{`(pow -1 10000000001)`, `-1`},
+		{`(pow 0 10000000000)`, `0`},
+		{`(pow 10 18)`, `1000000000000000000`},
+		{`(pow -2 63)`, `-9223372036854775808`},
+		{`(pow 1/2 62)`, `1/4611686018427387904`},
+		{`(pow 3/2 -3)`, `8/27`},
+		{`(abs -3/4)`, `3/4`},
+		{`(abs 0)`, `0`},
+		{`(min 1/2 1/3)`, `1/3`},
+		{`(max 1/2 1/3)`, `1/2`},
+		{`(numerator -6/4)`, `-3`},
+		{`(denominator -6/4)`, `2`},
+		{`(todec 1/3 4)`, `0.3333`},
+		{`(todec 2/3 4)`, `0.6667`},
+		{`(todec -1/8 2)`, `-0.13`},
+		{`(todec -1/8 2 "even")`, `-0.12`},
+		{`(todec 1/8 2 "floor")`, `0.12`},
+		{`(todec -1/8 2 "floor")`, `-0.13`},
+		{`(todec 1/8 2 "ceil")`, `0.13`},
+		{`(todec -1/8 2 "trunc")`, `-0.12`},
+		{`(todec -1/1000 2)`, `0.00`},
+		{`(todec 5/2 0)`, `3`},
+		{`(todec 1/20 3)`, `0.050`},
+		{`(todec 12 2)`, `12.00`},
+		{`(todec 1/3 19 "round")`, `0.3333333333333333333`},
+		{`(todec 2/3 30)`, `0.666666666666666666666666666667`},
+		{`(todec -2/3 25 "trunc")`, `-0.6666666666666666666666666`},
+		{`(todec 1000000000000 8 "round")`, `1000000000000.00000000`},
+		{`(todec 9223372036854775807 3)`, `9223372036854775807.000`},
+		{`(todec -99999999999/2 0 "even")`, `-50000000000`},
+		{`(todec 1/9223372036854775807 20 "ceil")`, `0.00000000000000000011`},
+		{`(todec 1/3 1000 "floor")`, `0.` + strings.Repeat("3", 1000)},
+		{`1.5e3`, `1500e0`},
+		{`-25E-2`, `-0.25e0`},
+		{`1e21`, `1e21`},
+		{`1.5e-7`, `1.5e-7`},
+		{`(tonum (tostr (div 1e0 3)))`, `0.3333333333333333e0`},
+		{`(eq (tonum (tostr (div 1e0 3))) (div 1e0 3))`, `true`},
+		{`(tonum "2e2")`, `200e0`},
+		{`(tonum "9007199254740993/2")`, `9007199254740993/2`},
+		{`(tonum "-9007199254740994/4")`, `-4503599627370497/2`},
+		{`(tonum (tostr (div 1e0 0)))`, `+inf`},
+		{`(eq (tonum (tostr (div -1e0 0))) (div -1e0 0))`, `true`},
+		{`(tonum (tostr (sqrt -1)))`, `nan`},
+		{`(isfloat (tonum "nan"))`, `true`},
+		{`(tonum "inf")`, `<void>`},
+		{`(tonum "NaN")`, `<void>`},
+		{`(div 1e0 0)`, `+inf`},
+		{`(div -1 0e0)`, `-inf`},
+		{`(sqrt -1)`, `nan`},
+		{`[(isfloat 1e0) (isnum 1e0)]`, `false`},
+		{`[(isnum 1) (isfloat 1e0)]`, `true`},
+		{`(isfloat 1)`, `false`},
+		{`(add 1/2 1e0)`, `1.5e0`},
+		{`(sub 1e0 1/4)`, `0.75e0`},
+		{`(mul 2 1.5e0)`, `3e0`},
+		{`(div 1 4e0)`, `0.25e0`},
+		{`(add 0.1e0 0.2e0)`, `0.30000000000000004e0`},
+		{`(add 0.1 0.2)`, `3/10`},
+		{`(eq 1 1e0)`, `true`},
+		{`(eq 1.5e0 3/2)`, `true`},
+		{`(eq 1/3 (div 1e0 3))`, `false`},
+		{`(eq 9007199254740993 9007199254740992e0)`, `false`},
+		{`(lt 9007199254740992e0 9007199254740993)`, `true`},
+		{`(gt (div 1e0 0) 9223372036854775807)`, `true`},
+		{`(eq 1e0 (inexact 1))`, `true`},
+		{`(lt 1/3 0.3e0)`, `false`},
+		{`(ge 1e0 1)`, `true`},
+		{`(lt (sqrt -1) 0)`, `true`},
+		{`(floor -2.5e0)`, `-3e0`},
+		{`(ceil 2.1e0)`, `3e0`},
+		{`(round 2.5e0)`, `3e0`},
+		{`(quot 7e0 -2)`, `-4e0`},
+		{`(mod -7 2e0)`, `1e0`},
+		{`(mod 7e0 -2)`, `-1e0`},
+		{`(pow 2 0.5e0)`, `1.4142135623730951e0`},
+		{`(abs -1e0)`, `1e0`},
+		{`(min 1/2 0.25e0)`, `0.25e0`},
+		{`(max 1 0.25e0)`, `1e0`},
+		{`(exact 0.5e0)`, `1/2`},
+		{`(exact 1e0)`, `1`},
+		{`(exact 0.1e0)`, `3602879701896397/36028797018963968`},
+		{`(exact 3/4)`, `3/4`},
+		{`(inexact 1/3)`, `0.3333333333333333e0`},
+		{`(inexact 2e0)`, `2e0`},
+		{`(sqrt 16)`, `4e0`},
+		{`(exp 0)`, `1e0`},
+		{`(log 1)`, `0e0`},
+		{`(sin 0)`, `0e0`},
+		{`(cos 0e0)`, `1e0`},
+		{`(tan 0)`, `0e0`},
+		{`(asin 1)`, `1.5707963267948966e0`},
+		{`(acos 1)`, `0e0`},
+		{`(mul 4 (atan 1))`, `3.141592653589793e0`},
+		{`(atan2 1 -1)`, `2.356194490192345e0`},
+		{`(concat)`, ``},
+		{`(concat "a" "b" "c")`, `abc`},
+		{`(strlen "hello, world")`, `12`},
+		{`(strlen "")`, `0`},
+		{`(substr "hello, world" 1 5)`, `ello`},
+		{`(substr "hello, world" 7 12)`, `world`},
+		{`(substr "abc" 3 3)`, ``},
+		{`(index "hello, world" "wo")`, `7`},
+		{`(index "abc" "d")`, `-1`},
+		{`(index "abc" "")`, `0`},
+		{`(split "a,b,,c" ",")`, `[a b  c]`},
+		{`(listlen (split "abc" ""))`, `3`},
+		{`(join (split "a b c" " ") "-")`, `a-b-c`},
+		{`(join (mklist) ",")`, ``},
+		{`(upper "hello")`, `HELLO`},
+		{`(lower "HeLLo")`, `hello`},
+		{`(concat "[" (trim " \t a b \n") "]")`, `[a b]`},
+		{`(replace "a-b-c" "-" "+")`, `a+b+c`},
+		{`(tonum "42")`, `42`},
+		{`(tonum "-0.25")`, `-1/4`},
+		{`(tonum "2/4")`, `1/2`},
+		{`(tonum "1a")`, `<void>`},
+		{`(tonum "(add 1 2)")`, `<void>`},
+		{`(tonum "99999999999999999999")`, `<void>`},
+		{`(tonum "1/99999999999999999999")`, `<void>`},
+		{`(tonum "0.0000000000000000001")`, `<void>`},
+		{`(tonum "-9223372036854775808")`, `-9223372036854775808`},
+		{`(tostr 1/2)`, `1/2`},
+		{`(strlen (tostr (mklist 1 "a")))`, `5`},
+		{`(tostr "s")`, `s`},
+		{`(lt "a" "b")`, `true`},
+		{`(lt "b" "abc")`, `false`},
+		{`(gt "b" "B")`, `true`},
+		{`(le "a" "a")`, `true`},
+		{`(ge "a" "ab")`, `false`},
+		{`(ref 1)`, `<reference>`},
+		{`(isref (ref 1))`, `true`},
+		{`(isref 1)`, `false`},
+		{`(deref (ref "a"))`, `a`},
+		{`(setref (ref 1) 2)`, `<void>`},
+		{`letrec (r = (ref 1)) { [(setref r 2) (deref r)] }`, `2`},
+		{`letrec (r = (ref 1)) { (eq r r) }`, `true`},
+		{`(eq (ref 1) (ref 1))`, `false`},
 	}
 	for _, test := range tests {
 		t.Run(test.input, func(t *testing.T) {
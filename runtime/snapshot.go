@@ -0,0 +1,615 @@
+package runtime
+
+import (
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/gogim1/goscript/ast"
+	"github.com/gogim1/goscript/file"
+)
+
+// A snapshot starts with snapshotMagic and the big-endian snapshotVersion,
+// followed by the gob encoding of snapshot.
+const (
+	snapshotMagic   = "GOSS"
+	snapshotVersion = uint32(1)
+)
+
+const (
+	voidKind byte = iota
+	numberKind
+	stringKind
+	closureKind
+	continuationKind
+	weakRefKind
+	errorKind
+	codeKind
+	intrinsicCodeKind
+	listKind
+	constructorKind
+	predicateKind
+	dataKind
+	recordKind
+	floatKind
+	boolKind
+	refKind
+)
+
+type snapshot struct {
+	Programs   []int    // number of nodes of each loaded program
+	Sources    []string // source of each program loaded by `evalin`, empty otherwise
+	Values     []snapshotValue
+	Envs       [][]snapshotEnvItem
+	Types      []int // declaration of each data type
+	Heap       []int
+	Stack      []snapshotLayer
+	Value      int
+	Finalizers [][2]int // pairs of value and script finalizer
+	WeakRefs   []int
+	Suspended  bool
+}
+
+type snapshotEnvItem struct {
+	Name     string
+	Location int
+}
+
+type snapshotLayer struct {
+	Env    int
+	Frame  bool
+	Tail   bool
+	Expr   int
+	Pc     int
+	Args   []int
+	Callee int
+}
+
+type snapshotValue struct {
+	Kind        byte
+	Numerator   int
+	Denominator int
+	Float       float64
+	Bool        bool
+	String      string
+	Env         []snapshotEnvItem
+	Node        int
+	Location    file.SourceLocation
+	Stack       []snapshotLayer
+	Target      int
+	ErrorKind   file.ErrorKind
+	Elems       []int
+	Type        int
+	Variant     int
+	Fields      []string
+	Cell        int
+}
+
+// Snapshot serializes the whole state, including closures and captured continuations.
+// It can be restored by a state created from the same program source.
+// Host objects and host finalizers cannot be serialized.
+func (s *state) Snapshot(w io.Writer) error {
+	if len(s.contexts) != 0 {
+		return errors.New("cannot snapshot during an FFI call")
+	}
+	e := &encoder{
+		state:  s,
+		nodes:  make(map[ast.ExprNode]int),
+		values: make(map[int64]int),
+		envs:   make(map[*[]envItem]int),
+		types:  make(map[*dataType]int),
+	}
+	n := 0
+	for _, program := range s.programs {
+		begin := n
+		ast.Walk(program, func(node ast.ExprNode) {
+			if _, ok := e.nodes[node]; !ok {
+				e.nodes[node] = n
+			}
+			n++
+		})
+		e.Programs = append(e.Programs, n-begin)
+		e.Sources = append(e.Sources, s.sources[program])
+	}
+
+	for _, v := range s.heap {
+		e.Heap = append(e.Heap, e.value(v))
+	}
+	e.Stack = e.layers(s.stack)
+	e.Value = e.value(s.value)
+	for _, f := range s.finalizers {
+		if f.script != nil {
+			e.Finalizers = append(e.Finalizers, [2]int{e.value(f.value), e.value(f.script)})
+		}
+	}
+	for _, f := range s.pending {
+		if f.script != nil {
+			e.Finalizers = append(e.Finalizers, [2]int{e.value(f.value), e.value(f.script)})
+		}
+	}
+	for _, w := range s.weakrefs {
+		e.WeakRefs = append(e.WeakRefs, e.value(w))
+	}
+	e.Suspended = s.suspended
+	if e.err != nil {
+		return e.err
+	}
+
+	if _, err := io.WriteString(w, snapshotMagic); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.BigEndian, snapshotVersion); err != nil {
+		return err
+	}
+	return gob.NewEncoder(w).Encode(&e.snapshot)
+}
+
+type encoder struct {
+	snapshot
+	state  *state
+	nodes  map[ast.ExprNode]int
+	values map[int64]int
+	envs   map[*[]envItem]int
+	types  map[*dataType]int
+	err    error
+}
+
+func (e *encoder) node(node ast.ExprNode) int {
+	if node == nil {
+		return -1
+	}
+	index, ok := e.nodes[node]
+	if !ok && e.err == nil {
+		e.err = errors.New("cannot snapshot code that is not part of a loaded program")
+	}
+	return index
+}
+
+func (e *encoder) env(env []envItem) []snapshotEnvItem {
+	items := make([]snapshotEnvItem, len(env))
+	for i, item := range env {
+		items[i] = snapshotEnvItem{Name: item.name, Location: item.location}
+	}
+	return items
+}
+
+func (e *encoder) dataType(t *dataType) int {
+	index, ok := e.types[t]
+	if !ok {
+		index = len(e.Types)
+		e.types[t] = index
+		e.Types = append(e.Types, e.node(t.Node))
+	}
+	return index
+}
+
+// variant returns the index of a constructor in its data type, or -1 for nil.
+func variant(constructor *Constructor) int {
+	if constructor != nil {
+		for i, c := range constructor.Type.Constructors {
+			if c == constructor {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func (e *encoder) layers(layers []*layer) []snapshotLayer {
+	ret := make([]snapshotLayer, len(layers))
+	for i, l := range layers {
+		index, ok := e.envs[l.env]
+		if !ok {
+			index = len(e.Envs)
+			e.envs[l.env] = index
+			e.Envs = append(e.Envs, e.env(*l.env))
+		}
+		args := make([]int, len(l.args))
+		for j, v := range l.args {
+			args[j] = e.value(v)
+		}
+		ret[i] = snapshotLayer{
+			Env:    index,
+			Frame:  l.frame,
+			Tail:   l.tail,
+			Expr:   e.node(l.expr),
+			Pc:     l.pc,
+			Args:   args,
+			Callee: e.value(l.callee),
+		}
+	}
+	return ret
+}
+
+func (e *encoder) value(value Value) int {
+	if value == nil {
+		return -1
+	}
+	if index, ok := e.values[value.GetId()]; ok {
+		return index
+	}
+	index := len(e.Values)
+	e.values[value.GetId()] = index
+	e.Values = append(e.Values, snapshotValue{})
+
+	var v snapshotValue
+	switch value := value.(type) {
+	case *Void:
+		v.Kind = voidKind
+	case *Number:
+		v.Kind = numberKind
+		v.Numerator, v.Denominator = value.Numerator, value.Denominator
+	case *Float:
+		v.Kind = floatKind
+		v.Float = value.Value
+	case *Bool:
+		v.Kind = boolKind
+		v.Bool = value.Value
+	case *String:
+		v.Kind = stringKind
+		v.String = value.Value
+	case *Closure:
+		v.Kind = closureKind
+		v.Env = e.env(value.Env)
+		v.Node = e.node(value.Fun)
+	case *Continuation:
+		v.Kind = continuationKind
+		v.Location = value.SourceLocation
+		v.Stack = e.layers(value.Stack)
+	case *Ref:
+		v.Kind = refKind
+		v.Cell = value.Cell
+	case *WeakRef:
+		v.Kind = weakRefKind
+		v.Target = e.value(value.Target)
+	case *Code:
+		// code is kept as source, since it is usually not part of a loaded program.
+		if n, ok := value.Node.(*ast.IntrinsicNode); ok {
+			v.Kind = intrinsicCodeKind
+			v.String = n.Name
+		} else {
+			v.Kind = codeKind
+			v.String = ast.Format(value.Node)
+		}
+	case *List:
+		v.Kind = listKind
+		for _, elem := range value.Elems {
+			v.Elems = append(v.Elems, e.value(elem))
+		}
+	case *Constructor:
+		v.Kind = constructorKind
+		v.Type = e.dataType(value.Type)
+		v.Variant = variant(value)
+	case *Predicate:
+		v.Kind = predicateKind
+		v.Type = e.dataType(value.Type)
+		v.Variant = variant(value.Constructor)
+	case *Data:
+		v.Kind = dataKind
+		v.Target = e.value(value.Constructor)
+		for _, elem := range value.Values {
+			v.Elems = append(v.Elems, e.value(elem))
+		}
+	case *Record:
+		v.Kind = recordKind
+		v.Fields = value.Fields
+		for _, elem := range value.Values {
+			v.Elems = append(v.Elems, e.value(elem))
+		}
+	case *Error:
+		v.Kind = errorKind
+		v.Location = value.Err.Location
+		v.String = value.Err.Message
+		v.ErrorKind = value.Err.Kind
+	default:
+		if e.err == nil {
+			e.err = fmt.Errorf("cannot snapshot value %s", value)
+		}
+	}
+	e.Values[index] = v
+	return index
+}
+
+// Restore replaces the state with a snapshot. The state must have been created
+// from the same program source as the one the snapshot was taken from.
+func (s *state) Restore(r io.Reader) error {
+	magic := make([]byte, len(snapshotMagic))
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return err
+	}
+	if string(magic) != snapshotMagic {
+		return errors.New("not a goscript snapshot")
+	}
+	var version uint32
+	if err := binary.Read(r, binary.BigEndian, &version); err != nil {
+		return err
+	}
+	if version != snapshotVersion {
+		return fmt.Errorf("unsupported snapshot version %d", version)
+	}
+	d := &decoder{}
+	if err := gob.NewDecoder(r).Decode(&d.snapshot); err != nil {
+		return err
+	}
+
+	// programs loaded by `evalin` are parsed again, the others must have been loaded
+	// by the state in the same order.
+	if len(d.Sources) != len(d.Programs) {
+		return errors.New("malformed snapshot")
+	}
+	programs := []ast.ExprNode{}
+	sources := make(map[ast.ExprNode]string)
+	owners := make(map[ast.ExprNode]ast.ExprNode)
+	loaded := []ast.ExprNode{}
+	for _, program := range s.programs {
+		if _, ok := s.sources[program]; !ok {
+			loaded = append(loaded, program)
+		}
+	}
+	for i, src := range d.Sources {
+		var program ast.ExprNode
+		if src != "" {
+			node, err := lexAndParse(src)
+			if err != nil {
+				return err
+			}
+			program = node
+			sources[program] = src
+			ast.Walk(program, func(n ast.ExprNode) {
+				owners[n] = program
+			})
+		} else if len(loaded) != 0 {
+			program, loaded = loaded[0], loaded[1:]
+		} else {
+			return errors.New("snapshot was taken from a different program")
+		}
+		begin := len(d.nodes)
+		ast.Walk(program, func(node ast.ExprNode) {
+			d.nodes = append(d.nodes, node)
+		})
+		if len(d.nodes)-begin != d.Programs[i] {
+			return errors.New("snapshot was taken from a different program")
+		}
+		programs = append(programs, program)
+	}
+	if len(loaded) != 0 {
+		return errors.New("snapshot was taken from a different program")
+	}
+
+	if err := d.decode(); err != nil {
+		return err
+	}
+	heap := make([]Value, len(d.Heap))
+	for i, index := range d.Heap {
+		heap[i] = d.value(index)
+	}
+	finalizers := make(map[int64]*finalizer)
+	for _, pair := range d.Finalizers {
+		value := d.value(pair[0])
+		script, ok := d.value(pair[1]).(*Closure)
+		if value == nil || !ok {
+			return errors.New("malformed snapshot")
+		}
+		finalizers[value.GetId()] = &finalizer{value: value, script: script}
+	}
+	weakrefs := []*WeakRef{}
+	for _, index := range d.WeakRefs {
+		w, ok := d.value(index).(*WeakRef)
+		if !ok {
+			return errors.New("malformed snapshot")
+		}
+		weakrefs = append(weakrefs, w)
+	}
+	stack := d.layers(d.Stack)
+	value := d.value(d.Value)
+	if d.err != nil {
+		return d.err
+	}
+	if len(stack) == 0 {
+		return errors.New("malformed snapshot")
+	}
+
+	s.programs = programs
+	s.sources = sources
+	s.owners = owners
+	s.heap = heap
+	s.stack = stack
+	s.value = value
+	s.finalizers = finalizers
+	s.pending = nil
+	s.weakrefs = weakrefs
+	s.suspended = d.Suspended
+	s.capabilities = s.sandbox()
+	return nil
+}
+
+type decoder struct {
+	snapshot
+	nodes  []ast.ExprNode
+	values []Value
+	envs   []*[]envItem
+	types  []*dataType
+	// err is set when an index does not refer to a node, value, environment or heap
+	// cell of the snapshot.
+	err error
+}
+
+func (d *decoder) decode() error {
+	d.envs = make([]*[]envItem, len(d.Envs))
+	for i, items := range d.Envs {
+		env := d.env(items)
+		d.envs[i] = &env
+	}
+
+	d.types = make([]*dataType, len(d.Types))
+	for i, index := range d.Types {
+		node, ok := d.node(index).(*ast.DataNode)
+		if !ok {
+			return errors.New("malformed snapshot")
+		}
+		d.types[i] = newDataType(node)
+	}
+
+	// allocate every value first, so that references between them can be resolved.
+	d.values = make([]Value, len(d.Values))
+	for i, v := range d.Values {
+		switch v.Kind {
+		case voidKind:
+			d.values[i] = voidValue
+		case numberKind:
+			d.values[i] = retrieveNumberValue(v.Numerator, v.Denominator)
+		case floatKind:
+			d.values[i] = NewFloat(v.Float)
+		case boolKind:
+			d.values[i] = boolValue(v.Bool)
+		case stringKind:
+			d.values[i] = retrieveStringValue(v.String)
+		case closureKind:
+			lambda, ok := d.node(v.Node).(*ast.LambdaNode)
+			if !ok {
+				return errors.New("malformed snapshot")
+			}
+			d.values[i] = NewClosure(d.env(v.Env), lambda)
+		case continuationKind:
+			d.values[i] = NewContinuation(v.Location, nil)
+		case weakRefKind:
+			d.values[i] = NewWeakRef(nil)
+		case refKind:
+			if v.Cell < 0 || v.Cell >= len(d.Heap) {
+				return errors.New("malformed snapshot")
+			}
+			d.values[i] = NewRef(v.Cell)
+		case codeKind:
+			node, err := parse(v.String)
+			if err != nil {
+				return err
+			}
+			d.values[i] = NewCode(node)
+		case intrinsicCodeKind:
+			d.values[i] = NewCode(ast.NewIntrinsicNode(v.Location, v.String))
+		case errorKind:
+			d.values[i] = NewError(&file.Error{Location: v.Location, Message: v.String, Kind: v.ErrorKind})
+		case listKind:
+			d.values[i] = NewList(make([]Value, len(v.Elems)))
+		case constructorKind, predicateKind:
+			if v.Type < 0 || v.Type >= len(d.types) || v.Variant < -1 || v.Variant >= len(d.types[v.Type].Constructors) {
+				return errors.New("malformed snapshot")
+			}
+			t := d.types[v.Type]
+			if v.Kind == predicateKind {
+				var constructor *Constructor
+				if v.Variant != -1 {
+					constructor = t.Constructors[v.Variant]
+				}
+				d.values[i] = newPredicate(t, constructor)
+			} else if v.Variant == -1 {
+				return errors.New("malformed snapshot")
+			} else {
+				d.values[i] = t.Constructors[v.Variant]
+			}
+		case dataKind:
+			d.values[i] = NewData(nil, make([]Value, len(v.Elems)))
+		case recordKind:
+			if len(v.Fields) != len(v.Elems) {
+				return errors.New("malformed snapshot")
+			}
+			d.values[i] = NewRecord(v.Fields, make([]Value, len(v.Elems)))
+		default:
+			return errors.New("malformed snapshot")
+		}
+	}
+	for i, v := range d.Values {
+		switch value := d.values[i].(type) {
+		case *Continuation:
+			value.Stack = d.layers(v.Stack)
+		case *WeakRef:
+			value.Target = d.value(v.Target)
+		case *List:
+			for j, elem := range v.Elems {
+				value.Elems[j] = d.value(elem)
+			}
+		case *Data:
+			constructor, ok := d.value(v.Target).(*Constructor)
+			if !ok {
+				return errors.New("malformed snapshot")
+			}
+			value.Constructor = constructor
+			for j, elem := range v.Elems {
+				value.Values[j] = d.value(elem)
+			}
+		case *Record:
+			for j, elem := range v.Elems {
+				value.Values[j] = d.value(elem)
+			}
+		}
+	}
+	return d.err
+}
+
+// malformed records that the snapshot refers to something it does not contain.
+func (d *decoder) malformed() {
+	if d.err == nil {
+		d.err = errors.New("malformed snapshot")
+	}
+}
+
+// node returns the node of an index, -1 standing for nil.
+func (d *decoder) node(index int) ast.ExprNode {
+	if index < -1 || index >= len(d.nodes) {
+		d.malformed()
+		return nil
+	}
+	if index == -1 {
+		return nil
+	}
+	return d.nodes[index]
+}
+
+// value returns the value of an index, -1 standing for nil.
+func (d *decoder) value(index int) Value {
+	if index < -1 || index >= len(d.values) {
+		d.malformed()
+		return nil
+	}
+	if index == -1 {
+		return nil
+	}
+	return d.values[index]
+}
+
+func (d *decoder) env(items []snapshotEnvItem) []envItem {
+	env := make([]envItem, len(items))
+	for i, item := range items {
+		if item.Location < 0 || item.Location >= len(d.Heap) {
+			d.malformed()
+		}
+		env[i] = envItem{name: item.Name, location: item.Location}
+	}
+	return env
+}
+
+func (d *decoder) layers(layers []snapshotLayer) []*layer {
+	ret := make([]*layer, len(layers))
+	for i, l := range layers {
+		if l.Env < 0 || l.Env >= len(d.envs) {
+			d.malformed()
+			return nil
+		}
+		var args []Value
+		for _, index := range l.Args {
+			args = append(args, d.value(index))
+		}
+		ret[i] = &layer{
+			env:    d.envs[l.Env],
+			frame:  l.Frame,
+			tail:   l.Tail,
+			expr:   d.node(l.Expr),
+			pc:     l.Pc,
+			args:   args,
+			callee: d.value(l.Callee),
+		}
+	}
+	return ret
+}
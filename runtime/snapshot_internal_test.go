@@ -0,0 +1,85 @@
+package runtime
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshot_malformed(t *testing.T) {
+	src := `letrec (w = (weakref lambda () { 1 }) x = 1) {
+		[(finalize lambda () { 0 } lambda (v) { (void) }) (callcc lambda (k) { k })]
+	}`
+	s := newTestState(t, src)
+	require.Nil(t, s.Execute())
+	buf := &bytes.Buffer{}
+	require.Nil(t, s.Snapshot(buf))
+	data := buf.Bytes()
+
+	decode := func() snapshot {
+		var snap snapshot
+		r := bytes.NewReader(data[len(snapshotMagic)+4:])
+		require.Nil(t, gob.NewDecoder(r).Decode(&snap))
+		return snap
+	}
+	encode := func(snap snapshot) []byte {
+		buf := &bytes.Buffer{}
+		buf.WriteString(snapshotMagic)
+		require.Nil(t, binary.Write(buf, binary.BigEndian, snapshotVersion))
+		require.Nil(t, gob.NewEncoder(buf).Encode(&snap))
+		return buf.Bytes()
+	}
+	// kind returns the index of a value of the given kind.
+	kind := func(snap snapshot, k byte) int {
+		for i, v := range snap.Values {
+			if v.Kind == k {
+				return i
+			}
+		}
+		require.Fail(t, "no value of kind %d", k)
+		return 0
+	}
+	require.Nil(t, newTestState(t, src).Restore(bytes.NewReader(encode(decode()))))
+
+	tests := map[string]func(snap *snapshot){
+		"env": func(snap *snapshot) { snap.Stack[0].Env = len(snap.Envs) },
+		"heap cell": func(snap *snapshot) {
+			snap.Envs[0] = append(snap.Envs[0], snapshotEnvItem{Name: "y", Location: len(snap.Heap)})
+		},
+		"heap value":       func(snap *snapshot) { snap.Heap[0] = len(snap.Values) },
+		"value":            func(snap *snapshot) { snap.Value = -2 },
+		"expr":             func(snap *snapshot) { snap.Stack[0].Expr = 1 << 20 },
+		"continuation":     func(snap *snapshot) { snap.Values[kind(*snap, continuationKind)].Stack[0].Env = -1 },
+		"finalized value":  func(snap *snapshot) { snap.Finalizers[0][0] = len(snap.Values) },
+		"finalizer":        func(snap *snapshot) { snap.Finalizers[0][1] = len(snap.Values) },
+		"finalizer type":   func(snap *snapshot) { snap.Finalizers[0][1] = kind(*snap, numberKind) },
+		"weak reference":   func(snap *snapshot) { snap.WeakRefs[0] = len(snap.Values) },
+		"weak ref type":    func(snap *snapshot) { snap.WeakRefs[0] = kind(*snap, numberKind) },
+		"weak ref target":  func(snap *snapshot) { snap.Values[kind(*snap, weakRefKind)].Target = len(snap.Values) },
+		"empty stack":      func(snap *snapshot) { snap.Stack = nil },
+		"closure function": func(snap *snapshot) { snap.Values[kind(*snap, closureKind)].Node = -1 },
+	}
+	for name, corrupt := range tests {
+		t.Run(name, func(t *testing.T) {
+			snap := decode()
+			corrupt(&snap)
+			err := newTestState(t, src).Restore(bytes.NewReader(encode(snap)))
+			require.NotNil(t, err)
+			assert.Equal(t, "malformed snapshot", err.Error())
+		})
+	}
+
+	t.Run("flipped bytes", func(t *testing.T) {
+		for i := range data {
+			corrupted := bytes.Clone(data)
+			corrupted[i] ^= 0xff
+			assert.NotPanics(t, func() {
+				_ = newTestState(t, src).Restore(bytes.NewReader(corrupted))
+			}, "byte %d", i)
+		}
+	})
+}
@@ -0,0 +1,204 @@
+package runtime_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/gogim1/goscript/conf"
+	"github.com/gogim1/goscript/file"
+	"github.com/gogim1/goscript/runtime"
+	. "github.com/gogim1/goscript/runtime"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshot(t *testing.T) {
+	tests := []struct {
+		input, value string
+	}{
+		{`letrec (
+			getcc = lambda () { (callcc lambda (k) { (k k) }) }
+			count = lambda (n acc) { if (eq n 0) then acc else (count (sub n 1) (add acc n)) }
+			f = lambda (x) { lambda (y) { (add x y) } }
+		) {
+			letrec (
+				c = (getcc)
+				g = (f 10)
+			) {
+				if (iscont c) then (c 5) else (g (count c 0))
+			}
+		}`, `25`},
+		{`letrec (
+			keep = lambda () { 1 }
+			strong = (weakref keep)
+			weak = (weakref lambda () { 1 })
+			Dyn = 1/2
+			get = lambda () { Dyn }
+		) {[
+			(finalize lambda () { 0 } lambda (v) { (void) })
+			(add (add ((weakget strong)) if (isvoid (weakget weak)) then 10 else 20) (get))
+		]}`, `23/2`},
+		{`letrec (x = 20) {
+			(evalin "letrec (f = lambda (y) { (add x y) }) { (f (evalin \"22\" lambda () { 0 })) }" lambda () { x })
+		}`, `42`},
+		{"letrec (c = `(add x ,(add 1 1)) x = 40 i = (codeget `(add 1 2) 0)) { (evalin `(,i 0 ,c) lambda () { x }) }", `42`},
+		{"letrec (c = `macro (m (a) { (add a 1) }) { (m x) } x = 41) { (evalin c lambda () { x }) }", `42`},
+		{`letrec (
+			f = lambda (l acc) { match l { [] then acc [x @r] if (gt x 0) then (f r (add acc x)) [_ @r] then (f r acc) } }
+		) {
+			(f (mklist 1 -2 3) 0)
+		}`, `4`},
+		{`data tree (node (left value right) leaf ()) {
+			letrec (
+				insert = lambda (t v) {
+					match t {
+						(leaf) then (node (leaf) v (leaf))
+						(node l x r) if (lt v x) then (node (insert l v) x r)
+						(node l x r) then (node l x (insert r v))
+					}
+				}
+				sum = lambda (t) { match t { (leaf) then 0 (node l x r) then (add (sum l) (add x (sum r))) } }
+				t = (insert (insert (insert (leaf) 2) 1) 3)
+			) {
+				if (istree t) then (sum t) else 0
+			}
+		}`, `6`},
+		{`letrec (
+			f = lambda (n acc) {
+				cond {
+					(and (gt n 0) (eq (div n 2) (div n 2))) then (f (sub n 1) (add acc n))
+					(or (eq n 0) (div 1 0)) then acc
+				}
+			}
+		) {
+			(f 4 0)
+		}`, `10`},
+		{`letrec (
+			count = lambda (r) { if (lt &n r 3) then (count {r | n = (add &n r 1)}) else r }
+		) {
+			(recget (count {n = 0 name = "counter"}) "n")
+		}`, `3`},
+		{`letrec (
+			f = lambda (x n) { if (gt n 0) then (f (add (mul x 1/2) 1e0) (sub n 1)) else x }
+		) {
+			(f 0.5e0 3)
+		}`, `1.8125e0`},
+		{`letrec (
+			even = lambda (n) { if (eq n 0) then true else (not (even (sub n 1))) }
+		) {
+			(mklist (even 4) (even 3) (isbool (even 0)))
+		}`, `[true false true]`},
+		{`letrec (
+			r = (ref 0)
+			k = (callcc lambda (k) { k })
+		) {[
+			(setref r (add (deref r) 1))
+			if (iscont k) then (k 1) else (deref r)
+		]}`, `2`},
+		{`letrec (
+			f = lambda (a b = (add a 1) @r) { (add (add a b) (listlen r)) }
+		) {
+			(add (f 1) (apply f 1 1 (mklist 5 6)))
+		}`, `7`},
+		{`data pair (mkpair (a b)) {
+			letrec (
+				[x {y}] = (mklist 1 {y = 2})
+				f = lambda ((mkpair a b) [c @r]) { (add (add a b) (add c (listlen r))) }
+			) {
+				(add (add x y) (f (mkpair 3 4) (mklist 5 6)))
+			}
+		}`, `16`},
+		{`let* (n = 4 f = lambda (k) { (k n) }) {
+			loop (i = 0 acc = (mklist)) {
+				if (eq i n) then (listlen acc) else (recur (add i 1) (mklist acc (callcc f)))
+			}
+		}`, `2`},
+	}
+	for _, test := range tests {
+		for _, tco := range []bool{true, false} {
+			config := conf.New(conf.EnableTCO(tco))
+			steps := 0
+			state := NewState(lexAndParse(t, test.input), config)
+			for done := false; !done; steps++ {
+				var err *file.Error
+				done, err = state.Step()
+				require.Nil(t, err)
+			}
+			require.Equal(t, test.value, state.Value().String())
+
+			for n := 0; n < steps; n++ {
+				state := NewState(lexAndParse(t, test.input), config)
+				for i := 0; i < n; i++ {
+					_, err := state.Step()
+					require.Nil(t, err)
+				}
+				buf := &bytes.Buffer{}
+				require.Nil(t, state.Snapshot(buf))
+
+				restored := NewState(lexAndParse(t, test.input), config)
+				require.Nil(t, restored.Restore(buf))
+				require.Nil(t, restored.Execute())
+				assert.Equal(t, test.value, restored.Value().String(), "snapshot after %d steps", n)
+			}
+		}
+	}
+}
+
+func TestSnapshot_interpreter(t *testing.T) {
+	src := `letrec (n = 41) { (reg "inc" lambda () { (add n 1) }) }`
+	i := runtime.NewInterpreter()
+	require.Nil(t, i.Load(src))
+	_, err := i.Execute()
+	require.Nil(t, err)
+	buf := &bytes.Buffer{}
+	require.Nil(t, i.Snapshot(buf))
+
+	restored := runtime.NewInterpreter()
+	require.Nil(t, restored.Load(src))
+	require.Nil(t, restored.Restore(buf))
+	v, err := restored.Call("inc")
+	require.Nil(t, err)
+	assert.Equal(t, `42`, v.String())
+}
+
+func TestSnapshot_error(t *testing.T) {
+	open := func(ctx *runtime.Context, args ...runtime.Value) (runtime.Value, *file.Error) {
+		return runtime.NewHostObject("file", nil), nil
+	}
+	state := NewState(lexAndParse(t, `letrec (f = (go "open")) { lambda () { f } }`), conf.New()).RegisterFunction("open", open)
+	require.Nil(t, state.Execute())
+	assert.NotNil(t, state.Snapshot(&bytes.Buffer{}))
+
+	buf := &bytes.Buffer{}
+	state = NewState(lexAndParse(t, `(add 1 2)`), conf.New())
+	require.Nil(t, state.Snapshot(buf))
+	snapshot := buf.Bytes()
+
+	state = NewState(lexAndParse(t, `(add 1 3 4)`), conf.New())
+	assert.NotNil(t, state.Restore(bytes.NewReader(snapshot)))
+
+	state = NewState(lexAndParse(t, `(add 1 2)`), conf.New())
+	assert.NotNil(t, state.Restore(bytes.NewReader(snapshot[:len(snapshot)-1])))
+	assert.NotNil(t, state.Restore(bytes.NewReader([]byte("GOSX\x00\x00\x00\x01"))))
+	assert.NotNil(t, state.Restore(bytes.NewReader([]byte("GOSS\x00\x00\x00\x02"))))
+	assert.Nil(t, state.Restore(bytes.NewReader(snapshot)))
+	require.Nil(t, state.Execute())
+	assert.Equal(t, `3`, state.Value().String())
+}
+
+func TestSnapshot_suspended(t *testing.T) {
+	wait := func(ctx *runtime.Context, args ...runtime.Value) (runtime.Value, *file.Error) {
+		return ctx.Suspend()
+	}
+	src := `(add 1 (go "wait"))`
+	state := NewState(lexAndParse(t, src), conf.New()).RegisterFunction("wait", wait)
+	require.Nil(t, state.Execute())
+	buf := &bytes.Buffer{}
+	require.Nil(t, state.Snapshot(buf))
+
+	restored := NewState(lexAndParse(t, src), conf.New())
+	require.Nil(t, restored.Restore(buf))
+	assert.True(t, restored.Suspended())
+	require.Nil(t, restored.Resume(runtime.NewNumber(41, 1)))
+	assert.Equal(t, `42`, restored.Value().String())
+}
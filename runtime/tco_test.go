@@ -0,0 +1,44 @@
+package runtime
+
+import (
+	"testing"
+
+	"github.com/gogim1/goscript/conf"
+	"github.com/gogim1/goscript/file"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestTailPosition checks that loops written with tail calls in tail position run
+// in bounded stack space with TCO, and grow the stack without it.
+func TestTailPosition(t *testing.T) {
+	srcs := []string{
+		`letrec (f = lambda (n) { (or (eq n 0) (f (sub n 1))) }) { (f 500) }`,
+		`letrec (f = lambda (n) { (and (ne n 0) (gt n -1) (f (sub n 1))) }) { (not (f 500)) }`,
+		`letrec (f = lambda (n) { cond { (eq n 0) then true (lt n 0) then false else (f (sub n 1)) } }) { (f 500) }`,
+		`letrec (f = lambda (n) { cond { (eq n 0) then true (gt n 0) then (f (sub n 1)) } }) { (f 500) }`,
+		`letrec (f = lambda (n) { if (eq n 0) then true else (apply f (mklist (sub n 1))) }) { (f 500) }`,
+		`letrec (f = lambda (n m = (sub n 1)) { if (eq n 0) then true else (f m) }) { (f 500) }`,
+		`loop (n = 500) { if (eq n 0) then true else (recur (sub n 1)) }`,
+		`letrec (f = lambda (n) { let (m = (sub n 1)) { if (eq n 0) then true else (f m) } }) { (f 500) }`,
+		`letrec (f = lambda (n) { let* (m = (sub n 1) b = (eq n 0)) { if b then true else (f m) } }) { (f 500) }`,
+		`letrec (f = lambda (n) { if (eq n 0) then true else loop (m = (sub n 1)) { (f m) } }) { (f 500) }`,
+	}
+	for _, src := range srcs {
+		t.Run(src, func(t *testing.T) {
+			depths := map[bool]int{}
+			for _, tco := range []bool{true, false} {
+				s := newTestState(t, src, conf.EnableTCO(tco))
+				for done := false; !done; {
+					var err *file.Error
+					done, err = s.Step()
+					require.Nil(t, err)
+					depths[tco] = max(depths[tco], len(s.stack))
+				}
+				assert.Equal(t, "true", s.Value().String())
+			}
+			assert.Less(t, depths[true], 20)
+			assert.Greater(t, depths[false], 500)
+		})
+	}
+}
@@ -1,9 +1,11 @@
 package runtime
 
 import (
+	"cmp"
 	"fmt"
 	"reflect"
 	"runtime"
+	"strings"
 	"unicode"
 
 	"github.com/gogim1/goscript/file"
@@ -20,6 +22,15 @@ func isLexical(str string) bool {
 	return len(str) > 0 && unicode.IsLower([]rune(str)[0])
 }
 
+func isIdentifier(str string) bool {
+	for i, char := range str {
+		if !unicode.IsLetter(char) && (i == 0 || (!unicode.IsDigit(char) && char != '_')) {
+			return false
+		}
+	}
+	return len(str) > 0
+}
+
 func filterLexical(env []envItem) []envItem {
 	newEnv := []envItem{}
 	for _, item := range env {
@@ -54,9 +65,15 @@ func lookupStack(name string, layers []*layer) int {
 	return -1
 }
 
+// deepcopy copies the layers of a stack. The barriers of synchronous calls are never
+// modified and are shared, so that a continuation can tell which calls it belongs to.
 func deepcopy(dst *[]*layer, src []*layer) {
 	*dst = make([]*layer, len(src))
 	for i, l := range src {
+		if i != 0 && l.expr == nil {
+			(*dst)[i] = l
+			continue
+		}
 		(*dst)[i] = &layer{
 			frame:  l.frame,
 			expr:   l.expr,
@@ -73,6 +90,38 @@ func deepcopy(dst *[]*layer, src []*layer) {
 	}
 }
 
+// compare compares two numbers or floats, or two strings in lexicographic order.
+// A number and a float are compared by their exact values, as `eq` does. NaN is
+// ordered before every other float.
+func compare(sl file.SourceLocation, args []Value) (int, *file.Error) {
+	if f, ok := inexact(args, 2); ok {
+		if lhs, ok := args[0].(*Number); ok {
+			return lhs.compareFloat(f[1]), nil
+		} else if rhs, ok := args[1].(*Number); ok {
+			return -rhs.compareFloat(f[0]), nil
+		}
+		return cmp.Compare(f[0], f[1]), nil
+	}
+	if len(args) == 2 {
+		switch lhs := args[0].(type) {
+		case *Number:
+			if rhs, ok := args[1].(*Number); ok {
+				if lhs.lt(rhs) {
+					return -1, nil
+				} else if rhs.lt(lhs) {
+					return 1, nil
+				}
+				return 0, nil
+			}
+		case *String:
+			if rhs, ok := args[1].(*String); ok {
+				return strings.Compare(lhs.Value, rhs.Value), nil
+			}
+		}
+	}
+	return 0, typeCheck(sl, args, []reflect.Type{NumberType, NumberType})
+}
+
 func typeCheck(sl file.SourceLocation, values []Value, types []reflect.Type) *file.Error {
 	if len(values) != len(types) {
 		return &file.Error{
@@ -23,3 +23,12 @@ func TestTypeCheck(t *testing.T) {
 	assert.Nil(t, typeCheck(file.SourceLocation{}, []Value{NewVoid()}, []reflect.Type{ValueType}))
 
 }
+
+func TestIsIdentifier(t *testing.T) {
+	assert.True(t, isIdentifier("a"))
+	assert.True(t, isIdentifier("Abc_1"))
+	assert.False(t, isIdentifier(""))
+	assert.False(t, isIdentifier("1a"))
+	assert.False(t, isIdentifier("_a"))
+	assert.False(t, isIdentifier("a b"))
+}
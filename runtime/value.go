@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"reflect"
 	"strconv"
+	"strings"
 	"sync/atomic"
 
 	"github.com/gogim1/goscript/ast"
@@ -14,6 +15,8 @@ type Value interface {
 	GetId() int64
 	SetId(int64)
 	String() string
+	// Hash is consistent with Equal: equal values have the same hash.
+	Hash() uint64
 }
 
 type Base struct {
@@ -34,8 +37,20 @@ var (
 	VoidType         = reflect.TypeOf(Void{})
 	StringType       = reflect.TypeOf(String{})
 	NumberType       = reflect.TypeOf(Number{})
+	FloatType        = reflect.TypeOf(Float{})
+	BoolType         = reflect.TypeOf(Bool{})
 	ClosureType      = reflect.TypeOf(Closure{})
 	ContinuationType = reflect.TypeOf(Continuation{})
+	HostObjectType   = reflect.TypeOf(HostObject{})
+	WeakRefType      = reflect.TypeOf(WeakRef{})
+	RefType          = reflect.TypeOf(Ref{})
+	ErrorType        = reflect.TypeOf(Error{})
+	CodeType         = reflect.TypeOf(Code{})
+	ListType         = reflect.TypeOf(List{})
+	ConstructorType  = reflect.TypeOf(Constructor{})
+	PredicateType    = reflect.TypeOf(Predicate{})
+	DataType         = reflect.TypeOf(Data{})
+	RecordType       = reflect.TypeOf(Record{})
 )
 
 type Void struct {
@@ -65,6 +80,26 @@ func (v *Number) lt(other *Number) bool {
 	return lhs < rhs
 }
 
+// Float is an inexact IEEE 754 double precision number.
+type Float struct {
+	Base
+	Value float64
+}
+
+func (v *Float) String() string {
+	return ast.FormatFloat(v.Value)
+}
+
+// Bool is a truth value, see `true` and `false`.
+type Bool struct {
+	Base
+	Value bool
+}
+
+func (v *Bool) String() string {
+	return strconv.FormatBool(v.Value)
+}
+
 type String struct {
 	Base
 	Value string
@@ -94,6 +129,185 @@ func (v *Continuation) String() string {
 	return fmt.Sprintf("<continuation evaluated at %s>", v.SourceLocation)
 }
 
+// within reports whether the continuation was captured during the synchronous call
+// stopped by barrier.
+func (v *Continuation) within(barrier *layer) bool {
+	for _, l := range v.Stack {
+		if l == barrier {
+			return true
+		}
+	}
+	return false
+}
+
+// HostObject wraps a golang value so that it can be passed through scripts untouched.
+// Scripts invoke its methods with `(go object "method" args...)`; the object itself
+// is given to the method as the first argument.
+type HostObject struct {
+	Base
+	TypeName string
+	Value    any
+	Methods  map[string]Function
+}
+
+func (v *HostObject) String() string {
+	return fmt.Sprintf("<host object %s>", v.TypeName)
+}
+
+func (v *HostObject) Method(name string, fun Function) *HostObject {
+	v.Methods[name] = fun
+	return v
+}
+
+// WeakRef refers to a value without keeping it alive. Once the value
+// has been collected the reference reads as void.
+type WeakRef struct {
+	Base
+	Target Value
+}
+
+func (v *WeakRef) String() string {
+	return "<weak reference>"
+}
+
+// Ref is a mutable cell holding a value in the heap, see `setref`.
+type Ref struct {
+	Base
+	Cell int
+}
+
+func (v *Ref) String() string {
+	return "<reference>"
+}
+
+// Error is a failure received as a value, see `evalin`.
+type Error struct {
+	Base
+	Err *file.Error
+}
+
+func (v *Error) String() string {
+	return fmt.Sprintf("<error raised at %s: %s>", v.Err.Location, v.Err.Message)
+}
+
+// Code is a syntax tree reified as a value, see quasiquotation.
+type Code struct {
+	Base
+	Node ast.ExprNode
+}
+
+func (v *Code) String() string {
+	return "<code " + ast.Format(v.Node) + ">"
+}
+
+// List is a native list of values, see `mklist`.
+type List struct {
+	Base
+	Elems []Value
+}
+
+func (v *List) String() string {
+	b := &strings.Builder{}
+	b.WriteString("[")
+	for i, elem := range v.Elems {
+		if i != 0 {
+			b.WriteString(" ")
+		}
+		b.WriteString(elem.String())
+	}
+	b.WriteString("]")
+	return b.String()
+}
+
+// dataType is a data type declared by `data`. Every evaluation of a declaration
+// creates a distinct type.
+type dataType struct {
+	Node         *ast.DataNode
+	Constructors []*Constructor
+}
+
+// Constructor builds the values of a variant of a data type when called.
+type Constructor struct {
+	Base
+	Type   *dataType
+	Name   string
+	Fields []string
+}
+
+func (v *Constructor) String() string {
+	return fmt.Sprintf("<constructor %s>", v.Name)
+}
+
+// Predicate tests whether its argument was built by a constructor of a data type,
+// or by a given constructor if any.
+type Predicate struct {
+	Base
+	Type        *dataType
+	Constructor *Constructor
+}
+
+func (v *Predicate) String() string {
+	if v.Constructor != nil {
+		return fmt.Sprintf("<predicate is%s>", v.Constructor.Name)
+	}
+	return fmt.Sprintf("<predicate is%s>", v.Type.Node.Name.Name)
+}
+
+func (v *Predicate) test(value Value) bool {
+	data, ok := value.(*Data)
+	if !ok || data.Constructor.Type != v.Type {
+		return false
+	}
+	return v.Constructor == nil || data.Constructor == v.Constructor
+}
+
+// Data is a value built by a constructor.
+type Data struct {
+	Base
+	Constructor *Constructor
+	Values      []Value
+}
+
+func (v *Data) String() string {
+	b := &strings.Builder{}
+	b.WriteString("(" + v.Constructor.Name)
+	for _, value := range v.Values {
+		b.WriteString(" " + value.String())
+	}
+	b.WriteString(")")
+	return b.String()
+}
+
+// Record is a value with named fields, see `{name = expr ...}`.
+type Record struct {
+	Base
+	Fields []string
+	Values []Value
+}
+
+func (v *Record) String() string {
+	b := &strings.Builder{}
+	b.WriteString("{")
+	for i, f := range v.Fields {
+		if i != 0 {
+			b.WriteString(" ")
+		}
+		b.WriteString(f + " = " + v.Values[i].String())
+	}
+	b.WriteString("}")
+	return b.String()
+}
+
+// get returns the value of a field of the record.
+func (v *Record) get(name string) (Value, bool) {
+	for i, f := range v.Fields {
+		if f == name {
+			return v.Values[i], true
+		}
+	}
+	return nil, false
+}
+
 var globalId int64 = 0
 
 func NewVoid() *Void {
@@ -113,6 +327,24 @@ func NewNumber(n, d int) *Number {
 	return ret
 }
 
+func NewFloat(v float64) *Float {
+	ret := &Float{
+		Value: v,
+	}
+	id := atomic.AddInt64(&globalId, 1)
+	ret.SetId(id)
+	return ret
+}
+
+func NewBool(v bool) *Bool {
+	ret := &Bool{
+		Value: v,
+	}
+	id := atomic.AddInt64(&globalId, 1)
+	ret.SetId(id)
+	return ret
+}
+
 func NewString(v string) *String {
 	ret := &String{
 		Value: v,
@@ -141,3 +373,112 @@ func NewContinuation(sl file.SourceLocation, stack []*layer) *Continuation {
 	ret.SetId(id)
 	return ret
 }
+
+func NewHostObject(typeName string, value any) *HostObject {
+	ret := &HostObject{
+		TypeName: typeName,
+		Value:    value,
+		Methods:  make(map[string]Function),
+	}
+	id := atomic.AddInt64(&globalId, 1)
+	ret.SetId(id)
+	return ret
+}
+
+func NewWeakRef(target Value) *WeakRef {
+	ret := &WeakRef{
+		Target: target,
+	}
+	id := atomic.AddInt64(&globalId, 1)
+	ret.SetId(id)
+	return ret
+}
+
+func NewRef(cell int) *Ref {
+	ret := &Ref{
+		Cell: cell,
+	}
+	id := atomic.AddInt64(&globalId, 1)
+	ret.SetId(id)
+	return ret
+}
+
+func NewError(err *file.Error) *Error {
+	ret := &Error{
+		Err: err,
+	}
+	id := atomic.AddInt64(&globalId, 1)
+	ret.SetId(id)
+	return ret
+}
+
+func NewCode(node ast.ExprNode) *Code {
+	ret := &Code{
+		Node: node,
+	}
+	id := atomic.AddInt64(&globalId, 1)
+	ret.SetId(id)
+	return ret
+}
+
+func NewList(elems []Value) *List {
+	ret := &List{
+		Elems: elems,
+	}
+	id := atomic.AddInt64(&globalId, 1)
+	ret.SetId(id)
+	return ret
+}
+
+func newDataType(node *ast.DataNode) *dataType {
+	t := &dataType{Node: node}
+	for _, variant := range node.Variants {
+		fields := []string{}
+		for _, f := range variant.Fields {
+			fields = append(fields, f.Name)
+		}
+		t.Constructors = append(t.Constructors, newConstructor(t, variant.Name.Name, fields))
+	}
+	return t
+}
+
+func newConstructor(t *dataType, name string, fields []string) *Constructor {
+	ret := &Constructor{
+		Type:   t,
+		Name:   name,
+		Fields: fields,
+	}
+	id := atomic.AddInt64(&globalId, 1)
+	ret.SetId(id)
+	return ret
+}
+
+func newPredicate(t *dataType, constructor *Constructor) *Predicate {
+	ret := &Predicate{
+		Type:        t,
+		Constructor: constructor,
+	}
+	id := atomic.AddInt64(&globalId, 1)
+	ret.SetId(id)
+	return ret
+}
+
+func NewData(constructor *Constructor, values []Value) *Data {
+	ret := &Data{
+		Constructor: constructor,
+		Values:      values,
+	}
+	id := atomic.AddInt64(&globalId, 1)
+	ret.SetId(id)
+	return ret
+}
+
+func NewRecord(fields []string, values []Value) *Record {
+	ret := &Record{
+		Fields: fields,
+		Values: values,
+	}
+	id := atomic.AddInt64(&globalId, 1)
+	ret.SetId(id)
+	return ret
+}
@@ -1,15 +1,15 @@
 letrec (
   left = lambda (value) {
-    lambda () { 0 }
+    lambda () { false }
   }
   right = lambda (value) {
-    lambda () {1}
+    lambda () { true }
   }
   isleft = lambda (either) {
-    if (not (either)) then 1 else 0
+    (not (either))
   }
   isright = lambda (either) {
-    if (isleft either) then 0 else 1
+    (not (isleft either))
   }
   fromleft = lambda (either) {
     if (isleft either) then &value either else (void)
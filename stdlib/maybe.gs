@@ -1,15 +1,15 @@
 letrec (
   nothing = lambda () {
-    lambda () { 0 }
+    lambda () { false }
   }
   just = lambda (value) {
-    lambda () {1}
+    lambda () { true }
   }
   isnothing = lambda (maybe) {
-    if (not (maybe)) then 1 else 0
+    (not (maybe))
   }
   isjust = lambda (maybe) {
-    if (isnothing maybe) then 0 else 1
+    (not (isnothing maybe))
   }
   fromjust = lambda (maybe) {
     if (isjust maybe) then &value maybe else (void)